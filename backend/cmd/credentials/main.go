@@ -0,0 +1,50 @@
+// credentials 管理 API 配置凭证的加密
+//
+// 用法:
+//
+//	go run ./cmd/credentials migrate  加密数据库中遗留的明文凭证
+//	go run ./cmd/credentials rotate   使用当前主密钥（CREDENTIAL_ACTIVE_KEY_ID）重新加密所有凭证
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/database"
+	"ai-chat-backend/services"
+	"ai-chat-backend/utils"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "用法: credentials <migrate|rotate>")
+		os.Exit(2)
+	}
+
+	config.LoadConfig()
+	if err := utils.InitCredentialKeys(); err != nil {
+		log.Fatal("Failed to load credential keys:", err)
+	}
+	database.InitDB()
+
+	switch os.Args[1] {
+	case "migrate":
+		count, err := services.EncryptPlaintextCredentials()
+		if err != nil {
+			log.Fatal("Failed to encrypt plaintext credentials:", err)
+		}
+		log.Printf("Encrypted %d plaintext credentials", count)
+	case "rotate":
+		keyID, _ := utils.ActiveCredentialKeyID()
+		count, err := services.RotateCredentialKeys()
+		if err != nil {
+			log.Fatal("Failed to rotate credential keys:", err)
+		}
+		log.Printf("Re-encrypted %d credentials with key %s", count, keyID)
+	default:
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n", os.Args[1])
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,105 @@
+// tokenizer-ranks 下载分词器词表，并按 SHA256SUMS 中固定的校验和验证
+//
+// 用法:
+//
+//	go generate ./tokenizer
+//	go run ./cmd/tokenizer-ranks tokenizer/ranks
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ranksBaseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "用法: tokenizer-ranks <词表目录>")
+		os.Exit(2)
+	}
+	dir := os.Args[1]
+
+	checksums, err := readChecksums(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		log.Fatal("Failed to read checksums:", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	for _, entry := range checksums {
+		path := filepath.Join(dir, entry.name)
+		if data, err := os.ReadFile(path); err == nil {
+			if sha256Hex(data) == entry.sum {
+				continue
+			}
+			log.Printf("%s checksum mismatch, downloading again", entry.name)
+		}
+
+		data, err := download(client, ranksBaseURL+entry.name)
+		if err != nil {
+			log.Fatalf("Failed to download %s: %v", entry.name, err)
+		}
+		if sum := sha256Hex(data); sum != entry.sum {
+			log.Fatalf("Checksum mismatch for %s: expected %s, got %s", entry.name, entry.sum, sum)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			log.Fatalf("Failed to write %s: %v", entry.name, err)
+		}
+		log.Printf("Downloaded %s", entry.name)
+	}
+}
+
+type checksum struct {
+	name string
+	sum  string
+}
+
+// readChecksums 读取 sha256sum 格式的校验和文件
+func readChecksums(path string) ([]checksum, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksums []checksum
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("格式错误: %q", scanner.Text())
+		}
+		checksums = append(checksums, checksum{name: fields[1], sum: strings.ToLower(fields[0])})
+	}
+	return checksums, scanner.Err()
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
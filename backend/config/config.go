@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -23,6 +24,28 @@ type Config struct {
 	JWTSecret      string
 	JWTExpireHours int
 	CORSOrigins    string
+	// 凭证加密主密钥，格式：id1:base64密钥,id2:base64密钥
+	CredentialMasterKeys  string
+	CredentialActiveKeyID string
+	// 模型请求的超时和重试配置
+	AIConnectTimeout        time.Duration // 建立连接超时
+	AIResponseHeaderTimeout time.Duration // 等待响应头超时（流式请求同样适用）
+	AIRequestTimeout        time.Duration // 非流式请求的总超时
+	AIMaxRetries            int           // 429 / 5xx / 网络错误时的最大重试次数（不含首次请求）
+	AIRetryBaseDelay        time.Duration // 指数退避的初始间隔
+	AIRetryMaxDelay         time.Duration // 单次退避的最大间隔，Retry-After 超过该值时直接切换备用配置
+	// 文件上传配置
+	StorageBackend   string // 文件存储后端：local
+	UploadDir        string // 本地存储目录
+	MaxUploadSize    int64  // 单个文件的大小上限（字节）
+	UserStorageQuota int64  // 每个用户的存储空间上限（字节）
+	// 向量缓存目录，以内容哈希为键缓存 embeddings 结果，为空时不缓存
+	EmbeddingCacheDir string
+	// 自定义代码智能体的脚本运行限制
+	ScriptTimeout       time.Duration // 单次执行的总时长（包括调用模型和工具的时间），同时决定脚本进程的 CPU 时间上限
+	ScriptMaxSteps      uint64        // 解释器执行步数上限，限制 CPU 消耗
+	ScriptMaxMemory     int64         // 脚本进程的内存上限（字节），为 0 时不限制
+	ScriptMaxModelCalls int           // 单次执行中调用模型的次数上限
 }
 
 var AppConfig *Config
@@ -35,6 +58,12 @@ func LoadConfig() {
 
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	jwtExpire, _ := strconv.Atoi(getEnv("JWT_EXPIRE_HOURS", "24"))
+	aiMaxRetries, _ := strconv.Atoi(getEnv("AI_MAX_RETRIES", "2"))
+	maxUploadMB, _ := strconv.ParseInt(getEnv("MAX_UPLOAD_SIZE_MB", "20"), 10, 64)
+	userQuotaMB, _ := strconv.ParseInt(getEnv("USER_STORAGE_QUOTA_MB", "200"), 10, 64)
+	scriptMaxSteps, _ := strconv.ParseUint(getEnv("SCRIPT_MAX_STEPS", "10000000"), 10, 64)
+	scriptMaxMemoryMB, _ := strconv.ParseInt(getEnv("SCRIPT_MAX_MEMORY_MB", "128"), 10, 64)
+	scriptMaxModelCalls, _ := strconv.Atoi(getEnv("SCRIPT_MAX_MODEL_CALLS", "20"))
 
 	AppConfig = &Config{
 		ServerPort:     getEnv("SERVER_PORT", "8080"),
@@ -51,6 +80,28 @@ func LoadConfig() {
 		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key-change-this"),
 		JWTExpireHours: jwtExpire,
 		CORSOrigins:    getEnv("CORS_ORIGINS", "http://localhost:3000"),
+
+		CredentialMasterKeys:  getEnv("CREDENTIAL_MASTER_KEYS", ""),
+		CredentialActiveKeyID: getEnv("CREDENTIAL_ACTIVE_KEY_ID", ""),
+
+		AIConnectTimeout:        getDuration("AI_CONNECT_TIMEOUT", 10*time.Second),
+		AIResponseHeaderTimeout: getDuration("AI_RESPONSE_HEADER_TIMEOUT", 60*time.Second),
+		AIRequestTimeout:        getDuration("AI_REQUEST_TIMEOUT", 120*time.Second),
+		AIMaxRetries:            aiMaxRetries,
+		AIRetryBaseDelay:        getDuration("AI_RETRY_BASE_DELAY", 500*time.Millisecond),
+		AIRetryMaxDelay:         getDuration("AI_RETRY_MAX_DELAY", 10*time.Second),
+
+		StorageBackend:   getEnv("STORAGE_BACKEND", "local"),
+		UploadDir:        getEnv("UPLOAD_DIR", "./uploads"),
+		MaxUploadSize:    maxUploadMB << 20,
+		UserStorageQuota: userQuotaMB << 20,
+
+		EmbeddingCacheDir: getEnv("EMBEDDING_CACHE_DIR", ""),
+
+		ScriptTimeout:       getDuration("SCRIPT_TIMEOUT", 30*time.Second),
+		ScriptMaxSteps:      scriptMaxSteps,
+		ScriptMaxMemory:     scriptMaxMemoryMB << 20,
+		ScriptMaxModelCalls: scriptMaxModelCalls,
 	}
 }
 
@@ -61,3 +112,12 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getDuration 读取时长配置，例如 30s、500ms
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"fmt"
+	"strings"
+
 	"ai-chat-backend/database"
 	"ai-chat-backend/middleware"
 	"ai-chat-backend/models"
@@ -57,12 +60,28 @@ func (agc *AgentController) Create(c *gin.Context) {
 			return
 		}
 	}
+	if err := validateFallbacks(req.Fallbacks, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateContextConfig(req.ContextConfig); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateRetrieval(req.Retrieval, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 
 	// 如果没有指定 WorkflowType，默认为 simple
 	workflowType := req.WorkflowType
 	if workflowType == "" {
 		workflowType = models.WorkflowSimple
 	}
+	if err := validateCustomCode(workflowType, req.CustomCode); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 
 	agent := models.Agent{
 		UserID:             userID,
@@ -74,10 +93,14 @@ func (agc *AgentController) Create(c *gin.Context) {
 		ModelName:          req.ModelName,
 		ModelParams:        req.ModelParams,
 		Tools:              req.Tools,
+		Fallbacks:          req.Fallbacks,
+		ContextConfig:      req.ContextConfig,
+		Retrieval:          req.Retrieval,
 		IsPublic:           req.IsPublic,
 		WorkflowType:       workflowType,
 		WorkflowDefinition: req.WorkflowDefinition,
 		TemplateID:         req.TemplateID,
+		CustomCode:         req.CustomCode,
 	}
 
 	if err := database.DB.Create(&agent).Error; err != nil {
@@ -136,6 +159,18 @@ func (agc *AgentController) Update(c *gin.Context) {
 			return
 		}
 	}
+	if err := validateFallbacks(req.Fallbacks, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateContextConfig(req.ContextConfig); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if err := validateRetrieval(req.Retrieval, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 
 	agent.Name = req.Name
 	agent.Description = req.Description
@@ -145,14 +180,22 @@ func (agc *AgentController) Update(c *gin.Context) {
 	agent.ModelName = req.ModelName
 	agent.ModelParams = req.ModelParams
 	agent.Tools = req.Tools
+	agent.Fallbacks = req.Fallbacks
+	agent.ContextConfig = req.ContextConfig
+	agent.Retrieval = req.Retrieval
 	agent.IsPublic = req.IsPublic
 
 	// 更新工作流相关字段
 	if req.WorkflowType != "" {
 		agent.WorkflowType = req.WorkflowType
 	}
+	if err := validateCustomCode(agent.WorkflowType, req.CustomCode); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 	agent.WorkflowDefinition = req.WorkflowDefinition
 	agent.TemplateID = req.TemplateID
+	agent.CustomCode = req.CustomCode
 
 	if err := database.DB.Save(&agent).Error; err != nil {
 		utils.InternalServerError(c, "更新智能体失败")
@@ -257,3 +300,76 @@ func (agc *AgentController) CreateFromWorkflow(c *gin.Context) {
 
 	utils.SuccessWithMessage(c, "从工作流创建成功", agent.ToResponse())
 }
+
+// validateFallbacks 验证备用配置中的API配置属于当前用户
+func validateFallbacks(fallbacks models.AgentFallbacks, userID uint) error {
+	for i, fallback := range fallbacks {
+		if fallback.APIConfigID == nil && fallback.ModelName == "" {
+			return fmt.Errorf("第 %d 个备用配置需要指定API配置或模型", i+1)
+		}
+		if fallback.APIConfigID != nil {
+			var apiConfig models.APIConfig
+			if err := database.DB.Where("id = ? AND user_id = ?", *fallback.APIConfigID, userID).First(&apiConfig).Error; err != nil {
+				return fmt.Errorf("第 %d 个备用配置的API配置不存在或无权访问", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// validateContextConfig 验证上下文窗口配置
+func validateContextConfig(cfg models.ContextConfig) error {
+	switch cfg.Strategy {
+	case "", models.ContextNone, models.ContextSlidingWindow, models.ContextKeepFirstLast, models.ContextSummarize:
+	default:
+		return fmt.Errorf("不支持的上下文策略: %s", cfg.Strategy)
+	}
+	if cfg.KeepFirst < 0 || cfg.KeepLast < 0 {
+		return fmt.Errorf("保留的消息数不能为负数")
+	}
+	return nil
+}
+
+// validateRetrieval 校验知识库检索配置：知识库必须属于当前用户
+func validateRetrieval(cfg models.RetrievalConfig, userID uint) error {
+	if cfg.TopK < 0 || cfg.TopK > services.MaxRetrievalTopK {
+		return fmt.Errorf("检索分段数必须在 0 到 %d 之间", services.MaxRetrievalTopK)
+	}
+	if cfg.MinScore < -1 || cfg.MinScore > 1 {
+		return fmt.Errorf("相似度下限必须在 -1 到 1 之间")
+	}
+	if len(cfg.KnowledgeBaseIDs) == 0 {
+		return nil
+	}
+
+	var count int64
+	database.DB.Model(&models.KnowledgeBase{}).Where("id IN ? AND user_id = ?", cfg.KnowledgeBaseIDs, userID).Count(&count)
+	if int(count) != len(uniqueIDs(cfg.KnowledgeBaseIDs)) {
+		return fmt.Errorf("知识库不存在或无权访问")
+	}
+	return nil
+}
+
+// validateCustomCode 自定义代码智能体的脚本必须能够编译并定义入口函数 main
+func validateCustomCode(workflowType models.WorkflowType, code string) error {
+	if workflowType != models.WorkflowCode {
+		return nil
+	}
+	if strings.TrimSpace(code) == "" {
+		return fmt.Errorf("自定义代码智能体需要填写脚本")
+	}
+	return services.ValidateScript(code)
+}
+
+// uniqueIDs 去重
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"errors"
+
 	"ai-chat-backend/database"
 	"ai-chat-backend/middleware"
 	"ai-chat-backend/models"
+	"ai-chat-backend/services"
 	"ai-chat-backend/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -38,13 +41,33 @@ func (acc *APIConfigController) Create(c *gin.Context) {
 		return
 	}
 
+	if req.Credentials == "" && req.AuthType != models.AuthNone {
+		utils.BadRequest(c, "请填写API密钥")
+		return
+	}
+	if err := validateAPIConfigRequest(req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	var credentials string
+	if req.Credentials != "" && req.AuthType != models.AuthNone {
+		encrypted, err := utils.EncryptCredential(req.Credentials)
+		if err != nil {
+			utils.InternalServerError(c, "加密凭证失败")
+			return
+		}
+		credentials = encrypted
+	}
+
 	config := models.APIConfig{
 		UserID:       userID,
 		Name:         req.Name,
 		APIType:      req.APIType,
 		EndpointURL:  req.EndpointURL,
 		AuthType:     req.AuthType,
-		Credentials:  req.Credentials, // TODO: 应该加密存储
+		Credentials:  credentials,
+		AuthSpec:     req.AuthSpec,
 		FieldMapping: req.FieldMapping,
 		IsActive:     req.IsActive,
 	}
@@ -87,13 +110,32 @@ func (acc *APIConfigController) Update(c *gin.Context) {
 		utils.BadRequest(c, "请求参数错误")
 		return
 	}
+	if err := validateAPIConfigRequest(req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	// 从无需认证改为其他认证方式时需要重新填写密钥
+	if req.Credentials == "" && req.AuthType != models.AuthNone && config.Credentials == "" {
+		utils.BadRequest(c, "请填写API密钥")
+		return
+	}
 
 	config.Name = req.Name
 	config.APIType = req.APIType
 	config.EndpointURL = req.EndpointURL
 	config.AuthType = req.AuthType
-	if req.Credentials != "" {
-		config.Credentials = req.Credentials
+	config.AuthSpec = req.AuthSpec.RestoreRedacted(config.AuthSpec)
+	switch {
+	case req.AuthType == models.AuthNone:
+		// 不再使用密钥时清除已保存的凭证
+		config.Credentials = ""
+	case req.Credentials != "":
+		credentials, err := utils.EncryptCredential(req.Credentials)
+		if err != nil {
+			utils.InternalServerError(c, "加密凭证失败")
+			return
+		}
+		config.Credentials = credentials
 	}
 	config.FieldMapping = req.FieldMapping
 	config.IsActive = req.IsActive
@@ -125,3 +167,68 @@ func (acc *APIConfigController) Delete(c *gin.Context) {
 	utils.SuccessWithMessage(c, "删除成功", nil)
 }
 
+// Test 使用已保存的API配置发送测试请求
+func (acc *APIConfigController) Test(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	configID := c.Param("id")
+
+	var config models.APIConfig
+	if err := database.DB.Where("id = ? AND user_id = ?", configID, userID).First(&config).Error; err != nil {
+		utils.NotFound(c, "配置不存在")
+		return
+	}
+
+	var req models.APIConfigTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+
+	utils.Success(c, services.NewAIService().TestConfig(c.Request.Context(), &config, req.ModelName, req.Prompt))
+}
+
+// TestUnsaved 使用未保存的API配置发送测试请求
+func (acc *APIConfigController) TestUnsaved(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.APIConfigTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+	if req.Config == nil {
+		utils.BadRequest(c, "请提供要测试的配置（config）")
+		return
+	}
+	if err := validateAPIConfigRequest(*req.Config); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	// 未保存的配置直接使用明文凭证，不写入数据库
+	config := models.APIConfig{
+		UserID:       userID,
+		Name:         req.Config.Name,
+		APIType:      req.Config.APIType,
+		EndpointURL:  req.Config.EndpointURL,
+		AuthType:     req.Config.AuthType,
+		Credentials:  req.Config.Credentials,
+		AuthSpec:     req.Config.AuthSpec,
+		FieldMapping: req.Config.FieldMapping,
+		IsActive:     true,
+	}
+
+	utils.Success(c, services.NewAIService().TestConfig(c.Request.Context(), &config, req.ModelName, req.Prompt))
+}
+
+// validateAPIConfigRequest 校验自定义认证和字段映射配置
+// 自定义认证至少需要配置一个请求头或查询参数
+func validateAPIConfigRequest(req models.APIConfigRequest) error {
+	if req.AuthType == models.AuthCustom && len(req.AuthSpec.Headers) == 0 && len(req.AuthSpec.QueryParams) == 0 {
+		return errors.New("自定义认证需要配置请求头或查询参数（auth_spec）")
+	}
+	if err := services.ValidateRequestMapping(req.FieldMapping.RequestMapping); err != nil {
+		return err
+	}
+	return services.ValidateResponseMapping(req.FieldMapping.ResponseMapping)
+}
@@ -4,6 +4,7 @@ import (
 	"ai-chat-backend/database"
 	"ai-chat-backend/middleware"
 	"ai-chat-backend/models"
+	"ai-chat-backend/services"
 	"ai-chat-backend/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -31,7 +32,7 @@ func (cc *ConversationController) List(c *gin.Context) {
 	for _, conv := range conversations {
 		// 获取消息数量
 		var messageCount int64
-		database.DB.Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Count(&messageCount)
+		database.DB.Model(&models.Message{}).Where("conversation_id = ? AND role <> ?", conv.ID, models.RoleSystem).Count(&messageCount)
 		
 		resp := conv.ToResponse()
 		resp.MessageCount = int(messageCount)
@@ -169,15 +170,18 @@ func (cc *ConversationController) GetMessages(c *gin.Context) {
 		return
 	}
 
-	var messages []models.Message
-	if err := database.DB.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages).Error; err != nil {
+	// 只返回当前分支的消息（上下文摘要以 system 消息保存，不在对话中展示），并附带兄弟分支信息
+	tree := services.NewMessageTreeService()
+	messages, err := tree.ActiveBranch(&conversation)
+	if err != nil {
 		utils.InternalServerError(c, "获取消息列表失败")
 		return
 	}
 
-	var responses []models.MessageResponse
-	for _, msg := range messages {
-		responses = append(responses, msg.ToResponse())
+	responses, err := tree.Responses(conversation.ID, messages)
+	if err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
 	}
 
 	utils.Success(c, responses)
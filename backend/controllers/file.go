@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/middleware"
+	"ai-chat-backend/models"
+	"ai-chat-backend/services"
+	"ai-chat-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type FileController struct{}
+
+// Upload 上传文件（multipart 表单字段 file），文档类文件会提取文本供对话附件使用
+func (fc *FileController) Upload(c *gin.Context) {
+	file, ok := uploadFormFile(c)
+	if !ok {
+		return
+	}
+	utils.SuccessWithMessage(c, "上传成功", file.ToResponse())
+}
+
+// List 获取文件列表和存储空间使用情况
+func (fc *FileController) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	fileService := services.NewFileService()
+
+	files, err := fileService.List(userID)
+	if err != nil {
+		utils.InternalServerError(c, "获取文件列表失败")
+		return
+	}
+	used, err := fileService.UsedBytes(userID)
+	if err != nil {
+		utils.InternalServerError(c, "获取文件列表失败")
+		return
+	}
+
+	responses := make([]models.FileResponse, 0, len(files))
+	for _, file := range files {
+		responses = append(responses, file.ToResponse())
+	}
+
+	utils.Success(c, models.FileListResponse{
+		Files:     responses,
+		UsedBytes: used,
+		Quota:     config.AppConfig.UserStorageQuota,
+	})
+}
+
+// Get 获取文件信息
+func (fc *FileController) Get(c *gin.Context) {
+	file, ok := loadFile(c)
+	if !ok {
+		return
+	}
+	utils.Success(c, file.ToResponse())
+}
+
+// Download 下载文件内容
+func (fc *FileController) Download(c *gin.Context) {
+	file, ok := loadFile(c)
+	if !ok {
+		return
+	}
+
+	data, err := services.NewFileService().Content(file)
+	if err != nil {
+		utils.InternalServerError(c, "读取文件失败")
+		return
+	}
+
+	c.Header("Content-Disposition", "inline; filename*=UTF-8''"+url.PathEscape(file.Name))
+	c.Data(http.StatusOK, file.MimeType, data)
+}
+
+// Delete 删除文件
+func (fc *FileController) Delete(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.NotFound(c, "文件不存在")
+		return
+	}
+
+	if err := services.NewFileService().Delete(userID, uint(fileID)); err != nil {
+		utils.NotFound(c, "文件不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// loadFile 加载路径参数 id 对应的当前用户的文件，不存在时写入 404 响应
+func loadFile(c *gin.Context) (*models.File, bool) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.NotFound(c, "文件不存在")
+		return nil, false
+	}
+
+	file, err := services.NewFileService().Get(middleware.GetUserID(c), uint(fileID))
+	if err != nil {
+		utils.NotFound(c, "文件不存在")
+		return nil, false
+	}
+	return file, true
+}
+
+// uploadFormFile 保存 multipart 表单字段 file 中的文件，失败时写入错误响应
+func uploadFormFile(c *gin.Context) (*models.File, bool) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequest(c, "请通过 file 字段上传文件")
+		return nil, false
+	}
+	if maxSize := config.AppConfig.MaxUploadSize; maxSize > 0 && header.Size > maxSize {
+		utils.ErrorWithStatus(c, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, fmt.Sprintf("文件超过 %d MB", maxSize>>20))
+		return nil, false
+	}
+
+	reader, err := header.Open()
+	if err != nil {
+		utils.BadRequest(c, "读取上传文件失败")
+		return nil, false
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		utils.BadRequest(c, "读取上传文件失败")
+		return nil, false
+	}
+
+	file, err := services.NewFileService().Upload(middleware.GetUserID(c), header.Filename, data)
+	if err != nil {
+		var rejected *services.FileRejectedError
+		if errors.As(err, &rejected) {
+			utils.BadRequest(c, rejected.Reason)
+			return nil, false
+		}
+		utils.InternalServerError(c, "保存文件失败")
+		return nil, false
+	}
+	return file, true
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/middleware"
+	"ai-chat-backend/models"
+	"ai-chat-backend/services"
+	"ai-chat-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type KnowledgeBaseController struct{}
+
+// List 获取知识库列表
+func (kc *KnowledgeBaseController) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var knowledgeBases []models.KnowledgeBase
+	if err := database.DB.Where("user_id = ?", userID).Order("updated_at DESC").Find(&knowledgeBases).Error; err != nil {
+		utils.InternalServerError(c, "获取知识库列表失败")
+		return
+	}
+
+	responses := make([]models.KnowledgeBaseResponse, 0, len(knowledgeBases))
+	for _, kb := range knowledgeBases {
+		responses = append(responses, kb.ToResponse())
+	}
+
+	utils.Success(c, responses)
+}
+
+// Create 创建知识库
+func (kc *KnowledgeBaseController) Create(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.KnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+	if err := validateKnowledgeBaseRequest(&req, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	kb := models.KnowledgeBase{
+		UserID:         userID,
+		Name:           req.Name,
+		Description:    req.Description,
+		APIConfigID:    req.APIConfigID,
+		EmbeddingModel: req.EmbeddingModel,
+		ChunkSize:      req.ChunkSize,
+		ChunkOverlap:   req.ChunkOverlap,
+	}
+	if err := database.DB.Create(&kb).Error; err != nil {
+		utils.InternalServerError(c, "创建知识库失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "创建成功", kb.ToResponse())
+}
+
+// Get 获取知识库详情（包含文档列表）
+func (kc *KnowledgeBaseController) Get(c *gin.Context) {
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+
+	var documents []models.KnowledgeDocument
+	if err := database.DB.Where("knowledge_base_id = ?", kb.ID).Order("created_at DESC").Find(&documents).Error; err != nil {
+		utils.InternalServerError(c, "获取文档列表失败")
+		return
+	}
+
+	response := kb.ToResponse()
+	response.Documents = documents
+	utils.Success(c, response)
+}
+
+// Update 更新知识库，已有分段时不能更换嵌入模型（向量不可比较）
+func (kc *KnowledgeBaseController) Update(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+
+	var req models.KnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+	if err := validateKnowledgeBaseRequest(&req, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if kb.DocumentCount > 0 && (req.EmbeddingModel != kb.EmbeddingModel || !sameAPIConfig(req.APIConfigID, kb.APIConfigID)) {
+		utils.BadRequest(c, "知识库中已有文档，不能更换嵌入模型或 API 配置")
+		return
+	}
+
+	kb.Name = req.Name
+	kb.Description = req.Description
+	kb.APIConfigID = req.APIConfigID
+	kb.EmbeddingModel = req.EmbeddingModel
+	kb.ChunkSize = req.ChunkSize
+	kb.ChunkOverlap = req.ChunkOverlap
+	if kb.DocumentCount == 0 {
+		kb.Dimensions = 0
+	}
+
+	if err := database.DB.Save(kb).Error; err != nil {
+		utils.InternalServerError(c, "更新知识库失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "更新成功", kb.ToResponse())
+}
+
+// Delete 删除知识库及其文档和分段（不删除上传的原始文件）
+func (kc *KnowledgeBaseController) Delete(c *gin.Context) {
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+
+	if err := knowledgeBaseService().DeleteKnowledgeBase(c.Request.Context(), kb); err != nil {
+		utils.InternalServerError(c, "删除知识库失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// AddDocument 向知识库添加文档：multipart 上传新文件（字段 file），或 JSON 指定已上传的 file_id
+// 文档在后台切分和计算向量，状态变为 ready 后可被检索
+func (kc *KnowledgeBaseController) AddDocument(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+
+	var file *models.File
+	if c.ContentType() == "multipart/form-data" {
+		if file, ok = uploadFormFile(c); !ok {
+			return
+		}
+	} else {
+		var req models.KnowledgeDocumentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequest(c, "请求参数错误: "+err.Error())
+			return
+		}
+		var err error
+		if file, err = services.NewFileService().Get(userID, req.FileID); err != nil {
+			utils.BadRequest(c, "文件不存在")
+			return
+		}
+	}
+	if file.Kind != models.FileKindDocument {
+		utils.BadRequest(c, "只能将文档（PDF、DOCX、TXT、Markdown、CSV）加入知识库")
+		return
+	}
+
+	document, err := knowledgeBaseService().AddDocument(kb, file)
+	if errors.Is(err, services.ErrIndexQueueFull) {
+		utils.ErrorWithStatus(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(c, "添加文档失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "文档已加入知识库，正在建立索引", document)
+}
+
+// DeleteDocument 从知识库中删除文档
+func (kc *KnowledgeBaseController) DeleteDocument(c *gin.Context) {
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+	documentID, err := strconv.ParseUint(c.Param("document_id"), 10, 64)
+	if err != nil {
+		utils.NotFound(c, "文档不存在")
+		return
+	}
+
+	if err := knowledgeBaseService().DeleteDocument(c.Request.Context(), kb, uint(documentID)); err != nil {
+		utils.NotFound(c, "文档不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// Search 在知识库中检索，用于调试检索效果
+func (kc *KnowledgeBaseController) Search(c *gin.Context) {
+	kb, ok := loadKnowledgeBase(c)
+	if !ok {
+		return
+	}
+
+	var req models.KnowledgeSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+	topK := req.TopK
+	if topK <= 0 {
+		topK = services.DefaultRetrievalTopK
+	}
+	if topK > services.MaxRetrievalTopK {
+		topK = services.MaxRetrievalTopK
+	}
+
+	chunks, err := knowledgeBaseService().Search(c.Request.Context(), []models.KnowledgeBase{*kb}, req.Query, topK)
+	if err != nil {
+		if c.Request.Context().Err() == context.Canceled {
+			return
+		}
+		utils.InternalServerError(c, "检索失败: "+err.Error())
+		return
+	}
+	if chunks == nil {
+		chunks = []services.RetrievedChunk{}
+	}
+
+	utils.Success(c, chunks)
+}
+
+func knowledgeBaseService() *services.KnowledgeBaseService {
+	return services.NewKnowledgeBaseService(services.NewAIService())
+}
+
+// loadKnowledgeBase 加载路径参数 id 对应的当前用户的知识库，不存在时写入 404 响应
+func loadKnowledgeBase(c *gin.Context) (*models.KnowledgeBase, bool) {
+	var kb models.KnowledgeBase
+	if err := database.DB.Preload("APIConfig").Where("id = ? AND user_id = ?", c.Param("id"), middleware.GetUserID(c)).
+		First(&kb).Error; err != nil {
+		utils.NotFound(c, "知识库不存在")
+		return nil, false
+	}
+	return &kb, true
+}
+
+// sameAPIConfig 比较两个可能为空的 API 配置 ID（配置被删除后知识库的 APIConfigID 为空）
+func sameAPIConfig(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// validateKnowledgeBaseRequest 校验 API 配置归属和分段参数，并填充默认值
+func validateKnowledgeBaseRequest(req *models.KnowledgeBaseRequest, userID uint) error {
+	var count int64
+	database.DB.Model(&models.APIConfig{}).Where("id = ? AND user_id = ?", *req.APIConfigID, userID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("API配置不存在")
+	}
+
+	if req.ChunkSize == 0 {
+		req.ChunkSize = services.DefaultChunkSize
+	}
+	if req.ChunkOverlap == 0 && req.ChunkSize > services.DefaultChunkOverlap {
+		req.ChunkOverlap = services.DefaultChunkOverlap
+	}
+	if req.ChunkSize < 100 || req.ChunkSize > services.MaxChunkSize {
+		return fmt.Errorf("分段长度必须在 100 到 %d 之间", services.MaxChunkSize)
+	}
+	if req.ChunkOverlap < 0 || req.ChunkOverlap >= req.ChunkSize {
+		return fmt.Errorf("分段重叠长度必须小于分段长度")
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 package controllers
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -10,6 +10,7 @@ import (
 	"ai-chat-backend/middleware"
 	"ai-chat-backend/models"
 	"ai-chat-backend/services"
+	"ai-chat-backend/tokenizer"
 	"ai-chat-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -34,10 +35,21 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 		utils.BadRequest(c, "请求参数错误: "+err.Error())
 		return
 	}
+	if !checkAttachments(c, userID, req.Attachments) {
+		return
+	}
+
+	// 新消息接在当前分支的最后一条消息之后
+	tree := services.NewMessageTreeService()
+	if err := tree.EnsureTree(&conversation); err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
 
 	// 保存用户消息
 	userMessage := models.Message{
 		ConversationID: conversation.ID,
+		ParentID:       conversation.ActiveMessageID,
 		Role:           models.RoleUser,
 		Content:        req.Content,
 		Attachments:    req.Attachments,
@@ -47,40 +59,17 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 		utils.InternalServerError(c, "保存消息失败")
 		return
 	}
-
-	// 获取历史消息
-	var messages []models.Message
-	database.DB.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages)
-
-	// 调用 Eino 服务（会根据 Agent 的 WorkflowType 自动选择执行方式）
-	einoService := services.NewEinoService()
-	response, inputTokens, outputTokens, err := einoService.ExecuteAgent(c.Request.Context(), conversation.Agent, messages)
-	if err != nil {
-		utils.InternalServerError(c, "AI服务调用失败: "+err.Error())
+	if err := tree.SetActive(&conversation, userMessage.ID); err != nil {
+		utils.InternalServerError(c, "保存消息失败")
 		return
 	}
 
-	// 保存AI回复
-	assistantMessage := models.Message{
-		ConversationID: conversation.ID,
-		Role:           models.RoleAssistant,
-		Content:        response,
-		InputTokens:    inputTokens,
-		OutputTokens:   outputTokens,
-	}
-
-	if err := database.DB.Create(&assistantMessage).Error; err != nil {
-		utils.InternalServerError(c, "保存AI回复失败")
+	assistantMessage, err := generateReply(c, userID, &conversation, &userMessage)
+	if err != nil {
+		replyError(c, err)
 		return
 	}
 
-	// 更新对话统计
-	conversation.TotalTokens += inputTokens + outputTokens
-	database.DB.Save(&conversation)
-
-	// 更新Token使用统计
-	services.UpdateTokenUsage(userID, conversation.AgentID, conversation.ID, inputTokens, outputTokens)
-
 	utils.Success(c, gin.H{
 		"user_message":      userMessage.ToResponse(),
 		"assistant_message": assistantMessage.ToResponse(),
@@ -88,6 +77,7 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 }
 
 // StreamMessage 流式发送消息（SSE）
+// 生成在后台进行，连接断开后可以通过 ResumeStream 携带最后收到的事件 ID 重新连接
 func (mc *MessageController) StreamMessage(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	conversationID := c.Param("id")
@@ -104,10 +94,21 @@ func (mc *MessageController) StreamMessage(c *gin.Context) {
 		utils.BadRequest(c, "请求参数错误: "+err.Error())
 		return
 	}
+	if !checkAttachments(c, userID, req.Attachments) {
+		return
+	}
+
+	// 新消息接在当前分支的最后一条消息之后
+	tree := services.NewMessageTreeService()
+	if err := tree.EnsureTree(&conversation); err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
 
 	// 保存用户消息
 	userMessage := models.Message{
 		ConversationID: conversation.ID,
+		ParentID:       conversation.ActiveMessageID,
 		Role:           models.RoleUser,
 		Content:        req.Content,
 		Attachments:    req.Attachments,
@@ -117,78 +118,360 @@ func (mc *MessageController) StreamMessage(c *gin.Context) {
 		utils.InternalServerError(c, "保存消息失败")
 		return
 	}
+	if err := tree.SetActive(&conversation, userMessage.ID); err != nil {
+		utils.InternalServerError(c, "保存消息失败")
+		return
+	}
 
+	// 登记本次生成：调用取消接口或所有连接断开过久时中断上游请求
+	stream, ctx, finish := services.DefaultGenerationRegistry.StartStream(conversation.ID)
+	go func() {
+		defer finish()
+		streamReply(ctx, stream, userID, &conversation, &userMessage)
+	}()
+
+	serveStream(c, stream, "")
+}
+
+// ResumeStream 重新连接对话最近一次的流式生成，补发 last_event_id（或 Last-Event-ID 请求头）之后的事件并继续接收
+func (mc *MessageController) ResumeStream(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	conversationID := c.Param("id")
+
+	var conversation models.Conversation
+	if err := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).First(&conversation).Error; err != nil {
+		utils.NotFound(c, "对话不存在")
+		return
+	}
+
+	stream := services.DefaultGenerationRegistry.Stream(conversation.ID)
+	if stream == nil {
+		utils.NotFound(c, "该对话没有可恢复的生成")
+		return
+	}
+
+	lastEventID := c.Query("last_event_id")
+	if lastEventID == "" {
+		lastEventID = c.GetHeader("Last-Event-ID")
+	}
+	serveStream(c, stream, lastEventID)
+}
+
+// StreamTicket 获取连接对话最近一次流式生成的票据，浏览器 EventSource 通过 ticket 查询参数使用
+func (mc *MessageController) StreamTicket(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	conversationID := c.Param("id")
+
+	var conversation models.Conversation
+	if err := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).First(&conversation).Error; err != nil {
+		utils.NotFound(c, "对话不存在")
+		return
+	}
+
+	stream := services.DefaultGenerationRegistry.Stream(conversation.ID)
+	if stream == nil {
+		utils.NotFound(c, "该对话没有可恢复的生成")
+		return
+	}
+
+	utils.Success(c, gin.H{"ticket": stream.Ticket()})
+}
+
+// serveStream 将生成的事件以 SSE 发送给客户端，直到生成结束或客户端断开
+func serveStream(c *gin.Context, stream *services.GenerationStream, lastEventID string) {
 	// 设置SSE响应头
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Transfer-Encoding", "chunked")
 
-	// 获取历史消息
-	var messages []models.Message
-	database.DB.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&messages)
+	detach := stream.Attach()
+	defer detach()
+
+	for {
+		events, wait, closed := stream.Since(lastEventID)
+		for _, event := range events {
+			sendSSE(c, event)
+			lastEventID = event.ID
+		}
+		if closed {
+			return
+		}
+
+		select {
+		case <-wait:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
 
-	// 调用AI服务（流式）
-	// 注意：流式处理目前仅支持 simple 模式，未来版本会支持 Eino 工作流的流式处理
-	aiService := services.NewAIService()
-	stream, err := aiService.ChatStream(conversation.Agent, messages)
+// streamReply 在后台执行流式生成，将事件写入 stream 并保存AI回复
+func streamReply(ctx context.Context, stream *services.GenerationStream, userID uint, conversation *models.Conversation, userMessage *models.Message) {
+	// 获取当前分支的历史消息，按模型的上下文长度和智能体的策略截断
+	messages, err := services.NewMessageTreeService().ContextMessages(conversation.ID, userMessage.ID)
+	if err != nil {
+		stream.Publish("error", gin.H{"message": "获取历史消息失败"})
+		return
+	}
+	window := services.NewContextManager().Prepare(ctx, conversation.Agent, messages)
+
+	// 调用 Eino 服务（流式，会根据 Agent 的 WorkflowType 自动选择执行方式）
+	einoService := services.NewEinoService()
+	events, err := einoService.ExecuteAgentStream(ctx, conversation.Agent, window.Messages)
 	if err != nil {
-		sendSSE(c, "error", gin.H{"message": err.Error()})
+		stream.Publish("error", gin.H{"message": err.Error()})
 		return
 	}
-	defer stream.Close()
 
 	// 发送用户消息事件
-	sendSSE(c, "user_message", userMessage.ToResponse())
-
-	// 读取流式响应
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(stream)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				break
+	stream.Publish("user_message", userMessage.ToResponse())
+
+	// 转发执行事件，同时累积已输出的内容（取消时保存为部分回复）
+	var partial strings.Builder
+	var fullResponse string
+	var inputTokens, outputTokens int
+	done := false
+	for event := range events {
+		switch event.Type {
+		case services.StreamEventContent:
+			partial.WriteString(event.Content)
+			stream.Publish("content", gin.H{"content": event.Content})
+		case services.StreamEventNodeStart, services.StreamEventNodeEnd:
+			stream.Publish(string(event.Type), gin.H{
+				"node_id":   event.NodeID,
+				"node_type": event.NodeType,
+				"error":     event.Error,
+			})
+		case services.StreamEventReActStep:
+			stream.Publish(string(event.Type), event.Step)
+		case services.StreamEventError:
+			if ctx.Err() != nil {
+				continue
 			}
+			stream.Publish("error", gin.H{"message": event.Error})
+			return
+		case services.StreamEventDone:
+			fullResponse = event.Content
+			inputTokens = event.InputTokens
+			outputTokens = event.OutputTokens
+			done = true
+		}
+	}
 
-			// 解析并转发数据
-			var chunk map[string]interface{}
-			if err := json.Unmarshal([]byte(data), &chunk); err == nil {
-				// 提取内容
-				if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-					if choice, ok := choices[0].(map[string]interface{}); ok {
-						if delta, ok := choice["delta"].(map[string]interface{}); ok {
-							if content, ok := delta["content"].(string); ok {
-								fullResponse.WriteString(content)
-								sendSSE(c, "content", gin.H{"content": content})
-							}
-						}
-					}
-				}
-			}
+	attempts := einoService.Attempts()
+	servedModel := services.ServedModel(attempts, conversation.Agent.ModelName)
+
+	// 生成被取消：保存已输出的部分内容，Token 使用量按本地分词器估算
+	cancelled := !done && ctx.Err() != nil
+	if cancelled {
+		fullResponse = partial.String()
+		if fullResponse == "" {
+			stream.Publish("cancelled", gin.H{"message_id": nil})
+			return
 		}
+		inputTokens = window.Report.PromptTokens
+		outputTokens = tokenizer.CountTokens(servedModel, fullResponse)
 	}
 
-	// 保存完整的AI回复
+	// 生成摘要消耗的Token计入本次回复
+	inputTokens += window.SummaryInputTokens
+	outputTokens += window.SummaryOutputTokens
+
+	// 按实际使用的模型定价计算成本（发生故障转移时可能是备用模型）
+	cost := services.NewPricingService().CalculateCost(servedModel, inputTokens, outputTokens, 0)
+
+	// 保存AI回复
+	metadata := messageMetadata(cost, window.Report, einoService)
+	if cancelled {
+		metadata["finish_reason"] = services.FinishReasonCancelled
+	}
 	assistantMessage := models.Message{
 		ConversationID: conversation.ID,
+		ParentID:       &userMessage.ID,
 		Role:           models.RoleAssistant,
-		Content:        fullResponse.String(),
-		InputTokens:    0, // 流式响应中需要单独计算
-		OutputTokens:   0,
+		Content:        fullResponse,
+		InputTokens:    inputTokens,
+		OutputTokens:   outputTokens,
+		Metadata:       metadata,
 	}
 
-	if err := database.DB.Create(&assistantMessage).Error; err == nil {
-		sendSSE(c, "assistant_message", assistantMessage.ToResponse())
-		sendSSE(c, "done", gin.H{"message_id": assistantMessage.ID})
+	if err := database.DB.Create(&assistantMessage).Error; err != nil {
+		stream.Publish("error", gin.H{"message": "保存AI回复失败"})
+		return
 	}
 
-	c.Writer.Flush()
+	// 更新对话统计，并切换到新回复所在的分支
+	conversation.TotalTokens += inputTokens + outputTokens
+	conversation.TotalCost += cost.TotalCost
+	conversation.ActiveMessageID = &assistantMessage.ID
+	if err := database.DB.Save(conversation).Error; err != nil {
+		stream.Publish("error", gin.H{"message": "保存对话失败"})
+		return
+	}
+
+	// 更新Token使用统计
+	services.UpdateTokenUsage(userID, conversation.AgentID, conversation.ID, inputTokens, outputTokens, cost.TotalCost)
+
+	stream.Publish("assistant_message", assistantMessage.ToResponse())
+	if cancelled {
+		stream.Publish("cancelled", gin.H{"message_id": assistantMessage.ID})
+	} else {
+		stream.Publish("done", gin.H{"message_id": assistantMessage.ID})
+	}
 }
 
-// DeleteMessage 删除消息
+// Cancel 取消对话中正在进行的生成，流式生成已输出的内容会保存为部分回复
+func (mc *MessageController) Cancel(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	conversationID := c.Param("id")
+
+	var conversation models.Conversation
+	if err := database.DB.Where("id = ? AND user_id = ?", conversationID, userID).First(&conversation).Error; err != nil {
+		utils.NotFound(c, "对话不存在")
+		return
+	}
+
+	if services.DefaultGenerationRegistry.Cancel(conversation.ID) == 0 {
+		utils.BadRequest(c, "该对话没有正在进行的生成")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "已取消", nil)
+}
+
+// Regenerate 重新生成AI回复，新回复与原回复互为兄弟分支
+func (mc *MessageController) Regenerate(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	message, conversation, ok := loadBranchMessage(c, userID)
+	if !ok {
+		return
+	}
+	if message.Role != models.RoleAssistant {
+		utils.BadRequest(c, "只能重新生成AI回复")
+		return
+	}
+	if message.ParentID == nil {
+		utils.BadRequest(c, "找不到该回复对应的用户消息")
+		return
+	}
+
+	var userMessage models.Message
+	if err := database.DB.Where("id = ? AND conversation_id = ?", *message.ParentID, conversation.ID).First(&userMessage).Error; err != nil {
+		utils.NotFound(c, "找不到该回复对应的用户消息")
+		return
+	}
+
+	assistantMessage, err := generateReply(c, userID, conversation, &userMessage)
+	if err != nil {
+		replyError(c, err)
+		return
+	}
+
+	responses, err := services.NewMessageTreeService().Responses(conversation.ID, []models.Message{*assistantMessage})
+	if err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"assistant_message": responses[0],
+	})
+}
+
+// EditMessage 编辑用户消息：保存为原消息的兄弟消息（新分支）并重新生成回复，原分支保留
+func (mc *MessageController) EditMessage(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.MessageEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+	if !checkAttachments(c, userID, req.Attachments) {
+		return
+	}
+
+	message, conversation, ok := loadBranchMessage(c, userID)
+	if !ok {
+		return
+	}
+	if message.Role != models.RoleUser {
+		utils.BadRequest(c, "只能编辑用户消息")
+		return
+	}
+
+	attachments := req.Attachments
+	if attachments == nil {
+		attachments = message.Attachments
+	}
+	userMessage := models.Message{
+		ConversationID: conversation.ID,
+		ParentID:       message.ParentID,
+		Role:           models.RoleUser,
+		Content:        req.Content,
+		Attachments:    attachments,
+	}
+	if err := database.DB.Create(&userMessage).Error; err != nil {
+		utils.InternalServerError(c, "保存消息失败")
+		return
+	}
+
+	tree := services.NewMessageTreeService()
+	if err := tree.SetActive(conversation, userMessage.ID); err != nil {
+		utils.InternalServerError(c, "保存消息失败")
+		return
+	}
+
+	assistantMessage, err := generateReply(c, userID, conversation, &userMessage)
+	if err != nil {
+		replyError(c, err)
+		return
+	}
+
+	responses, err := tree.Responses(conversation.ID, []models.Message{userMessage, *assistantMessage})
+	if err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"user_message":      responses[0],
+		"assistant_message": responses[1],
+	})
+}
+
+// SelectBranch 切换到包含指定消息的分支，返回切换后的消息列表
+func (mc *MessageController) SelectBranch(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	message, conversation, ok := loadBranchMessage(c, userID)
+	if !ok {
+		return
+	}
+
+	tree := services.NewMessageTreeService()
+	if err := tree.SelectBranch(conversation, message.ID); err != nil {
+		utils.InternalServerError(c, "切换分支失败")
+		return
+	}
+
+	messages, err := tree.ActiveBranch(conversation)
+	if err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
+	responses, err := tree.Responses(conversation.ID, messages)
+	if err != nil {
+		utils.InternalServerError(c, "获取消息列表失败")
+		return
+	}
+
+	utils.Success(c, responses)
+}
+
+// DeleteMessage 删除单条消息，子消息接到被删除消息的父消息下
 func (mc *MessageController) DeleteMessage(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	messageID := c.Param("id")
@@ -205,7 +488,7 @@ func (mc *MessageController) DeleteMessage(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Delete(&message).Error; err != nil {
+	if err := services.NewMessageTreeService().DeleteMessage(&message.Conversation, &message); err != nil {
 		utils.InternalServerError(c, "删除消息失败")
 		return
 	}
@@ -213,9 +496,165 @@ func (mc *MessageController) DeleteMessage(c *gin.Context) {
 	utils.SuccessWithMessage(c, "删除成功", nil)
 }
 
-// sendSSE 发送SSE事件
-func sendSSE(c *gin.Context, event string, data interface{}) {
-	jsonData, _ := json.Marshal(data)
-	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, jsonData)
+// DeleteBranch 删除消息及其后续的所有消息（整个分支）
+func (mc *MessageController) DeleteBranch(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	message, conversation, ok := loadBranchMessage(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := services.NewMessageTreeService().DeleteSubtree(conversation, message.ID); err != nil {
+		utils.InternalServerError(c, "删除消息失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// checkAttachments 校验消息附件的格式以及引用的文件归属，不通过时写入 400 响应
+func checkAttachments(c *gin.Context, userID uint, attachments models.Attachments) bool {
+	if err := services.ValidateAttachments(attachments); err != nil {
+		utils.BadRequest(c, err.Error())
+		return false
+	}
+	if err := services.NewFileService().CheckAttachments(userID, attachments); err != nil {
+		utils.BadRequest(c, err.Error())
+		return false
+	}
+	return true
+}
+
+// loadBranchMessage 加载当前用户对话中的消息和所属对话（补全旧对话的父子关系后重新读取消息）
+func loadBranchMessage(c *gin.Context, userID uint) (*models.Message, *models.Conversation, bool) {
+	var message models.Message
+	if err := database.DB.Preload("Conversation").Where("role <> ?", models.RoleSystem).First(&message, c.Param("id")).Error; err != nil {
+		utils.NotFound(c, "消息不存在")
+		return nil, nil, false
+	}
+
+	// 验证权限
+	if message.Conversation.UserID != userID {
+		utils.Forbidden(c, "无权操作此消息")
+		return nil, nil, false
+	}
+
+	var conversation models.Conversation
+	if err := database.DB.Preload("Agent.APIConfig").First(&conversation, message.ConversationID).Error; err != nil {
+		utils.NotFound(c, "对话不存在")
+		return nil, nil, false
+	}
+
+	if conversation.ActiveMessageID == nil {
+		if err := services.NewMessageTreeService().EnsureTree(&conversation); err != nil {
+			utils.InternalServerError(c, "获取消息列表失败")
+			return nil, nil, false
+		}
+		if err := database.DB.First(&message, message.ID).Error; err != nil {
+			utils.NotFound(c, "消息不存在")
+			return nil, nil, false
+		}
+	}
+
+	return &message, &conversation, true
+}
+
+// errGenerationCancelled 非流式生成被取消
+var errGenerationCancelled = errors.New("生成已取消")
+
+// statusGenerationCancelled 生成被取消时的状态码（沿用 Nginx 的 499 Client Closed Request）
+const statusGenerationCancelled = 499
+
+// replyError 返回生成回复失败的响应
+func replyError(c *gin.Context, err error) {
+	if errors.Is(err, errGenerationCancelled) {
+		utils.ErrorWithStatus(c, statusGenerationCancelled, statusGenerationCancelled, err.Error())
+		return
+	}
+	utils.InternalServerError(c, err.Error())
+}
+
+// generateReply 为分支中的用户消息生成AI回复（非流式），保存回复并切换到回复所在的分支
+func generateReply(c *gin.Context, userID uint, conversation *models.Conversation, userMessage *models.Message) (*models.Message, error) {
+	// 登记本次生成：客户端断开连接或调用取消接口时中断上游请求
+	ctx, finish := services.DefaultGenerationRegistry.Start(c.Request.Context(), conversation.ID)
+	defer finish()
+
+	// 获取用户消息所在分支的历史消息，按模型的上下文长度和智能体的策略截断
+	messages, err := services.NewMessageTreeService().ContextMessages(conversation.ID, userMessage.ID)
+	if err != nil {
+		return nil, fmt.Errorf("获取历史消息失败: %w", err)
+	}
+	window := services.NewContextManager().Prepare(ctx, conversation.Agent, messages)
+
+	// 调用 Eino 服务（会根据 Agent 的 WorkflowType 自动选择执行方式）
+	einoService := services.NewEinoService()
+	response, inputTokens, outputTokens, err := einoService.ExecuteAgent(ctx, conversation.Agent, window.Messages)
+	if err != nil {
+		// 非流式生成被取消时没有可保存的内容
+		if ctx.Err() != nil {
+			return nil, errGenerationCancelled
+		}
+		return nil, fmt.Errorf("AI服务调用失败: %w", err)
+	}
+	// 生成摘要消耗的Token计入本次回复
+	inputTokens += window.SummaryInputTokens
+	outputTokens += window.SummaryOutputTokens
+
+	// 按实际使用的模型定价计算成本（发生故障转移时可能是备用模型）
+	attempts := einoService.Attempts()
+	cost := services.NewPricingService().CalculateCost(services.ServedModel(attempts, conversation.Agent.ModelName), inputTokens, outputTokens, 0)
+
+	// 保存AI回复
+	assistantMessage := models.Message{
+		ConversationID: conversation.ID,
+		ParentID:       &userMessage.ID,
+		Role:           models.RoleAssistant,
+		Content:        response,
+		InputTokens:    inputTokens,
+		OutputTokens:   outputTokens,
+		Metadata:       messageMetadata(cost, window.Report, einoService),
+	}
+
+	if err := database.DB.Create(&assistantMessage).Error; err != nil {
+		return nil, errors.New("保存AI回复失败")
+	}
+
+	// 更新对话统计，并切换到新回复所在的分支
+	conversation.TotalTokens += inputTokens + outputTokens
+	conversation.TotalCost += cost.TotalCost
+	conversation.ActiveMessageID = &assistantMessage.ID
+	if err := database.DB.Save(conversation).Error; err != nil {
+		return nil, errors.New("保存对话失败")
+	}
+
+	// 更新Token使用统计
+	services.UpdateTokenUsage(userID, conversation.AgentID, conversation.ID, inputTokens, outputTokens, cost.TotalCost)
+
+	return &assistantMessage, nil
+}
+
+// messageMetadata 构建AI回复的元数据：成本、上下文处理结果、请求尝试记录、知识库引用和 ReAct 执行轨迹
+func messageMetadata(cost models.MessageCost, context services.ContextReport, einoService *services.EinoService) models.Metadata {
+	metadata := models.Metadata{"cost": cost, "context": context}
+	if attempts := einoService.Attempts(); len(attempts) > 0 {
+		metadata["attempts"] = attempts
+	}
+	if citations := einoService.Citations(); len(citations) > 0 {
+		metadata["citations"] = citations
+	}
+	if trace := einoService.ReActTrace(); trace != nil {
+		metadata["react"] = trace
+	}
+	if trace := einoService.ScriptTrace(); trace != nil {
+		metadata["script"] = trace
+	}
+	return metadata
+}
+
+// sendSSE 发送带 ID 的SSE事件
+func sendSSE(c *gin.Context, event services.SSEEvent) {
+	fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Event, event.Data)
 	c.Writer.Flush()
 }
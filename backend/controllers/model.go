@@ -1,11 +1,12 @@
 package controllers
 
 import (
-	"encoding/json"
-	"io"
-	"net/http"
-	"time"
+	"strings"
 
+	"ai-chat-backend/database"
+	"ai-chat-backend/middleware"
+	"ai-chat-backend/models"
+	"ai-chat-backend/services"
 	"ai-chat-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -13,28 +14,6 @@ import (
 
 type ModelController struct{}
 
-// OpenRouter 模型响应结构
-type OpenRouterModel struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Pricing     struct {
-		Prompt     string `json:"prompt"`
-		Completion string `json:"completion"`
-	} `json:"pricing"`
-	ContextLength int `json:"context_length"`
-	Architecture  struct {
-		Modality string `json:"modality"`
-	} `json:"architecture"`
-	TopProvider struct {
-		MaxCompletionTokens int `json:"max_completion_tokens"`
-	} `json:"top_provider"`
-}
-
-type OpenRouterResponse struct {
-	Data []OpenRouterModel `json:"data"`
-}
-
 // GetModels 获取可用模型列表
 func (mc *ModelController) GetModels(c *gin.Context) {
 	provider := c.DefaultQuery("provider", "openrouter")
@@ -50,6 +29,8 @@ func (mc *ModelController) GetModels(c *gin.Context) {
 		mc.getGoogleModels(c)
 	case "meta":
 		mc.getMetaModels(c)
+	case "ollama":
+		mc.getOllamaModels(c)
 	default:
 		// 返回默认模型列表
 		mc.getDefaultModels(c)
@@ -58,40 +39,15 @@ func (mc *ModelController) GetModels(c *gin.Context) {
 
 // getOpenRouterModels 从 OpenRouter 获取模型列表
 func (mc *ModelController) getOpenRouterModels(c *gin.Context) {
-	// 创建 HTTP 客户端
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	// 请求 OpenRouter API
-	req, err := http.NewRequest("GET", "https://openrouter.ai/api/v1/models", nil)
+	openRouterModels, err := services.FetchOpenRouterModels()
 	if err != nil {
-		utils.InternalServerError(c, "创建请求失败: "+err.Error())
-		return
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		utils.InternalServerError(c, "获取模型列表失败: "+err.Error())
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		utils.InternalServerError(c, "读取响应失败: "+err.Error())
-		return
-	}
-
-	var openRouterResp OpenRouterResponse
-	if err := json.Unmarshal(body, &openRouterResp); err != nil {
-		utils.InternalServerError(c, "解析响应失败: "+err.Error())
+		utils.InternalServerError(c, err.Error())
 		return
 	}
 
 	// 转换为简化的模型列表
 	models := make([]map[string]interface{}, 0)
-	for _, model := range openRouterResp.Data {
+	for _, model := range openRouterModels {
 		models = append(models, map[string]interface{}{
 			"id":             model.ID,
 			"name":           model.Name,
@@ -107,6 +63,51 @@ func (mc *ModelController) getOpenRouterModels(c *gin.Context) {
 	utils.Success(c, models)
 }
 
+// getOllamaModels 从用户的 Ollama 服务获取本地模型列表（需要登录并指定 config_id）
+func (mc *ModelController) getOllamaModels(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == 0 {
+		utils.Unauthorized(c, "获取 Ollama 模型列表需要登录")
+		return
+	}
+
+	configID := c.Query("config_id")
+	if configID == "" {
+		utils.BadRequest(c, "请指定 Ollama 的 API 配置（config_id）")
+		return
+	}
+
+	var apiConfig models.APIConfig
+	if err := database.DB.Where("id = ? AND user_id = ?", configID, userID).First(&apiConfig).Error; err != nil {
+		utils.NotFound(c, "配置不存在")
+		return
+	}
+
+	ollamaModels, err := services.ListOllamaModels(&apiConfig)
+	if err != nil {
+		utils.InternalServerError(c, err.Error())
+		return
+	}
+
+	modelList := make([]map[string]interface{}, 0, len(ollamaModels))
+	for _, model := range ollamaModels {
+		details := make([]string, 0, 3)
+		for _, detail := range []string{model.Details.Family, model.Details.ParameterSize, model.Details.QuantizationLevel} {
+			if detail != "" {
+				details = append(details, detail)
+			}
+		}
+		modelList = append(modelList, map[string]interface{}{
+			"id":             model.Name,
+			"name":           model.Name,
+			"description":    strings.Join(details, " · "),
+			"context_length": services.ModelContextLength(model.Name),
+		})
+	}
+
+	utils.Success(c, modelList)
+}
+
 // getOpenAIModels 获取 OpenAI 模型列表
 func (mc *ModelController) getOpenAIModels(c *gin.Context) {
 	models := []map[string]interface{}{
@@ -184,6 +185,24 @@ func (mc *ModelController) getAnthropicModels(c *gin.Context) {
 // getGoogleModels 获取 Google 模型列表
 func (mc *ModelController) getGoogleModels(c *gin.Context) {
 	models := []map[string]interface{}{
+		{
+			"id":             "gemini-2.0-flash",
+			"name":           "Gemini 2.0 Flash",
+			"description":    "速度快、成本低的多模态模型，支持 1M 上下文",
+			"context_length": 1048576,
+		},
+		{
+			"id":             "gemini-1.5-pro",
+			"name":           "Gemini 1.5 Pro",
+			"description":    "适合复杂推理的多模态模型，支持 1M 上下文",
+			"context_length": 1048576,
+		},
+		{
+			"id":             "gemini-1.5-flash",
+			"name":           "Gemini 1.5 Flash",
+			"description":    "轻量快速的多模态模型，支持 1M 上下文",
+			"context_length": 1048576,
+		},
 		{
 			"id":             "gemini-pro",
 			"name":           "Gemini Pro",
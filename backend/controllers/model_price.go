@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+	"ai-chat-backend/services"
+	"ai-chat-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelPriceController 模型定价管理（仅管理员）
+type ModelPriceController struct{}
+
+// List 获取模型定价列表
+func (mpc *ModelPriceController) List(c *gin.Context) {
+	query := database.DB.Model(&models.ModelPrice{})
+
+	if model := c.Query("model"); model != "" {
+		query = query.Where("model_pattern LIKE ?", "%"+model+"%")
+	}
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	var prices []models.ModelPrice
+	if err := query.Order("model_pattern ASC, effective_date DESC").Find(&prices).Error; err != nil {
+		utils.InternalServerError(c, "获取定价列表失败")
+		return
+	}
+
+	utils.Success(c, prices)
+}
+
+// Create 创建模型定价
+func (mpc *ModelPriceController) Create(c *gin.Context) {
+	var req models.ModelPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+
+	price := models.ModelPrice{Source: services.PriceSourceManual}
+	if err := applyModelPriceRequest(&price, req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if err := database.DB.Create(&price).Error; err != nil {
+		utils.InternalServerError(c, "创建定价失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "创建成功", price)
+}
+
+// Update 更新模型定价
+func (mpc *ModelPriceController) Update(c *gin.Context) {
+	var price models.ModelPrice
+	if err := database.DB.First(&price, c.Param("id")).Error; err != nil {
+		utils.NotFound(c, "定价不存在")
+		return
+	}
+
+	var req models.ModelPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "请求参数错误: "+err.Error())
+		return
+	}
+
+	if err := applyModelPriceRequest(&price, req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	// 手动修改后不再被 OpenRouter 同步覆盖
+	price.Source = services.PriceSourceManual
+
+	if err := database.DB.Save(&price).Error; err != nil {
+		utils.InternalServerError(c, "更新定价失败")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "更新成功", price)
+}
+
+// Delete 删除模型定价
+func (mpc *ModelPriceController) Delete(c *gin.Context) {
+	result := database.DB.Delete(&models.ModelPrice{}, c.Param("id"))
+	if result.Error != nil {
+		utils.InternalServerError(c, "删除定价失败")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.NotFound(c, "定价不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// Sync 从 OpenRouter 同步模型定价
+func (mpc *ModelPriceController) Sync(c *gin.Context) {
+	openRouterModels, err := services.FetchOpenRouterModels()
+	if err != nil {
+		utils.InternalServerError(c, err.Error())
+		return
+	}
+
+	count, err := services.NewPricingService().SyncOpenRouterPrices(openRouterModels)
+	if err != nil {
+		utils.InternalServerError(c, "同步定价失败: "+err.Error())
+		return
+	}
+
+	utils.SuccessWithMessage(c, "同步成功", gin.H{"updated": count})
+}
+
+// applyModelPriceRequest 将请求参数写入定价记录
+func applyModelPriceRequest(price *models.ModelPrice, req models.ModelPriceRequest) error {
+	effectiveDate := time.Now()
+	if req.EffectiveDate != "" {
+		date, err := time.Parse("2006-01-02", req.EffectiveDate)
+		if err != nil {
+			return errors.New("生效日期格式错误，应为 YYYY-MM-DD")
+		}
+		effectiveDate = date
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+
+	price.ModelPattern = req.ModelPattern
+	price.InputPrice = req.InputPrice
+	price.OutputPrice = req.OutputPrice
+	price.CachedInputPrice = req.InputPrice
+	if req.CachedInputPrice != nil {
+		price.CachedInputPrice = *req.CachedInputPrice
+	}
+	price.Currency = currency
+	price.EffectiveDate = time.Date(effectiveDate.Year(), effectiveDate.Month(), effectiveDate.Day(), 0, 0, 0, 0, time.UTC)
+	return nil
+}
@@ -88,6 +88,12 @@ func (tc *TemplateController) CreateAgentFromTemplate(c *gin.Context) {
 		return
 	}
 	
+	// 知识检索类模板引用的知识库必须属于当前用户
+	if err := validateRetrieval(agent.Retrieval, userID); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
 	// 设置用户ID
 	agent.UserID = userID
 	
@@ -34,8 +34,9 @@ CREATE TABLE IF NOT EXISTS api_configs (
     name VARCHAR(100) NOT NULL,
     api_type VARCHAR(50) NOT NULL,
     endpoint_url VARCHAR(500) NOT NULL,
-    auth_type ENUM('bearer', 'api_key', 'custom') DEFAULT 'bearer',
-    credentials TEXT NOT NULL,
+    auth_type ENUM('bearer', 'api_key', 'custom', 'none') DEFAULT 'bearer',
+    credentials TEXT,
+    auth_spec JSON,
     field_mapping JSON,
     is_active BOOLEAN DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -58,6 +59,9 @@ CREATE TABLE IF NOT EXISTS agents (
     model_name VARCHAR(100),
     model_params JSON,
     tools JSON,
+    fallbacks JSON,
+    context_config JSON,
+    retrieval JSON,
     is_public BOOLEAN DEFAULT FALSE,
     usage_count INT DEFAULT 0,
     -- Eino 工作流相关字段
@@ -87,6 +91,7 @@ CREATE TABLE IF NOT EXISTS conversations (
     status ENUM('active', 'archived', 'deleted') DEFAULT 'active',
     total_tokens INT DEFAULT 0,
     total_cost DECIMAL(10,6) DEFAULT 0,
+    active_message_id BIGINT UNSIGNED NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP NULL,
@@ -102,6 +107,7 @@ CREATE TABLE IF NOT EXISTS conversations (
 CREATE TABLE IF NOT EXISTS messages (
     id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
     conversation_id BIGINT UNSIGNED NOT NULL,
+    parent_id BIGINT UNSIGNED NULL,
     role ENUM('user', 'assistant', 'system') NOT NULL,
     content TEXT NOT NULL,
     attachments JSON,
@@ -111,6 +117,7 @@ CREATE TABLE IF NOT EXISTS messages (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
     INDEX idx_conversation_id (conversation_id),
+    INDEX idx_parent_id (parent_id),
     INDEX idx_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
@@ -155,3 +162,101 @@ CREATE TABLE IF NOT EXISTS prompt_templates (
     FULLTEXT idx_search (name, description)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+
+-- 模型定价表（价格单位：每百万 Token）
+CREATE TABLE IF NOT EXISTS model_prices (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    model_pattern VARCHAR(200) NOT NULL,
+    input_price DECIMAL(12,6) DEFAULT 0,
+    output_price DECIMAL(12,6) DEFAULT 0,
+    cached_input_price DECIMAL(12,6) DEFAULT 0,
+    currency VARCHAR(10) DEFAULT 'USD',
+    effective_date DATE NOT NULL,
+    source VARCHAR(50) DEFAULT 'manual',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_model_pattern (model_pattern),
+    INDEX idx_effective_date (effective_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+
+-- 上传文件表（文件内容保存在存储后端，文档提取的文本用于消息附件）
+CREATE TABLE IF NOT EXISTS files (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_backend VARCHAR(20) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    extracted_text LONGTEXT,
+    text_length INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 知识库、知识库文档和文档分段（向量以 float32 小端字节序存储）
+CREATE TABLE IF NOT EXISTS knowledge_bases (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    api_config_id BIGINT UNSIGNED,
+    embedding_model VARCHAR(100) NOT NULL,
+    dimensions INT DEFAULT 0,
+    chunk_size INT DEFAULT 800,
+    chunk_overlap INT DEFAULT 100,
+    document_count INT DEFAULT 0,
+    chunk_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (api_config_id) REFERENCES api_configs(id) ON DELETE SET NULL,
+    INDEX idx_user_id (user_id),
+    INDEX idx_api_config_id (api_config_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS knowledge_documents (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    knowledge_base_id BIGINT UNSIGNED NOT NULL,
+    file_id BIGINT UNSIGNED,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    chunk_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (knowledge_base_id) REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+    FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE SET NULL,
+    INDEX idx_knowledge_base_id (knowledge_base_id),
+    INDEX idx_file_id (file_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS knowledge_chunks (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    knowledge_base_id BIGINT UNSIGNED NOT NULL,
+    document_id BIGINT UNSIGNED NOT NULL,
+    chunk_index INT NOT NULL,
+    content TEXT NOT NULL,
+    embedding MEDIUMBLOB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (knowledge_base_id) REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+    FOREIGN KEY (document_id) REFERENCES knowledge_documents(id) ON DELETE CASCADE,
+    INDEX idx_knowledge_base_id (knowledge_base_id),
+    INDEX idx_document_id (document_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS script_states (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    agent_id BIGINT UNSIGNED NOT NULL,
+    conversation_id BIGINT UNSIGNED NOT NULL,
+    `values` JSON,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    UNIQUE INDEX idx_script_state_scope (agent_id, conversation_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
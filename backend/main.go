@@ -2,20 +2,34 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"ai-chat-backend/config"
 	"ai-chat-backend/controllers"
 	"ai-chat-backend/database"
 	"ai-chat-backend/middleware"
 	"ai-chat-backend/models"
+	"ai-chat-backend/services"
+	"ai-chat-backend/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// 自定义代码智能体的脚本在独立的子进程中执行
+	if len(os.Args) > 1 && os.Args[1] == services.ScriptWorkerCommand {
+		services.RunScriptWorker()
+		return
+	}
+
 	// 加载配置
 	config.LoadConfig()
 
+	// 加载凭证加密主密钥
+	if err := utils.InitCredentialKeys(); err != nil {
+		log.Fatal("Failed to load credential keys:", err)
+	}
+
 	// 设置Gin模式
 	gin.SetMode(config.AppConfig.GinMode)
 
@@ -31,8 +45,26 @@ func main() {
 		&models.Message{},
 		&models.TokenUsage{},
 		&models.PromptTemplate{},
+		&models.ModelPrice{},
+		&models.File{},
+		&models.KnowledgeBase{},
+		&models.KnowledgeDocument{},
+		&models.KnowledgeChunk{},
+		&models.ScriptState{},
 	)
 
+	// 加密历史遗留的明文凭证
+	if count, err := services.EncryptPlaintextCredentials(); err != nil {
+		log.Fatal("Failed to encrypt plaintext credentials:", err)
+	} else if count > 0 {
+		log.Printf("Encrypted %d plaintext credentials", count)
+	}
+
+	// 启动知识库文档索引队列，重新索引上次退出时未完成的文档
+	if err := services.DefaultKnowledgeIndexer.Start(); err != nil {
+		log.Fatal("Failed to start knowledge indexer:", err)
+	}
+
 	// 创建路由
 	r := gin.Default()
 
@@ -50,6 +82,9 @@ func main() {
 	promptTemplateCtrl := &controllers.PromptTemplateController{}
 	modelCtrl := &controllers.ModelController{}
 	templateCtrl := controllers.NewTemplateController()
+	modelPriceCtrl := &controllers.ModelPriceController{}
+	fileCtrl := &controllers.FileController{}
+	knowledgeBaseCtrl := &controllers.KnowledgeBaseController{}
 
 	// 健康检查
 	r.GET("/health", func(c *gin.Context) {
@@ -67,13 +102,16 @@ func main() {
 		}
 
 		// 公开的 API（无需认证）
-		api.GET("/models", modelCtrl.GetModels) // 获取模型列表
+		api.GET("/models", middleware.OptionalAuth(), modelCtrl.GetModels) // 获取模型列表（provider=ollama 时需要登录）
 		
 		// Agent 模板相关（公开访问）
 		api.GET("/agent-templates", templateCtrl.ListTemplates)
 		api.GET("/agent-templates/categories", templateCtrl.GetCategories)
 		api.GET("/agent-templates/:id", templateCtrl.GetTemplate)
 
+		// 流式重连（EventSource 无法设置请求头，也可以使用流票据认证）
+		api.GET("/conversations/:id/stream", middleware.StreamAuth(), messageCtrl.ResumeStream)
+
 		// 需要认证的路由
 		authorized := api.Group("")
 		authorized.Use(middleware.AuthRequired())
@@ -87,9 +125,11 @@ func main() {
 			{
 				configs.GET("", apiConfigCtrl.List)
 				configs.POST("", apiConfigCtrl.Create)
+				configs.POST("/test", apiConfigCtrl.TestUnsaved)
 				configs.GET("/:id", apiConfigCtrl.Get)
 				configs.PUT("/:id", apiConfigCtrl.Update)
 				configs.DELETE("/:id", apiConfigCtrl.Delete)
+				configs.POST("/:id/test", apiConfigCtrl.Test)
 			}
 
 			// 智能体管理
@@ -117,10 +157,39 @@ func main() {
 				// 消息相关
 				conversations.POST("/:id/messages", messageCtrl.SendMessage)
 				conversations.POST("/:id/stream", messageCtrl.StreamMessage)
+				conversations.POST("/:id/stream/ticket", messageCtrl.StreamTicket)
+				conversations.POST("/:id/cancel", messageCtrl.Cancel)
 			}
 
 			// 消息操作
+			authorized.PUT("/messages/:id", messageCtrl.EditMessage)
 			authorized.DELETE("/messages/:id", messageCtrl.DeleteMessage)
+			authorized.DELETE("/messages/:id/branch", messageCtrl.DeleteBranch)
+			authorized.POST("/messages/:id/regenerate", messageCtrl.Regenerate)
+			authorized.POST("/messages/:id/select", messageCtrl.SelectBranch)
+
+			// 文件上传（用作消息附件）
+			files := authorized.Group("/files")
+			{
+				files.GET("", fileCtrl.List)
+				files.POST("", fileCtrl.Upload)
+				files.GET("/:id", fileCtrl.Get)
+				files.GET("/:id/content", fileCtrl.Download)
+				files.DELETE("/:id", fileCtrl.Delete)
+			}
+
+			// 知识库（rag 智能体检索）
+			knowledgeBases := authorized.Group("/knowledge-bases")
+			{
+				knowledgeBases.GET("", knowledgeBaseCtrl.List)
+				knowledgeBases.POST("", knowledgeBaseCtrl.Create)
+				knowledgeBases.GET("/:id", knowledgeBaseCtrl.Get)
+				knowledgeBases.PUT("/:id", knowledgeBaseCtrl.Update)
+				knowledgeBases.DELETE("/:id", knowledgeBaseCtrl.Delete)
+				knowledgeBases.POST("/:id/documents", knowledgeBaseCtrl.AddDocument)
+				knowledgeBases.DELETE("/:id/documents/:document_id", knowledgeBaseCtrl.DeleteDocument)
+				knowledgeBases.POST("/:id/search", knowledgeBaseCtrl.Search)
+			}
 
 			// 使用统计
 			usage := authorized.Group("/usage")
@@ -140,6 +209,18 @@ func main() {
 				templates.DELETE("/:id", promptTemplateCtrl.Delete)
 				templates.POST("/:id/use", promptTemplateCtrl.Use)
 			}
+
+			// 管理员接口
+			admin := authorized.Group("/admin")
+			admin.Use(middleware.AdminRequired())
+			{
+				// 模型定价管理
+				admin.GET("/model-prices", modelPriceCtrl.List)
+				admin.POST("/model-prices", modelPriceCtrl.Create)
+				admin.POST("/model-prices/sync", modelPriceCtrl.Sync)
+				admin.PUT("/model-prices/:id", modelPriceCtrl.Update)
+				admin.DELETE("/model-prices/:id", modelPriceCtrl.Delete)
+			}
 		}
 	}
 
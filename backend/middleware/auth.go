@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"strconv"
 	"strings"
 
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+	"ai-chat-backend/services"
 	"ai-chat-backend/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -41,6 +45,52 @@ func AuthRequired() gin.HandlerFunc {
 	}
 }
 
+// StreamAuth 流式重连接口的认证：携带 Authorization 请求头时与 AuthRequired 相同；
+// 浏览器 EventSource 无法设置请求头，改用 ticket 查询参数传递流票据（见 GenerationStream.Ticket），
+// 票据只对路径中对话的一次生成有效，不会像登录令牌那样长期有效地出现在访问日志中
+func StreamAuth() gin.HandlerFunc {
+	authRequired := AuthRequired()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if c.GetHeader("Authorization") != "" || ticket == "" {
+			authRequired(c)
+			return
+		}
+
+		conversationID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil || services.DefaultGenerationRegistry.StreamByTicket(uint(conversationID), ticket) == nil {
+			utils.Unauthorized(c, "无效或已过期的流票据")
+			c.Abort()
+			return
+		}
+		var conversation models.Conversation
+		if err := database.DB.Select("id", "user_id").First(&conversation, conversationID).Error; err != nil {
+			utils.Unauthorized(c, "无效或已过期的流票据")
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", conversation.UserID)
+		c.Next()
+	}
+}
+
+// OptionalAuth 可选认证：携带有效令牌时设置用户信息，未携带时继续处理请求
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			if claims, err := utils.ParseToken(parts[1]); err == nil {
+				c.Set("user_id", claims.UserID)
+				c.Set("username", claims.Username)
+				c.Set("email", claims.Email)
+			}
+		}
+
+		c.Next()
+	}
+}
+
 // GetUserID 从上下文中获取用户ID
 func GetUserID(c *gin.Context) uint {
 	userID, exists := c.Get("user_id")
@@ -50,3 +100,23 @@ func GetUserID(c *gin.Context) uint {
 	return userID.(uint)
 }
 
+
+// AdminRequired 要求当前用户为管理员（需在 AuthRequired 之后使用）
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		if err := database.DB.Select("id", "role").First(&user, GetUserID(c)).Error; err != nil {
+			utils.Unauthorized(c, "用户不存在")
+			c.Abort()
+			return
+		}
+
+		if user.Role != models.RoleAdmin {
+			utils.Forbidden(c, "需要管理员权限")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
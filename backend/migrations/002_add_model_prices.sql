@@ -0,0 +1,22 @@
+-- 添加模型定价表的迁移脚本（价格单位：每百万 Token）
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/002_add_model_prices.sql
+-- 表创建后可通过 POST /api/admin/model-prices/sync 从 OpenRouter 导入定价
+
+USE ai_chat;
+
+CREATE TABLE IF NOT EXISTS model_prices (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    model_pattern VARCHAR(200) NOT NULL,
+    input_price DECIMAL(12,6) DEFAULT 0,
+    output_price DECIMAL(12,6) DEFAULT 0,
+    cached_input_price DECIMAL(12,6) DEFAULT 0,
+    currency VARCHAR(10) DEFAULT 'USD',
+    effective_date DATE NOT NULL,
+    source VARCHAR(50) DEFAULT 'manual',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_model_pattern (model_pattern),
+    INDEX idx_effective_date (effective_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+SELECT '✅ Migration completed: model_prices table created' AS status;
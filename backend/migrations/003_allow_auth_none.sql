@@ -0,0 +1,11 @@
+-- 允许无需认证的 API 配置（例如本地部署的 Ollama）
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/003_allow_auth_none.sql
+
+USE ai_chat;
+
+ALTER TABLE api_configs
+    MODIFY COLUMN auth_type ENUM('bearer', 'api_key', 'custom', 'none') DEFAULT 'bearer',
+    MODIFY COLUMN credentials TEXT NULL;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: api_configs now supports auth_type none' AS status;
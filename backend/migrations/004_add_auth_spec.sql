@@ -0,0 +1,22 @@
+-- 为 api_configs 表添加自定义认证配置字段的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/004_add_auth_spec.sql
+
+USE ai_chat;
+
+-- 检查并添加 auth_spec 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'api_configs' 
+  AND COLUMN_NAME = 'auth_spec';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE api_configs ADD COLUMN auth_spec JSON AFTER credentials',
+    'SELECT ''auth_spec column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: auth_spec field added to api_configs table' AS status;
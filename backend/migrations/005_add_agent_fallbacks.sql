@@ -0,0 +1,22 @@
+-- 为 agents 表添加备用模型配置字段的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/005_add_agent_fallbacks.sql
+
+USE ai_chat;
+
+-- 检查并添加 fallbacks 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'agents' 
+  AND COLUMN_NAME = 'fallbacks';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE agents ADD COLUMN fallbacks JSON AFTER tools',
+    'SELECT ''fallbacks column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: fallbacks field added to agents table' AS status;
@@ -0,0 +1,22 @@
+-- 为 agents 表添加上下文窗口配置字段的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/006_add_agent_context_config.sql
+
+USE ai_chat;
+
+-- 检查并添加 context_config 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'agents' 
+  AND COLUMN_NAME = 'context_config';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE agents ADD COLUMN context_config JSON AFTER fallbacks',
+    'SELECT ''context_config column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: context_config field added to agents table' AS status;
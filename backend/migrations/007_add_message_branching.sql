@@ -0,0 +1,38 @@
+-- 为消息分支（重新生成、编辑消息）添加字段的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/007_add_message_branching.sql
+-- 已有对话的消息在首次访问时按时间顺序自动串成一条分支
+
+USE ai_chat;
+
+-- 检查并添加 messages.parent_id 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'messages' 
+  AND COLUMN_NAME = 'parent_id';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE messages ADD COLUMN parent_id BIGINT UNSIGNED NULL AFTER conversation_id, ADD INDEX idx_parent_id (parent_id)',
+    'SELECT ''parent_id column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 检查并添加 conversations.active_message_id 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'conversations' 
+  AND COLUMN_NAME = 'active_message_id';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE conversations ADD COLUMN active_message_id BIGINT UNSIGNED NULL AFTER total_cost',
+    'SELECT ''active_message_id column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: message branching fields added' AS status;
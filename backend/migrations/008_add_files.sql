@@ -0,0 +1,24 @@
+-- 添加上传文件表的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/008_add_files.sql
+-- 文件内容保存在存储后端（STORAGE_BACKEND），表中记录存储路径和提取的文本
+
+USE ai_chat;
+
+CREATE TABLE IF NOT EXISTS files (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_backend VARCHAR(20) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    extracted_text LONGTEXT,
+    text_length INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+SELECT '✅ Migration completed: files table created' AS status;
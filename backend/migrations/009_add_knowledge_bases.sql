@@ -0,0 +1,73 @@
+-- 添加知识库（检索增强生成）的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/009_add_knowledge_bases.sql
+-- 文档切分后的分段和向量保存在 knowledge_chunks 表，agents.retrieval 保存智能体使用的知识库
+
+USE ai_chat;
+
+CREATE TABLE IF NOT EXISTS knowledge_bases (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    api_config_id BIGINT UNSIGNED,
+    embedding_model VARCHAR(100) NOT NULL,
+    dimensions INT DEFAULT 0,
+    chunk_size INT DEFAULT 800,
+    chunk_overlap INT DEFAULT 100,
+    document_count INT DEFAULT 0,
+    chunk_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (api_config_id) REFERENCES api_configs(id) ON DELETE SET NULL,
+    INDEX idx_user_id (user_id),
+    INDEX idx_api_config_id (api_config_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS knowledge_documents (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    knowledge_base_id BIGINT UNSIGNED NOT NULL,
+    file_id BIGINT UNSIGNED,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    chunk_count INT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (knowledge_base_id) REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+    FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE SET NULL,
+    INDEX idx_knowledge_base_id (knowledge_base_id),
+    INDEX idx_file_id (file_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS knowledge_chunks (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    knowledge_base_id BIGINT UNSIGNED NOT NULL,
+    document_id BIGINT UNSIGNED NOT NULL,
+    chunk_index INT NOT NULL,
+    content TEXT NOT NULL,
+    embedding MEDIUMBLOB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (knowledge_base_id) REFERENCES knowledge_bases(id) ON DELETE CASCADE,
+    FOREIGN KEY (document_id) REFERENCES knowledge_documents(id) ON DELETE CASCADE,
+    INDEX idx_knowledge_base_id (knowledge_base_id),
+    INDEX idx_document_id (document_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 检查并添加 retrieval 字段
+SET @col_exists = 0;
+SELECT COUNT(*) INTO @col_exists 
+FROM information_schema.COLUMNS 
+WHERE TABLE_SCHEMA = 'ai_chat' 
+  AND TABLE_NAME = 'agents' 
+  AND COLUMN_NAME = 'retrieval';
+
+SET @query = IF(@col_exists = 0,
+    'ALTER TABLE agents ADD COLUMN retrieval JSON AFTER context_config',
+    'SELECT ''retrieval column already exists'' AS message');
+PREPARE stmt FROM @query;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: knowledge base tables created, retrieval field added to agents table' AS status;
@@ -0,0 +1,20 @@
+-- 添加自定义代码智能体脚本状态的迁移脚本
+-- 执行方式: mysql -u ai_chat_user -p ai_chat < migrations/010_add_script_states.sql
+-- 脚本通过 kv 接口读写的状态按（智能体，对话）保存在 script_states 表
+
+USE ai_chat;
+
+CREATE TABLE IF NOT EXISTS script_states (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    agent_id BIGINT UNSIGNED NOT NULL,
+    conversation_id BIGINT UNSIGNED NOT NULL,
+    `values` JSON,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (agent_id) REFERENCES agents(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    UNIQUE INDEX idx_script_state_scope (agent_id, conversation_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 显示迁移完成信息
+SELECT '✅ Migration completed: script_states table created' AS status;
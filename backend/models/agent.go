@@ -48,6 +48,84 @@ func (t *Tools) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, t)
 }
 
+// AgentFallback 备用模型配置，主配置请求失败时按顺序尝试
+// APIConfigID 为空时沿用主配置，ModelName 为空时沿用主模型
+type AgentFallback struct {
+	APIConfigID *uint  `json:"api_config_id,omitempty"`
+	ModelName   string `json:"model_name,omitempty"`
+}
+
+type AgentFallbacks []AgentFallback
+
+func (f AgentFallbacks) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *AgentFallbacks) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, f)
+}
+
+// ContextStrategy 对话历史超出上下文长度时的处理策略
+type ContextStrategy string
+
+const (
+	ContextNone          ContextStrategy = "none"            // 不截断，发送全部历史
+	ContextSlidingWindow ContextStrategy = "sliding_window"  // 保留最近的消息（默认）
+	ContextKeepFirstLast ContextStrategy = "keep_first_last" // 保留开头 N 条和最近的消息
+	ContextSummarize     ContextStrategy = "summarize"       // 较早的消息滚动总结为摘要
+)
+
+// ContextConfig 上下文窗口配置
+type ContextConfig struct {
+	Strategy  ContextStrategy `json:"strategy,omitempty"`
+	KeepFirst int             `json:"keep_first,omitempty"` // keep_first_last 保留开头的消息数，默认 2
+	KeepLast  int             `json:"keep_last,omitempty"`  // 最多保留的最近消息数，0 表示只受上下文长度限制
+}
+
+func (c ContextConfig) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *ContextConfig) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, c)
+}
+
+// RetrievalConfig 知识库检索配置：配置了知识库的智能体在回答前检索相关分段并注入系统提示词
+type RetrievalConfig struct {
+	KnowledgeBaseIDs []uint  `json:"knowledge_base_ids,omitempty"`
+	TopK             int     `json:"top_k,omitempty"`     // 注入的分段数，默认 4
+	MinScore         float64 `json:"min_score,omitempty"` // 余弦相似度下限，低于该值的分段不注入
+}
+
+func (r RetrievalConfig) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *RetrievalConfig) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, r)
+}
+
 // WorkflowType Agent 工作流类型
 type WorkflowType string
 
@@ -105,6 +183,9 @@ type Agent struct {
 	ModelName    string         `gorm:"size:100" json:"model_name"`
 	ModelParams  ModelParams    `gorm:"type:json" json:"model_params"`
 	Tools        Tools          `gorm:"type:json" json:"tools"`
+	Fallbacks    AgentFallbacks `gorm:"type:json" json:"fallbacks"`
+	ContextConfig ContextConfig `gorm:"type:json" json:"context_config"`
+	Retrieval    RetrievalConfig `gorm:"type:json" json:"retrieval"`
 	IsPublic     bool           `gorm:"default:false;index" json:"is_public"`
 	UsageCount   int            `gorm:"default:0" json:"usage_count"`
 	
@@ -130,12 +211,16 @@ type AgentRequest struct {
 	ModelName    string      `json:"model_name" binding:"required"`
 	ModelParams  ModelParams `json:"model_params"`
 	Tools        Tools       `json:"tools"`
+	Fallbacks    AgentFallbacks `json:"fallbacks"`
+	ContextConfig ContextConfig `json:"context_config"`
+	Retrieval    RetrievalConfig `json:"retrieval"`
 	IsPublic     bool        `json:"is_public"`
 	
 	// Eino 工作流相关字段
 	WorkflowType       WorkflowType           `json:"workflow_type"`
 	WorkflowDefinition EinoWorkflowDefinition `json:"workflow_definition,omitempty"`
 	TemplateID         string                 `json:"template_id,omitempty"`
+	CustomCode         string                 `json:"custom_code,omitempty"`
 }
 
 type AgentResponse struct {
@@ -148,6 +233,9 @@ type AgentResponse struct {
 	ModelName    string       `json:"model_name"`
 	ModelParams  ModelParams  `json:"model_params"`
 	Tools        Tools        `json:"tools"`
+	Fallbacks    AgentFallbacks `json:"fallbacks"`
+	ContextConfig ContextConfig `json:"context_config"`
+	Retrieval    RetrievalConfig `json:"retrieval"`
 	IsPublic     bool         `json:"is_public"`
 	UsageCount   int          `json:"usage_count"`
 	CreatedAt    time.Time    `json:"created_at"`
@@ -157,6 +245,7 @@ type AgentResponse struct {
 	WorkflowType       WorkflowType           `json:"workflow_type"`
 	WorkflowDefinition EinoWorkflowDefinition `json:"workflow_definition,omitempty"`
 	TemplateID         string                 `json:"template_id,omitempty"`
+	CustomCode         string                 `json:"custom_code,omitempty"`
 }
 
 func (a *Agent) ToResponse() AgentResponse {
@@ -170,6 +259,9 @@ func (a *Agent) ToResponse() AgentResponse {
 		ModelName:          a.ModelName,
 		ModelParams:        a.ModelParams,
 		Tools:              a.Tools,
+		Fallbacks:          a.Fallbacks,
+		ContextConfig:      a.ContextConfig,
+		Retrieval:          a.Retrieval,
 		IsPublic:           a.IsPublic,
 		UsageCount:         a.UsageCount,
 		CreatedAt:          a.CreatedAt,
@@ -177,6 +269,7 @@ func (a *Agent) ToResponse() AgentResponse {
 		WorkflowType:       a.WorkflowType,
 		WorkflowDefinition: a.WorkflowDefinition,
 		TemplateID:         a.TemplateID,
+		CustomCode:         a.CustomCode,
 	}
 }
 
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"strings"
 	"time"
 	"gorm.io/gorm"
 )
@@ -13,6 +14,7 @@ const (
 	AuthBearer AuthType = "bearer"
 	AuthAPIKey AuthType = "api_key"
 	AuthCustom AuthType = "custom"
+	AuthNone   AuthType = "none" // 无需认证，例如本地部署的 Ollama
 )
 
 type FieldMapping struct {
@@ -35,14 +37,95 @@ func (f *FieldMapping) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, f)
 }
 
+// AuthSpec 自定义认证配置（AuthType 为 custom 时使用）
+// 请求头和查询参数的值支持模板变量：
+// {{credential}} 完整凭证，{{credential.字段}} 多字段凭证（凭证为 JSON 对象）中的字段，
+// {{timestamp}} 时间戳，{{nonce}} 随机串，{{body_sha256}} 请求体 SHA256，{{method}} 请求方法，{{path}} 请求路径，
+// {{signature}} HMAC 签名（需要配置 Signing）
+type AuthSpec struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	QueryParams map[string]string `json:"query_params,omitempty"`
+	Signing     *SigningSpec      `json:"signing,omitempty"`
+}
+
+// SigningSpec HMAC 请求签名配置
+type SigningSpec struct {
+	Algorithm       string `json:"algorithm"`        // hmac-sha256（默认）、hmac-sha1、hmac-sha512
+	SecretField     string `json:"secret_field"`     // 签名密钥在凭证中的字段名，默认 secret；凭证不是 JSON 时使用完整凭证
+	StringToSign    string `json:"string_to_sign"`   // 待签名字符串模板，默认 {{timestamp}}\n{{body_sha256}}
+	Encoding        string `json:"encoding"`         // 签名编码：hex（默认）、base64
+	TimestampFormat string `json:"timestamp_format"` // 时间戳格式：unix（默认）、unix_ms、rfc3339
+}
+
+// RedactedValue 响应中隐藏的 AuthSpec 字面值，更新配置时提交该值表示保留原值
+const RedactedValue = "******"
+
+// Redacted 返回隐藏了字面值的副本：不含模板变量的请求头和查询参数值可能是直接填写的密钥
+func (a AuthSpec) Redacted() AuthSpec {
+	a.Headers = redactLiteralValues(a.Headers)
+	a.QueryParams = redactLiteralValues(a.QueryParams)
+	return a
+}
+
+// RestoreRedacted 将提交的 RedactedValue 还原为已保存配置中的值
+func (a AuthSpec) RestoreRedacted(stored AuthSpec) AuthSpec {
+	a.Headers = restoreRedactedValues(a.Headers, stored.Headers)
+	a.QueryParams = restoreRedactedValues(a.QueryParams, stored.QueryParams)
+	return a
+}
+
+func redactLiteralValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for key, value := range values {
+		if value != "" && !strings.Contains(value, "{{") {
+			value = RedactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func restoreRedactedValues(values, stored map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	restored := make(map[string]string, len(values))
+	for key, value := range values {
+		if original, ok := stored[key]; ok && value == RedactedValue {
+			value = original
+		}
+		restored[key] = value
+	}
+	return restored
+}
+
+func (a AuthSpec) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+func (a *AuthSpec) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, a)
+}
+
 type APIConfig struct {
 	ID           uint           `gorm:"primarykey" json:"id"`
 	UserID       uint           `gorm:"not null;index" json:"user_id"`
 	Name         string         `gorm:"size:100;not null" json:"name"`
-	APIType      string         `gorm:"size:50;not null" json:"api_type"` // openrouter, openai, claude, custom
+	APIType      string         `gorm:"size:50;not null" json:"api_type"` // openrouter, openai, claude, gemini, ollama, custom
 	EndpointURL  string         `gorm:"size:500;not null" json:"endpoint_url"`
-	AuthType     AuthType       `gorm:"type:enum('bearer','api_key','custom');default:'bearer'" json:"auth_type"`
-	Credentials  string         `gorm:"type:text;not null" json:"-"` // 加密存储
+	AuthType     AuthType       `gorm:"type:enum('bearer','api_key','custom','none');default:'bearer'" json:"auth_type"`
+	Credentials  string         `gorm:"type:text" json:"-"` // 加密存储，AuthType 为 none 时可为空
+	AuthSpec     AuthSpec       `gorm:"type:json" json:"auth_spec"`
 	FieldMapping FieldMapping   `gorm:"type:json" json:"field_mapping"`
 	IsActive     bool           `gorm:"default:true" json:"is_active"`
 	CreatedAt    time.Time      `json:"created_at"`
@@ -56,7 +139,8 @@ type APIConfigRequest struct {
 	APIType      string       `json:"api_type" binding:"required"`
 	EndpointURL  string       `json:"endpoint_url" binding:"required,url"`
 	AuthType     AuthType     `json:"auth_type" binding:"required"`
-	Credentials  string       `json:"credentials" binding:"required"`
+	Credentials  string       `json:"credentials"` // AuthType 为 none 时可不填，多字段凭证使用 JSON 对象字符串
+	AuthSpec     AuthSpec     `json:"auth_spec"`
 	FieldMapping FieldMapping `json:"field_mapping"`
 	IsActive     bool         `json:"is_active"`
 }
@@ -67,6 +151,7 @@ type APIConfigResponse struct {
 	APIType      string       `json:"api_type"`
 	EndpointURL  string       `json:"endpoint_url"`
 	AuthType     AuthType     `json:"auth_type"`
+	AuthSpec     AuthSpec     `json:"auth_spec"`
 	FieldMapping FieldMapping `json:"field_mapping"`
 	IsActive     bool         `json:"is_active"`
 	CreatedAt    time.Time    `json:"created_at"`
@@ -79,9 +164,32 @@ func (a *APIConfig) ToResponse() APIConfigResponse {
 		APIType:      a.APIType,
 		EndpointURL:  a.EndpointURL,
 		AuthType:     a.AuthType,
+		AuthSpec:     a.AuthSpec.Redacted(),
 		FieldMapping: a.FieldMapping,
 		IsActive:     a.IsActive,
 		CreatedAt:    a.CreatedAt,
 	}
 }
 
+
+// APIConfigTestRequest 测试API配置的请求，Config 不为空时测试未保存的配置
+type APIConfigTestRequest struct {
+	ModelName string            `json:"model_name" binding:"required"`
+	Prompt    string            `json:"prompt"`
+	Config    *APIConfigRequest `json:"config"`
+}
+
+// APIConfigTestResult 测试API配置的结果，失败时 Stage 为出错的步骤
+type APIConfigTestResult struct {
+	Success      bool   `json:"success"`
+	Stage        string `json:"stage,omitempty"`
+	Error        string `json:"error,omitempty"`
+	RequestURL   string `json:"request_url,omitempty"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	LatencyMs    int64  `json:"latency_ms"`
+	RawResponse  string `json:"raw_response,omitempty"`
+	Content      string `json:"content"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	FinishReason string `json:"finish_reason,omitempty"`
+}
@@ -20,12 +20,14 @@ type Conversation struct {
 	Status      ConversationStatus `gorm:"type:enum('active','archived','deleted');default:'active';index" json:"status"`
 	TotalTokens int                `gorm:"default:0" json:"total_tokens"`
 	TotalCost   float64            `gorm:"type:decimal(10,6);default:0" json:"total_cost"`
-	CreatedAt   time.Time          `json:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at"`
-	DeletedAt   *time.Time         `json:"deleted_at"`
-	User        User               `gorm:"foreignKey:UserID" json:"-"`
-	Agent       Agent              `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
-	Messages    []Message          `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
+	// 当前分支最后一条消息
+	ActiveMessageID *uint      `json:"active_message_id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+	User            User       `gorm:"foreignKey:UserID" json:"-"`
+	Agent           Agent      `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	Messages        []Message  `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
 }
 
 type ConversationRequest struct {
@@ -34,28 +36,30 @@ type ConversationRequest struct {
 }
 
 type ConversationResponse struct {
-	ID           uint               `json:"id"`
-	AgentID      uint               `json:"agent_id"`
-	Title        string             `json:"title"`
-	Status       ConversationStatus `json:"status"`
-	TotalTokens  int                `json:"total_tokens"`
-	TotalCost    float64            `json:"total_cost"`
-	CreatedAt    time.Time          `json:"created_at"`
-	UpdatedAt    time.Time          `json:"updated_at"`
-	Agent        *AgentResponse     `json:"agent,omitempty"`
-	MessageCount int                `json:"message_count,omitempty"`
+	ID              uint               `json:"id"`
+	AgentID         uint               `json:"agent_id"`
+	Title           string             `json:"title"`
+	Status          ConversationStatus `json:"status"`
+	TotalTokens     int                `json:"total_tokens"`
+	TotalCost       float64            `json:"total_cost"`
+	ActiveMessageID *uint              `json:"active_message_id"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+	Agent           *AgentResponse     `json:"agent,omitempty"`
+	MessageCount    int                `json:"message_count,omitempty"`
 }
 
 func (c *Conversation) ToResponse() ConversationResponse {
 	resp := ConversationResponse{
-		ID:          c.ID,
-		AgentID:     c.AgentID,
-		Title:       c.Title,
-		Status:      c.Status,
-		TotalTokens: c.TotalTokens,
-		TotalCost:   c.TotalCost,
-		CreatedAt:   c.CreatedAt,
-		UpdatedAt:   c.UpdatedAt,
+		ID:              c.ID,
+		AgentID:         c.AgentID,
+		Title:           c.Title,
+		Status:          c.Status,
+		TotalTokens:     c.TotalTokens,
+		TotalCost:       c.TotalCost,
+		ActiveMessageID: c.ActiveMessageID,
+		CreatedAt:       c.CreatedAt,
+		UpdatedAt:       c.UpdatedAt,
 	}
 
 	if c.Agent.ID != 0 {
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+// FileKind 上传文件的类别
+type FileKind string
+
+const (
+	FileKindImage    FileKind = "image"
+	FileKindDocument FileKind = "document"
+)
+
+// File 用户上传的文件，文档类文件在上传时提取文本供对话使用
+type File struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	UserID         uint      `gorm:"not null;index" json:"user_id"`
+	Name           string    `gorm:"size:255;not null" json:"name"`
+	MimeType       string    `gorm:"size:100;not null" json:"mime_type"`
+	Kind           FileKind  `gorm:"size:20;not null" json:"kind"`
+	Size           int64     `gorm:"not null" json:"size"`
+	StorageBackend string    `gorm:"size:20;not null" json:"-"`
+	StorageKey     string    `gorm:"size:255;not null" json:"-"`
+	ExtractedText  string    `gorm:"type:longtext" json:"-"`
+	TextLength     int       `gorm:"default:0" json:"text_length"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	User           User      `gorm:"foreignKey:UserID" json:"-"`
+}
+
+type FileResponse struct {
+	ID         uint      `json:"id"`
+	Name       string    `json:"name"`
+	MimeType   string    `json:"mime_type"`
+	Kind       FileKind  `json:"kind"`
+	Size       int64     `json:"size"`
+	TextLength int       `json:"text_length"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type FileListResponse struct {
+	Files     []FileResponse `json:"files"`
+	UsedBytes int64          `json:"used_bytes"`
+	Quota     int64          `json:"quota"`
+}
+
+func (f *File) ToResponse() FileResponse {
+	return FileResponse{
+		ID:         f.ID,
+		Name:       f.Name,
+		MimeType:   f.MimeType,
+		Kind:       f.Kind,
+		Size:       f.Size,
+		TextLength: f.TextLength,
+		CreatedAt:  f.CreatedAt,
+	}
+}
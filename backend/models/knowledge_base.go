@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// Vector 文本向量，数据库中以 float32 小端字节序存储
+type Vector []float32
+
+func (v Vector) Value() (driver.Value, error) {
+	buf := make([]byte, 4*len(v))
+	for i, value := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(value))
+	}
+	return buf, nil
+}
+
+func (v *Vector) Scan(value interface{}) error {
+	if value == nil {
+		*v = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	if len(bytes)%4 != 0 {
+		return errors.New("invalid vector length")
+	}
+	vector := make(Vector, len(bytes)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(bytes[4*i:]))
+	}
+	*v = vector
+	return nil
+}
+
+// KnowledgeDocumentStatus 知识库文档的索引状态
+type KnowledgeDocumentStatus string
+
+const (
+	KnowledgeDocumentProcessing KnowledgeDocumentStatus = "processing"
+	KnowledgeDocumentReady      KnowledgeDocumentStatus = "ready"
+	KnowledgeDocumentFailed     KnowledgeDocumentStatus = "failed"
+)
+
+// KnowledgeBase 知识库：文档切分后通过 API 配置的 embeddings 接口计算向量，供 rag 智能体检索
+type KnowledgeBase struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	UserID         uint       `gorm:"not null;index" json:"user_id"`
+	Name           string     `gorm:"size:100;not null" json:"name"`
+	Description    string     `gorm:"type:text" json:"description"`
+	APIConfigID    *uint      `gorm:"index" json:"api_config_id"`
+	EmbeddingModel string     `gorm:"size:100;not null" json:"embedding_model"`
+	Dimensions     int        `gorm:"default:0" json:"dimensions"` // 首个文档索引后确定
+	ChunkSize      int        `gorm:"default:800" json:"chunk_size"`
+	ChunkOverlap   int        `gorm:"default:100" json:"chunk_overlap"`
+	DocumentCount  int        `gorm:"default:0" json:"document_count"`
+	ChunkCount     int        `gorm:"default:0" json:"chunk_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	User           User       `gorm:"foreignKey:UserID" json:"-"`
+	APIConfig      *APIConfig `gorm:"foreignKey:APIConfigID" json:"-"`
+}
+
+// KnowledgeDocument 知识库中的文档（来自上传的文件）
+type KnowledgeDocument struct {
+	ID              uint                    `gorm:"primarykey" json:"id"`
+	KnowledgeBaseID uint                    `gorm:"not null;index" json:"knowledge_base_id"`
+	FileID          *uint                   `gorm:"index" json:"file_id"`
+	Name            string                  `gorm:"size:255;not null" json:"name"`
+	Status          KnowledgeDocumentStatus `gorm:"size:20;not null" json:"status"`
+	Error           string                  `gorm:"type:text" json:"error,omitempty"`
+	ChunkCount      int                     `gorm:"default:0" json:"chunk_count"`
+	CreatedAt       time.Time               `json:"created_at"`
+	UpdatedAt       time.Time               `json:"updated_at"`
+}
+
+// KnowledgeChunk 文档分段及其向量
+type KnowledgeChunk struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	KnowledgeBaseID uint      `gorm:"not null;index" json:"knowledge_base_id"`
+	DocumentID      uint      `gorm:"not null;index" json:"document_id"`
+	ChunkIndex      int       `gorm:"not null" json:"chunk_index"`
+	Content         string    `gorm:"type:text;not null" json:"content"`
+	Embedding       Vector    `gorm:"type:mediumblob" json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type KnowledgeBaseRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	APIConfigID    *uint  `json:"api_config_id" binding:"required"`
+	EmbeddingModel string `json:"embedding_model" binding:"required"`
+	ChunkSize      int    `json:"chunk_size"`
+	ChunkOverlap   int    `json:"chunk_overlap"`
+}
+
+// KnowledgeDocumentRequest 将已上传的文件加入知识库（也可以直接以 multipart 上传文件）
+type KnowledgeDocumentRequest struct {
+	FileID uint `json:"file_id" binding:"required"`
+}
+
+type KnowledgeSearchRequest struct {
+	Query string `json:"query" binding:"required"`
+	TopK  int    `json:"top_k"`
+}
+
+type KnowledgeBaseResponse struct {
+	ID             uint                `json:"id"`
+	Name           string              `json:"name"`
+	Description    string              `json:"description"`
+	APIConfigID    *uint               `json:"api_config_id"`
+	EmbeddingModel string              `json:"embedding_model"`
+	Dimensions     int                 `json:"dimensions"`
+	ChunkSize      int                 `json:"chunk_size"`
+	ChunkOverlap   int                 `json:"chunk_overlap"`
+	DocumentCount  int                 `json:"document_count"`
+	ChunkCount     int                 `json:"chunk_count"`
+	Documents      []KnowledgeDocument `json:"documents,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+}
+
+func (kb *KnowledgeBase) ToResponse() KnowledgeBaseResponse {
+	return KnowledgeBaseResponse{
+		ID:             kb.ID,
+		Name:           kb.Name,
+		Description:    kb.Description,
+		APIConfigID:    kb.APIConfigID,
+		EmbeddingModel: kb.EmbeddingModel,
+		Dimensions:     kb.Dimensions,
+		ChunkSize:      kb.ChunkSize,
+		ChunkOverlap:   kb.ChunkOverlap,
+		DocumentCount:  kb.DocumentCount,
+		ChunkCount:     kb.ChunkCount,
+		CreatedAt:      kb.CreatedAt,
+		UpdatedAt:      kb.UpdatedAt,
+	}
+}
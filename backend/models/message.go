@@ -48,9 +48,23 @@ func (m *Metadata) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, m)
 }
 
+// RequestAttempt 一次模型请求尝试的记录（重试和故障转移时会有多条），保存在消息的 Metadata.attempts 中
+type RequestAttempt struct {
+	APIConfigID uint      `json:"api_config_id"`
+	APIType     string    `json:"api_type"`
+	ModelName   string    `json:"model_name"`
+	Attempt     int       `json:"attempt"` // 同一配置下的第几次尝试，从 1 开始
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	LatencyMs   int64     `json:"latency_ms"`
+	StartedAt   time.Time `json:"started_at"`
+	Success     bool      `json:"success"`
+}
+
 type Message struct {
 	ID             uint        `gorm:"primarykey" json:"id"`
 	ConversationID uint        `gorm:"not null;index" json:"conversation_id"`
+	ParentID       *uint       `gorm:"index" json:"parent_id"` // 上一条消息，多条消息的 ParentID 相同时形成分支
 	Role           MessageRole `gorm:"type:enum('user','assistant','system');not null" json:"role"`
 	Content        string      `gorm:"type:text;not null" json:"content"`
 	Attachments    Attachments `gorm:"type:json" json:"attachments"`
@@ -66,9 +80,16 @@ type MessageRequest struct {
 	Attachments Attachments `json:"attachments"`
 }
 
+// MessageEditRequest 编辑用户消息，未提供附件时沿用原消息的附件
+type MessageEditRequest struct {
+	Content     string      `json:"content" binding:"required"`
+	Attachments Attachments `json:"attachments"`
+}
+
 type MessageResponse struct {
 	ID             uint        `json:"id"`
 	ConversationID uint        `json:"conversation_id"`
+	ParentID       *uint       `json:"parent_id"`
 	Role           MessageRole `json:"role"`
 	Content        string      `json:"content"`
 	Attachments    Attachments `json:"attachments"`
@@ -76,12 +97,17 @@ type MessageResponse struct {
 	OutputTokens   int         `json:"output_tokens"`
 	Metadata       Metadata    `json:"metadata"`
 	CreatedAt      time.Time   `json:"created_at"`
+	// 分支信息：同一父消息下的兄弟消息（包括自身），按创建顺序排列
+	SiblingIDs   []uint `json:"sibling_ids,omitempty"`
+	SiblingIndex int    `json:"sibling_index"`
+	SiblingCount int    `json:"sibling_count"`
 }
 
 func (m *Message) ToResponse() MessageResponse {
 	return MessageResponse{
 		ID:             m.ID,
 		ConversationID: m.ConversationID,
+		ParentID:       m.ParentID,
 		Role:           m.Role,
 		Content:        m.Content,
 		Attachments:    m.Attachments,
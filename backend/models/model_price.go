@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// ModelPrice 模型定价（价格单位：每百万 Token）
+type ModelPrice struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	ModelPattern     string    `gorm:"size:200;not null;index" json:"model_pattern"` // 模型名称，支持 * 通配符，例如 openai/gpt-4o*
+	InputPrice       float64   `gorm:"type:decimal(12,6);default:0" json:"input_price"`
+	OutputPrice      float64   `gorm:"type:decimal(12,6);default:0" json:"output_price"`
+	CachedInputPrice float64   `gorm:"type:decimal(12,6);default:0" json:"cached_input_price"`
+	Currency         string    `gorm:"size:10;default:'USD'" json:"currency"`
+	EffectiveDate    time.Time `gorm:"type:date;not null;index" json:"effective_date"`
+	Source           string    `gorm:"size:50;default:'manual'" json:"source"` // manual, openrouter
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type ModelPriceRequest struct {
+	ModelPattern     string   `json:"model_pattern" binding:"required"`
+	InputPrice       float64  `json:"input_price" binding:"min=0"`
+	OutputPrice      float64  `json:"output_price" binding:"min=0"`
+	CachedInputPrice *float64 `json:"cached_input_price" binding:"omitempty,min=0"` // 不填时与输入价格相同
+	Currency         string   `json:"currency"`
+	EffectiveDate    string   `json:"effective_date"` // YYYY-MM-DD，默认当天
+}
+
+// MessageCost 单条消息的成本明细（保存在 Message.Metadata 中）
+type MessageCost struct {
+	Model        string  `json:"model"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	InputCost    float64 `json:"input_cost"`
+	OutputCost   float64 `json:"output_cost"`
+	TotalCost    float64 `json:"total_cost"`
+	Currency     string  `json:"currency"`
+	PriceID      *uint   `json:"price_id,omitempty"` // 为空表示使用默认价格
+}
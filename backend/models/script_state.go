@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+)
+
+// ScriptValues 脚本 KV 状态，值为 JSON 编码的数据
+type ScriptValues map[string]json.RawMessage
+
+func (v ScriptValues) Value() (driver.Value, error) {
+	return json.Marshal(v)
+}
+
+func (v *ScriptValues) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+// ScriptState 自定义代码智能体在一个对话中的 KV 状态，脚本执行成功后保存，供同一对话的后续执行读取
+type ScriptState struct {
+	ID             uint         `gorm:"primarykey" json:"id"`
+	AgentID        uint         `gorm:"not null;uniqueIndex:idx_script_state_scope" json:"agent_id"`
+	ConversationID uint         `gorm:"not null;uniqueIndex:idx_script_state_scope" json:"conversation_id"`
+	Values         ScriptValues `gorm:"type:json" json:"values"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
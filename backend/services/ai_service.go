@@ -1,7 +1,9 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,102 +12,237 @@ import (
 	"strings"
 
 	"ai-chat-backend/models"
+	"ai-chat-backend/tokenizer"
+	"ai-chat-backend/utils"
 )
 
 type AIService struct {
-	client *http.Client
+	client       *http.Client
+	streamClient *http.Client
+	retry        retryPolicy
+	attempts     *attemptLog
+	tools        *ToolRegistry
+	images       *imageCache
 }
 
 func NewAIService() *AIService {
+	client, streamClient, retry := newAIHTTPClients()
 	return &AIService{
-		client: &http.Client{},
+		client:       client,
+		streamClient: streamClient,
+		retry:        retry,
+		attempts:     &attemptLog{},
+		tools:        DefaultToolRegistry,
+		images:       newImageCache(),
 	}
 }
 
-// Chat 非流式对话
-func (s *AIService) Chat(agent models.Agent, messages []models.Message) (string, int, int, error) {
-	// 构建请求
-	req, err := s.buildRequest(agent, messages, false)
-	if err != nil {
-		return "", 0, 0, err
+// maxToolIterations 单次对话中工具调用的最大轮数
+const maxToolIterations = 5
+
+// Chat 非流式对话（Agent 配置了工具时会自动执行工具调用循环）
+func (s *AIService) Chat(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
+	chatMessages := s.buildChatMessages(agent, messages)
+	tools := s.tools.Definitions(agent.Tools)
+
+	totalInputTokens := 0
+	totalOutputTokens := 0
+
+	for i := 0; i <= maxToolIterations; i++ {
+		// 达到上限后不再提供工具，要求模型直接给出回答
+		if i == maxToolIterations {
+			tools = nil
+		}
+
+		result, err := s.doChat(ctx, agent, chatMessages, tools)
+		if err != nil {
+			return "", totalInputTokens, totalOutputTokens, err
+		}
+
+		// 提取Token使用量（服务商未返回时使用本地估算）
+		inputTokens, outputTokens := result.InputTokens, result.OutputTokens
+		if inputTokens == 0 && outputTokens == 0 {
+			inputTokens, outputTokens = s.estimateTokens(agent.ModelName, chatMessages, s.responseText(result))
+		}
+		totalInputTokens += inputTokens
+		totalOutputTokens += outputTokens
+
+		if len(result.ToolCalls) == 0 {
+			return result.Content, totalInputTokens, totalOutputTokens, nil
+		}
+
+		// 保留模型的工具调用消息，并追加每个工具的执行结果
+		chatMessages = append(chatMessages, map[string]interface{}{
+			"role":       "assistant",
+			"content":    result.Content,
+			"tool_calls": result.ToolCalls,
+		})
+		for _, call := range result.ToolCalls {
+			chatMessages = append(chatMessages, s.executeToolCall(ctx, call))
+		}
 	}
 
-	// 发送请求
-	resp, err := s.client.Do(req)
+	return "", totalInputTokens, totalOutputTokens, errors.New("工具调用次数超过上限")
+}
+
+// doChat 发送一次非流式请求并解析响应
+func (s *AIService) doChat(ctx context.Context, agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}) (*ChatResult, error) {
+	// 发送请求（失败时自动重试和切换备用配置）
+	resp, served, err := s.send(ctx, agent, chatMessages, tools, false)
 	if err != nil {
-		return "", 0, 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", 0, 0, fmt.Errorf("API请求失败: %s", string(body))
-	}
-
 	// 解析响应
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", 0, 0, err
+		return nil, err
 	}
 
-	// 提取内容
-	content, err := s.extractContent(result)
+	return providerForConfig(served.APIConfig).ParseResponse(result)
+}
+
+// complete 发送一次不提供工具的非流式请求，返回结果和Token使用量（服务商未返回时使用本地估算）
+func (s *AIService) complete(ctx context.Context, agent models.Agent, chatMessages []map[string]interface{}) (*ChatResult, int, int, error) {
+	result, err := s.doChat(ctx, agent, chatMessages, nil)
 	if err != nil {
-		return "", 0, 0, err
+		return nil, 0, 0, err
+	}
+	inputTokens, outputTokens := result.InputTokens, result.OutputTokens
+	if inputTokens == 0 && outputTokens == 0 {
+		inputTokens, outputTokens = s.estimateTokens(agent.ModelName, chatMessages, result.Content)
+	}
+	return result, inputTokens, outputTokens, nil
+}
+
+// executeToolCall 执行模型请求的工具调用，返回 tool 角色消息
+func (s *AIService) executeToolCall(ctx context.Context, call map[string]interface{}) map[string]interface{} {
+	callID, _ := call["id"].(string)
+	function, _ := call["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	arguments, _ := function["arguments"].(string)
+
+	output, err := s.tools.Execute(ctx, name, arguments)
+	if err != nil {
+		// 将错误信息返回给模型，由模型决定如何继续
+		output = "工具执行失败: " + err.Error()
 	}
 
-	// 提取Token使用量
-	inputTokens, outputTokens := s.extractTokens(result)
+	return map[string]interface{}{
+		"role":         "tool",
+		"tool_call_id": callID,
+		"name":         name,
+		"content":      output,
+	}
+}
 
-	return content, inputTokens, outputTokens, nil
+// ChatStream 流式对话，ctx 取消时中断读取
+func (s *AIService) ChatStream(ctx context.Context, agent models.Agent, messages []models.Message) (io.ReadCloser, error) {
+	stream, _, err := s.openStream(ctx, agent, messages)
+	return stream, err
 }
 
-// ChatStream 流式对话
-func (s *AIService) ChatStream(agent models.Agent, messages []models.Message) (io.ReadCloser, error) {
-	// 构建请求
-	req, err := s.buildRequest(agent, messages, true)
+// openStream 发起流式请求（开始接收数据前失败时自动重试和切换备用配置），返回响应流和实际使用的配置
+func (s *AIService) openStream(ctx context.Context, agent models.Agent, messages []models.Message) (io.ReadCloser, models.Agent, error) {
+	resp, served, err := s.send(ctx, agent, s.buildChatMessages(agent, messages), nil, true)
 	if err != nil {
-		return nil, err
+		return nil, agent, err
 	}
+	return resp.Body, served, nil
+}
 
-	// 发送请求
-	resp, err := s.client.Do(req)
+// StreamChat 流式对话，逐块回调内容，返回完整内容和Token使用量
+// ctx 取消时停止读取，返回已收到的部分内容和 ctx 的错误
+func (s *AIService) StreamChat(ctx context.Context, agent models.Agent, messages []models.Message, onContent func(content string)) (string, int, int, error) {
+	stream, served, err := s.openStream(ctx, agent, messages)
 	if err != nil {
-		return nil, err
+		return "", 0, 0, err
+	}
+	defer stream.Close()
+
+	// 读取流式响应，由实际使用的配置对应的服务商适配器解析每一行
+	provider := providerForConfig(served.APIConfig)
+	var fullResponse strings.Builder
+	inputTokens, outputTokens := 0, 0
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		delta, done := provider.ParseStreamLine(scanner.Text())
+		if delta.Content != "" {
+			fullResponse.WriteString(delta.Content)
+			onContent(delta.Content)
+		}
+
+		// 使用量可能分多次返回（例如输入和输出Token分别在开始和结束时返回）
+		if delta.InputTokens > 0 {
+			inputTokens = delta.InputTokens
+		}
+		if delta.OutputTokens > 0 {
+			outputTokens = delta.OutputTokens
+		}
+
+		if done {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		return fullResponse.String(), inputTokens, outputTokens, ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fullResponse.String(), inputTokens, outputTokens, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, fmt.Errorf("API请求失败: %s", string(body))
+	// 服务商未返回使用量时，使用本地估算
+	if inputTokens == 0 && outputTokens == 0 {
+		inputTokens, outputTokens = s.estimateTokens(served.ModelName, s.buildChatMessages(agent, messages), fullResponse.String())
 	}
 
-	return resp.Body, nil
+	return fullResponse.String(), inputTokens, outputTokens, nil
 }
 
-// buildRequest 构建API请求
-func (s *AIService) buildRequest(agent models.Agent, messages []models.Message, stream bool) (*http.Request, error) {
-	// 获取API配置
-	var apiConfig *models.APIConfig
-	var endpointURL string
-	var apiKey string
+// estimateTokens 使用本地分词器估算请求和回复的Token数量
+func (s *AIService) estimateTokens(modelName string, chatMessages []map[string]interface{}, response string) (int, int) {
+	return tokenizer.CountMessageTokens(modelName, messageContents(chatMessages)), tokenizer.CountTokens(modelName, response)
+}
 
-	// 检查是否配置了 API
-	if agent.APIConfig == nil {
-		return nil, errors.New("该智能体未配置 API。请先在 'API 配置' 页面创建 API 配置，然后在智能体设置中选择该配置")
+// checkContextLength 预检请求是否超出模型的上下文长度（仅在分词器词表可用时检查）
+func (s *AIService) checkContextLength(agent models.Agent, chatMessages []map[string]interface{}) error {
+	contextLength := ModelContextLength(agent.ModelName)
+	if contextLength == 0 || !tokenizer.ForModel(agent.ModelName).Exact() {
+		return nil
 	}
 
-	// 使用智能体关联的 API 配置
-	apiConfig = agent.APIConfig
-	endpointURL = apiConfig.EndpointURL
-	apiKey = apiConfig.Credentials
+	promptTokens := tokenizer.CountMessageTokens(agent.ModelName, messageContents(chatMessages))
+	if promptTokens+agent.ModelParams.MaxTokens > contextLength {
+		return fmt.Errorf("消息长度约 %d Tokens，加上预留的 %d 输出 Tokens 超出模型 %s 的上下文长度限制（%d Tokens），请开启新对话或减少输入内容",
+			promptTokens, agent.ModelParams.MaxTokens, agent.ModelName, contextLength)
+	}
+	return nil
+}
 
-	// 验证用户配置的 API Key
-	if apiKey == "" {
-		return nil, errors.New("API 配置中未设置 API 密钥。请在 'API 配置' 页面编辑该配置并填写 API 密钥")
+// messageContents 提取消息列表中的文本内容（包括工具调用参数）
+func messageContents(chatMessages []map[string]interface{}) []string {
+	contents := make([]string, 0, len(chatMessages))
+	for _, msg := range chatMessages {
+		text := contentText(msg["content"])
+		if toolCalls, ok := msg["tool_calls"].([]map[string]interface{}); ok {
+			for _, call := range toolCalls {
+				function, _ := call["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				arguments, _ := function["arguments"].(string)
+				text += name + arguments
+			}
+		}
+		contents = append(contents, text)
 	}
+	return contents
+}
 
-	// 构建消息列表
+// buildChatMessages 构建消息列表（系统提示词 + 历史消息）
+func (s *AIService) buildChatMessages(agent models.Agent, messages []models.Message) []map[string]interface{} {
 	var chatMessages []map[string]interface{}
 
 	// 添加系统提示词
@@ -116,41 +253,60 @@ func (s *AIService) buildRequest(agent models.Agent, messages []models.Message,
 		})
 	}
 
-	// 添加历史消息
+	// 添加历史消息（用户消息的图片附件转换为 image_url 内容块，文档附件注入提取的文本）
 	for _, msg := range messages {
+		var content interface{} = msg.Content
+		if msg.Role == models.RoleUser {
+			content = s.userMessageContent(msg)
+		}
 		chatMessages = append(chatMessages, map[string]interface{}{
 			"role":    string(msg.Role),
-			"content": msg.Content,
+			"content": content,
 		})
 	}
 
-	// 构建请求体
-	requestBody := map[string]interface{}{
-		"model":    agent.ModelName,
-		"messages": chatMessages,
-		"stream":   stream,
-	}
+	return chatMessages
+}
 
-	// 添加模型参数
-	if agent.ModelParams.Temperature > 0 {
-		requestBody["temperature"] = agent.ModelParams.Temperature
+// buildChatRequest 根据消息列表和工具定义构建API请求
+func (s *AIService) buildChatRequest(ctx context.Context, agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) (*http.Request, error) {
+	// 检查是否配置了 API
+	if agent.APIConfig == nil {
+		return nil, errors.New("该智能体未配置 API。请先在 'API 配置' 页面创建 API 配置，然后在智能体设置中选择该配置")
 	}
-	if agent.ModelParams.MaxTokens > 0 {
-		requestBody["max_tokens"] = agent.ModelParams.MaxTokens
+
+	// 使用智能体关联的 API 配置
+	apiConfig := agent.APIConfig
+	apiKey, err := configAPIKey(apiConfig)
+	if err != nil {
+		return nil, err
 	}
-	if agent.ModelParams.TopP > 0 {
-		requestBody["top_p"] = agent.ModelParams.TopP
+
+	// 预检上下文长度
+	if err := s.checkContextLength(agent, chatMessages); err != nil {
+		return nil, err
 	}
-	if agent.ModelParams.FrequencyPenalty != 0 {
-		requestBody["frequency_penalty"] = agent.ModelParams.FrequencyPenalty
+
+	// 检查模型是否支持图片输入
+	if err := s.checkImageSupport(agent, chatMessages); err != nil {
+		return nil, err
 	}
-	if agent.ModelParams.PresencePenalty != 0 {
-		requestBody["presence_penalty"] = agent.ModelParams.PresencePenalty
+
+	// 由服务商适配器构建请求体（只接受 base64 图片的服务商先下载图片）
+	provider := providerForConfig(apiConfig)
+	if inline, ok := provider.(inlineImageProvider); ok && inline.inlineImages() {
+		if chatMessages, err = s.inlineImages(ctx, chatMessages); err != nil {
+			return nil, err
+		}
 	}
+	requestBody := provider.RequestBody(agent, chatMessages, tools, stream)
 
 	// 应用字段映射（如果有自定义配置）
-	if apiConfig != nil && apiConfig.FieldMapping.RequestMapping != nil {
-		requestBody = s.applyFieldMapping(requestBody, apiConfig.FieldMapping.RequestMapping)
+	if len(apiConfig.FieldMapping.RequestMapping) > 0 {
+		requestBody, err = applyRequestMapping(requestBody, apiConfig.FieldMapping.RequestMapping, agent, chatMessages, tools, stream)
+		if err != nil {
+			return nil, &StageError{Stage: StageRequestMapping, Err: fmt.Errorf("请求映射配置错误: %w", err)}
+		}
 	}
 
 	// 序列化请求体
@@ -160,98 +316,40 @@ func (s *AIService) buildRequest(agent models.Agent, messages []models.Message,
 	}
 
 	// 创建HTTP请求
-	req, err := http.NewRequest("POST", endpointURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.RequestURL(apiConfig, agent.ModelName, stream), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
+	provider.SetHeaders(req, apiConfig, apiKey)
 
-	if apiConfig != nil {
-		switch apiConfig.AuthType {
-		case models.AuthBearer:
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-		case models.AuthAPIKey:
-			req.Header.Set("X-API-Key", apiKey)
+	// 自定义认证：按配置设置请求头、查询参数和签名
+	if apiConfig.AuthType == models.AuthCustom {
+		if err := applyCustomAuth(req, apiConfig.AuthSpec, apiKey, jsonData); err != nil {
+			return nil, &StageError{Stage: StageAuth, Err: fmt.Errorf("自定义认证配置错误: %w", err)}
 		}
-	} else {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
 	}
 
 	return req, nil
 }
 
-// extractContent 从响应中提取内容
-func (s *AIService) extractContent(result map[string]interface{}) (string, error) {
-	choices, ok := result["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return "", errors.New("无效的响应格式")
-	}
-
-	choice := choices[0].(map[string]interface{})
-	message, ok := choice["message"].(map[string]interface{})
-	if !ok {
-		return "", errors.New("无效的消息格式")
-	}
-
-	content, ok := message["content"].(string)
-	if !ok {
-		return "", errors.New("无法提取内容")
-	}
-
-	return content, nil
-}
-
-// extractTokens 从响应中提取Token使用量
-func (s *AIService) extractTokens(result map[string]interface{}) (int, int) {
-	usage, ok := result["usage"].(map[string]interface{})
-	if !ok {
-		return 0, 0
-	}
-
-	inputTokens := 0
-	outputTokens := 0
-
-	if val, ok := usage["prompt_tokens"].(float64); ok {
-		inputTokens = int(val)
+// configAPIKey 解密 API 配置的密钥（无需认证的本地服务允许为空）
+func configAPIKey(apiConfig *models.APIConfig) (string, error) {
+	apiKey, err := utils.DecryptCredential(apiConfig.Credentials)
+	if err != nil {
+		return "", &StageError{Stage: StageCredentials, Err: fmt.Errorf("读取 API 密钥失败: %w", err)}
 	}
-	if val, ok := usage["completion_tokens"].(float64); ok {
-		outputTokens = int(val)
+	if apiKey == "" && apiConfig.AuthType != models.AuthNone {
+		return "", &StageError{Stage: StageCredentials, Err: errors.New("API 配置中未设置 API 密钥。请在 'API 配置' 页面编辑该配置并填写 API 密钥")}
 	}
-
-	return inputTokens, outputTokens
+	return apiKey, nil
 }
 
-// applyFieldMapping 应用字段映射
-func (s *AIService) applyFieldMapping(data map[string]interface{}, mapping map[string]interface{}) map[string]interface{} {
-	// 简单的字段映射实现
-	// 实际使用中可能需要更复杂的映射逻辑
-	result := make(map[string]interface{})
-
-	for key, value := range data {
-		if mappedKey, ok := mapping[key].(string); ok {
-			result[mappedKey] = value
-		} else {
-			result[key] = value
-		}
-	}
-
-	return result
+// responseText 提取响应中用于估算输出Token的文本（回复内容和工具调用）
+func (s *AIService) responseText(result *ChatResult) string {
+	assistant := map[string]interface{}{"content": result.Content, "tool_calls": result.ToolCalls}
+	return messageContents([]map[string]interface{}{assistant})[0]
 }
 
-// CountTokens 估算Token数量（简单实现）
-func CountTokens(text string) int {
-	// 简单估算：英文约4字符=1token，中文约1.5字符=1token
-	// 实际应该使用tiktoken等专业库
-	words := strings.Fields(text)
-	chineseChars := 0
-
-	for _, char := range text {
-		if char >= 0x4e00 && char <= 0x9fa5 {
-			chineseChars++
-		}
-	}
-
-	return len(words) + chineseChars/2
-}
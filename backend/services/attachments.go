@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"ai-chat-backend/models"
+)
+
+// 消息附件（Message.Attachments）的格式：
+//
+//	{"type": "image", "url": "https://example.com/cat.png"}           图片地址（也可以是 data: URI）
+//	{"type": "image", "data": "<base64>", "mime_type": "image/png"}   客户端上传的图片数据
+//	{"type": "image", "file_id": 12}                                  通过 POST /api/files 上传的图片
+//	{"type": "file", "file_id": 13}                                   通过 POST /api/files 上传的文档
+//
+// 图片在内部以 OpenAI 的 image_url 内容块表示，由服务商适配器转换为各自的格式；
+// 文档在构建消息时将提取的文本注入到用户消息之前
+const (
+	AttachmentTypeImage = "image"
+	AttachmentTypeFile  = "file"
+
+	// maxImageBytes 单张图片的大小上限
+	maxImageBytes = 20 << 20
+	// maxImageCacheBytes 已下载图片缓存的总大小上限（data: URI 的长度）
+	maxImageCacheBytes = 64 << 20
+	// imageDownloadTimeout 下载单张图片的超时时间
+	imageDownloadTimeout = 30 * time.Second
+
+	// attachmentChunkSize 注入文档时的分段长度（字符）
+	attachmentChunkSize = 2000
+	// maxAttachmentChars 单个文档注入提示词的最大长度（字符），超出部分按分段截断
+	maxAttachmentChars = 24000
+
+	// imageTokenEstimate 上下文预算中每张图片的Token估算（OpenAI high detail 下 1024x1024 图片的开销）
+	imageTokenEstimate = 765
+)
+
+// ValidateAttachments 校验消息附件的格式
+func ValidateAttachments(attachments models.Attachments) error {
+	for i, attachment := range attachments {
+		attachmentType, _ := attachment["type"].(string)
+		_, hasFile := attachmentFileID(attachment)
+		if attachmentType == AttachmentTypeFile {
+			if !hasFile {
+				return fmt.Errorf("附件 %d: 文档附件需要提供 file_id", i+1)
+			}
+			continue
+		}
+		if attachmentType != AttachmentTypeImage {
+			continue
+		}
+
+		url, _ := attachment["url"].(string)
+		data, _ := attachment["data"].(string)
+		switch {
+		case hasFile:
+		case url != "":
+			if strings.HasPrefix(url, "data:") {
+				if _, _, err := parseImageDataURI(url); err != nil {
+					return fmt.Errorf("附件 %d: %w", i+1, err)
+				}
+			} else if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				return fmt.Errorf("附件 %d: 图片地址必须是 http(s) 或 data: URI", i+1)
+			}
+		case data != "":
+			mimeType, _ := attachment["mime_type"].(string)
+			if !strings.HasPrefix(mimeType, "image/") {
+				return fmt.Errorf("附件 %d: mime_type 必须是图片类型", i+1)
+			}
+			if base64.StdEncoding.DecodedLen(len(data)) > maxImageBytes {
+				return fmt.Errorf("附件 %d: 图片超过 %d MB", i+1, maxImageBytes>>20)
+			}
+			if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+				return fmt.Errorf("附件 %d: 图片数据不是有效的 base64", i+1)
+			}
+		default:
+			return fmt.Errorf("附件 %d: 图片需要提供 url、data 或 file_id", i+1)
+		}
+	}
+	return nil
+}
+
+// imageAttachmentURL 返回图片附件的地址（上传的数据转换为 data: URI）
+func imageAttachmentURL(attachment map[string]interface{}) (string, bool) {
+	if attachmentType, _ := attachment["type"].(string); attachmentType != AttachmentTypeImage {
+		return "", false
+	}
+	if url, _ := attachment["url"].(string); url != "" {
+		return url, true
+	}
+	data, _ := attachment["data"].(string)
+	mimeType, _ := attachment["mime_type"].(string)
+	if data == "" || mimeType == "" {
+		return "", false
+	}
+	return "data:" + mimeType + ";base64," + data, true
+}
+
+// attachmentFileID 读取附件引用的文件 ID
+func attachmentFileID(attachment map[string]interface{}) (uint, bool) {
+	switch id := attachment["file_id"].(type) {
+	case float64:
+		if id > 0 && id == float64(uint(id)) {
+			return uint(id), true
+		}
+	case int:
+		if id > 0 {
+			return uint(id), true
+		}
+	case uint:
+		return id, id > 0
+	}
+	return 0, false
+}
+
+// userMessageContent 构建用户消息的内容：文档附件的文本注入在消息之前；
+// 没有图片时为字符串，有图片时为文本和 image_url 内容块
+func (s *AIService) userMessageContent(msg models.Message) interface{} {
+	files := loadAttachmentFiles(msg.Attachments)
+
+	var parts []map[string]interface{}
+	for _, attachment := range msg.Attachments {
+		fileID, hasFile := attachmentFileID(attachment)
+		attachmentType, _ := attachment["type"].(string)
+
+		switch {
+		case attachmentType == AttachmentTypeImage && hasFile:
+			if url, ok := s.fileImageURL(files[fileID]); ok {
+				parts = append(parts, imageURLPart(url))
+			}
+		default:
+			if url, ok := imageAttachmentURL(attachment); ok {
+				parts = append(parts, imageURLPart(url))
+			}
+		}
+	}
+
+	text := userMessageText(msg, files)
+	if len(parts) == 0 {
+		return text
+	}
+
+	if text != "" {
+		parts = append([]map[string]interface{}{{"type": "text", "text": text}}, parts...)
+	}
+	return parts
+}
+
+// userMessageText 用户消息的文本部分：文档附件的文本注入在消息之前
+func userMessageText(msg models.Message, files map[uint]*models.File) string {
+	var documents []string
+	for _, attachment := range msg.Attachments {
+		attachmentType, _ := attachment["type"].(string)
+		if fileID, ok := attachmentFileID(attachment); ok && attachmentType == AttachmentTypeFile {
+			documents = append(documents, documentPrompt(attachment, files[fileID]))
+		}
+	}
+
+	if len(documents) == 0 {
+		return msg.Content
+	}
+	return strings.Join(documents, "\n\n") + "\n\n" + msg.Content
+}
+
+// imageAttachmentCount 用户消息中会发送给模型的图片数量
+func imageAttachmentCount(attachments models.Attachments, files map[uint]*models.File) int {
+	count := 0
+	for _, attachment := range attachments {
+		attachmentType, _ := attachment["type"].(string)
+		if fileID, ok := attachmentFileID(attachment); ok && attachmentType == AttachmentTypeImage {
+			if file := files[fileID]; file != nil && file.Kind == models.FileKindImage {
+				count++
+			}
+			continue
+		}
+		if _, ok := imageAttachmentURL(attachment); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func imageURLPart(url string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "image_url",
+		"image_url": map[string]interface{}{"url": url},
+	}
+}
+
+// documentPrompt 将文档附件转换为提示词，过长的文档只保留前面的分段
+func documentPrompt(attachment map[string]interface{}, file *models.File) string {
+	name, _ := attachment["name"].(string)
+	if file == nil {
+		return fmt.Sprintf("[附件: %s]\n（文件已被删除）", name)
+	}
+
+	chunks := chunkText(file.ExtractedText, attachmentChunkSize, 0)
+	var content strings.Builder
+	included, length := 0, 0
+	for _, chunk := range chunks {
+		chunkLength := utf8.RuneCountInString(chunk)
+		if included > 0 && length+chunkLength > maxAttachmentChars {
+			break
+		}
+		if included > 0 {
+			content.WriteString("\n")
+		}
+		content.WriteString(chunk)
+		included++
+		length += chunkLength
+	}
+
+	prompt := fmt.Sprintf("[附件: %s]\n%s", file.Name, content.String())
+	if included < len(chunks) {
+		prompt += fmt.Sprintf("\n（文件内容过长，以上仅包含前 %d/%d 段）", included, len(chunks))
+	}
+	return prompt + fmt.Sprintf("\n[附件结束: %s]", file.Name)
+}
+
+// fileImageURL 读取上传的图片文件并转换为 data: URI
+func (s *AIService) fileImageURL(file *models.File) (string, bool) {
+	if file == nil || file.Kind != models.FileKindImage {
+		return "", false
+	}
+
+	cacheKey := fmt.Sprintf("file:%d", file.ID)
+	if cached, ok := s.images.get(cacheKey); ok {
+		return cached, true
+	}
+
+	data, err := readStoredFile(file.StorageBackend, file.StorageKey)
+	if err != nil {
+		return "", false
+	}
+	dataURI := "data:" + file.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	s.images.put(cacheKey, dataURI)
+	return dataURI, true
+}
+
+// contentParts 返回消息的内容块，内容为字符串时返回 nil
+func contentParts(content interface{}) []map[string]interface{} {
+	parts, _ := content.([]map[string]interface{})
+	return parts
+}
+
+// contentText 提取消息内容中的文本
+func contentText(content interface{}) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	var texts []string
+	for _, part := range contentParts(content) {
+		if text, ok := part["text"].(string); ok && part["type"] == "text" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// partImageURL 读取 image_url 内容块中的地址
+func partImageURL(part map[string]interface{}) (string, bool) {
+	if part["type"] != "image_url" {
+		return "", false
+	}
+	imageURL, _ := part["image_url"].(map[string]interface{})
+	url, _ := imageURL["url"].(string)
+	return url, url != ""
+}
+
+// parseImageDataURI 解析 data:image/png;base64,... 形式的图片地址
+func parseImageDataURI(uri string) (mimeType string, data string, err error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", "", errors.New("图片 data: URI 必须使用 base64 编码")
+	}
+	mimeType = strings.TrimSuffix(header, ";base64")
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", "", fmt.Errorf("不支持的图片类型 %s", mimeType)
+	}
+	return mimeType, data, nil
+}
+
+// hasImageParts 消息列表中是否包含图片
+func hasImageParts(chatMessages []map[string]interface{}) bool {
+	for _, msg := range chatMessages {
+		for _, part := range contentParts(msg["content"]) {
+			if _, ok := partImageURL(part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkImageSupport 包含图片时检查模型是否支持图片输入（未知模型交给服务商判断）
+func (s *AIService) checkImageSupport(agent models.Agent, chatMessages []map[string]interface{}) error {
+	if !hasImageParts(chatMessages) {
+		return nil
+	}
+	if supported, known := ModelSupportsImages(agent.ModelName); known && !supported {
+		return fmt.Errorf("模型 %s 不支持图片输入，请更换支持视觉的模型或移除图片附件", agent.ModelName)
+	}
+	return nil
+}
+
+// inlineImageProvider 只接受 base64 图片数据的服务商适配器，图片地址需要先下载
+type inlineImageProvider interface {
+	inlineImages() bool
+}
+
+// imageCache 缓存已下载的图片（重试和故障转移时不重复下载），总大小超过上限时淘汰最早加入的图片
+type imageCache struct {
+	mu    sync.Mutex
+	data  map[string]string // 图片地址 -> data: URI
+	order []string
+	size  int
+}
+
+func newImageCache() *imageCache {
+	return &imageCache{data: make(map[string]string)}
+}
+
+func (c *imageCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.data[key]
+	return value, ok
+}
+
+func (c *imageCache) put(key, value string) {
+	if len(value) > maxImageCacheBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.data[key]; ok {
+		return
+	}
+	for c.size+len(value) > maxImageCacheBytes && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.size -= len(c.data[oldest])
+		delete(c.data, oldest)
+	}
+	c.data[key] = value
+	c.order = append(c.order, key)
+	c.size += len(value)
+}
+
+// imageDownloadClient 下载图片附件的 HTTP 客户端：拒绝连接内网、回环和链路本地地址，
+// 在建立连接时检查解析后的地址，重定向和 DNS 重绑定也无法绕过；不使用代理
+var imageDownloadClient = &http.Client{
+	Timeout: imageDownloadTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   rejectInternalAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: imageDownloadTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// carrierGradeNAT 100.64.0.0/10 共享地址段
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// rejectInternalAddress 拒绝连接非公网地址
+func rejectInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("无法解析的图片地址: %s", host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip) {
+		return fmt.Errorf("不允许访问内网地址: %s", ip)
+	}
+	return nil
+}
+
+// inlineImages 将消息中的图片地址下载并替换为 data: URI
+func (s *AIService) inlineImages(ctx context.Context, chatMessages []map[string]interface{}) ([]map[string]interface{}, error) {
+	if !hasImageParts(chatMessages) {
+		return chatMessages, nil
+	}
+
+	inlined := make([]map[string]interface{}, 0, len(chatMessages))
+	for _, msg := range chatMessages {
+		parts := contentParts(msg["content"])
+		if parts == nil {
+			inlined = append(inlined, msg)
+			continue
+		}
+
+		converted := make([]map[string]interface{}, 0, len(parts))
+		for _, part := range parts {
+			url, ok := partImageURL(part)
+			if !ok || strings.HasPrefix(url, "data:") {
+				converted = append(converted, part)
+				continue
+			}
+			dataURI, err := s.downloadImage(ctx, url)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]interface{}{"url": dataURI},
+			})
+		}
+
+		copied := make(map[string]interface{}, len(msg))
+		for key, value := range msg {
+			copied[key] = value
+		}
+		copied["content"] = converted
+		inlined = append(inlined, copied)
+	}
+	return inlined, nil
+}
+
+// downloadImage 下载图片并转换为 data: URI
+func (s *AIService) downloadImage(ctx context.Context, url string) (string, error) {
+	if cached, ok := s.images.get(url); ok {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("图片地址无效: %w", err)
+	}
+	resp, err := imageDownloadClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载图片失败 (状态码: %d): %s", resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	if len(body) > maxImageBytes {
+		return "", fmt.Errorf("图片超过 %d MB: %s", maxImageBytes>>20, url)
+	}
+
+	mimeType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(body)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", fmt.Errorf("地址不是图片 (%s): %s", mimeType, url)
+	}
+
+	dataURI := "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(body)
+	s.images.put(url, dataURI)
+	return dataURI, nil
+}
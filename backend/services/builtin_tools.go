@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// calculatorTool 计算器工具，计算数学表达式
+type calculatorTool struct{}
+
+func (t *calculatorTool) Name() string {
+	return "calculator"
+}
+
+func (t *calculatorTool) Description() string {
+	return "计算数学表达式，支持 + - * / % ^、括号以及 sqrt、abs、sin、cos、tan、log、ln 等函数和常量 pi、e"
+}
+
+func (t *calculatorTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"expression": map[string]interface{}{
+				"type":        "string",
+				"description": "要计算的数学表达式，例如 (3 + 4) * 2",
+			},
+		},
+		"required": []string{"expression"},
+	}
+}
+
+func (t *calculatorTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("参数格式错误: %w", err)
+	}
+
+	result, err := evaluateExpression(args.Expression)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
+
+// currentTimeTool 获取当前时间
+type currentTimeTool struct{}
+
+func (t *currentTimeTool) Name() string {
+	return "current_time"
+}
+
+func (t *currentTimeTool) Description() string {
+	return "获取当前日期和时间，可指定 IANA 时区（例如 Asia/Shanghai）"
+}
+
+func (t *currentTimeTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"timezone": map[string]interface{}{
+				"type":        "string",
+				"description": "IANA 时区名称，默认使用服务器时区",
+			},
+		},
+	}
+}
+
+func (t *currentTimeTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("参数格式错误: %w", err)
+		}
+	}
+
+	now := time.Now()
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("无效的时区: %s", args.Timezone)
+		}
+		now = now.In(loc)
+	}
+
+	return now.Format("2006-01-02 15:04:05 Monday MST"), nil
+}
+
+// evaluateExpression 计算数学表达式
+func evaluateExpression(expression string) (float64, error) {
+	p := &expressionParser{input: []rune(expression)}
+	value, err := p.parseExpression()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("表达式中存在无法解析的字符: %s", string(p.input[p.pos:]))
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("计算结果无效")
+	}
+	return value, nil
+}
+
+// maxExpressionDepth 表达式的最大嵌套层数（括号、函数调用、正负号和乘方），避免过深的递归
+const maxExpressionDepth = 100
+
+// expressionParser 递归下降表达式解析器
+type expressionParser struct {
+	input []rune
+	pos   int
+	depth int
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *expressionParser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// parseExpression 解析加减法
+func (p *expressionParser) parseExpression() (float64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left += right
+		case '-':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left -= right
+		default:
+			return left, nil
+		}
+	}
+}
+
+// parseTerm 解析乘除法和取模
+func (p *expressionParser) parseTerm() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, errors.New("除数不能为零")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, errors.New("除数不能为零")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+// parseUnary 解析正负号（所有嵌套都会经过这里，在此限制嵌套层数）
+func (p *expressionParser) parseUnary() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return 0, fmt.Errorf("表达式嵌套超过 %d 层", maxExpressionDepth)
+	}
+
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower 解析乘方（右结合）
+func (p *expressionParser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+// parsePrimary 解析数字、括号、常量和函数调用
+func (p *expressionParser) parsePrimary() (float64, error) {
+	ch := p.peek()
+
+	switch {
+	case ch == '(':
+		p.pos++
+		value, err := p.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("缺少右括号")
+		}
+		p.pos++
+		return value, nil
+
+	case unicode.IsDigit(ch) || ch == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("无效的数字: %s", string(p.input[start:p.pos]))
+		}
+		return value, nil
+
+	case unicode.IsLetter(ch):
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos])) {
+			p.pos++
+		}
+		name := strings.ToLower(string(p.input[start:p.pos]))
+
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("未知的标识符: %s", name)
+		}
+		arg, err := p.parsePrimary()
+		if err != nil {
+			return 0, err
+		}
+		return applyMathFunction(name, arg)
+
+	case ch == 0:
+		return 0, errors.New("表达式不完整")
+
+	default:
+		return 0, fmt.Errorf("无法识别的字符: %c", ch)
+	}
+}
+
+// applyMathFunction 计算数学函数
+func applyMathFunction(name string, arg float64) (float64, error) {
+	switch name {
+	case "sqrt":
+		return math.Sqrt(arg), nil
+	case "abs":
+		return math.Abs(arg), nil
+	case "sin":
+		return math.Sin(arg), nil
+	case "cos":
+		return math.Cos(arg), nil
+	case "tan":
+		return math.Tan(arg), nil
+	case "log":
+		return math.Log10(arg), nil
+	case "ln":
+		return math.Log(arg), nil
+	case "exp":
+		return math.Exp(arg), nil
+	case "floor":
+		return math.Floor(arg), nil
+	case "ceil":
+		return math.Ceil(arg), nil
+	case "round":
+		return math.Round(arg), nil
+	default:
+		return 0, fmt.Errorf("不支持的函数: %s", name)
+	}
+}
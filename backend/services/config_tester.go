@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"ai-chat-backend/models"
+)
+
+// 测试API配置时可能出错的步骤
+const (
+	StageCredentials    = "credentials"      // 读取凭证
+	StageRequestMapping = "request_mapping"  // 应用请求映射
+	StageAuth           = "auth"             // 自定义认证
+	StageBuildRequest   = "build_request"    // 构建请求
+	StageSendRequest    = "send_request"     // 发送请求
+	StageHTTPStatus     = "http_status"      // 服务端返回错误状态码
+	StageDecodeResponse = "decode_response"  // 响应不是 JSON
+	StageParseResponse  = "parse_response"   // 服务商适配器解析响应
+	StageResponseMap    = "response_mapping" // 按响应映射解析响应
+)
+
+const (
+	// 测试请求默认使用的提示词和输出长度
+	defaultTestPrompt   = "Reply with the single word: pong"
+	testMaxTokens       = 64
+	testRequestTimeout  = 30 * time.Second
+	testResponseExcerpt = 2000
+)
+
+// StageError 标记错误发生在请求的哪个步骤
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// TestConfig 使用配置的认证方式和字段映射发送一条最简单的提示词，返回每个步骤的诊断信息
+// ctx 取消（例如客户端断开连接）时中止测试请求
+func (s *AIService) TestConfig(ctx context.Context, apiConfig *models.APIConfig, modelName, prompt string) *models.APIConfigTestResult {
+	if prompt == "" {
+		prompt = defaultTestPrompt
+	}
+	agent := models.Agent{
+		ModelName:   modelName,
+		APIConfig:   apiConfig,
+		ModelParams: models.ModelParams{MaxTokens: testMaxTokens},
+	}
+	chatMessages := []map[string]interface{}{{"role": "user", "content": prompt}}
+
+	result := &models.APIConfigTestResult{}
+	fail := func(stage string, err error) *models.APIConfigTestResult {
+		result.Stage = stage
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, testRequestTimeout)
+	defer cancel()
+
+	req, err := s.buildChatRequest(ctx, agent, chatMessages, nil, false)
+	if err != nil {
+		var stageErr *StageError
+		if errors.As(err, &stageErr) {
+			return fail(stageErr.Stage, stageErr.Err)
+		}
+		return fail(StageBuildRequest, err)
+	}
+	// 不返回查询参数，避免泄露认证信息
+	result.RequestURL = req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		result.LatencyMs = time.Since(start).Milliseconds()
+		return fail(StageSendRequest, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.StatusCode = resp.StatusCode
+	result.RawResponse = truncateUTF8(string(body), testResponseExcerpt)
+	if err != nil {
+		return fail(StageSendRequest, fmt.Errorf("读取响应失败: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fail(StageHTTPStatus, fmt.Errorf("API请求失败 (状态码: %d)", resp.StatusCode))
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return fail(StageDecodeResponse, fmt.Errorf("响应不是有效的 JSON 对象: %w", err))
+	}
+
+	provider := providerForConfig(apiConfig)
+	chatResult, err := provider.ParseResponse(data)
+	if err != nil {
+		if _, ok := provider.(*mappedProvider); ok {
+			return fail(StageResponseMap, err)
+		}
+		return fail(StageParseResponse, err)
+	}
+
+	result.Success = true
+	result.Content = chatResult.Content
+	result.InputTokens = chatResult.InputTokens
+	result.OutputTokens = chatResult.OutputTokens
+	result.FinishReason = chatResult.FinishReason
+	return result
+}
+
+// truncateUTF8 按字节截断字符串，不截断多字节字符
+func truncateUTF8(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit] + "..."
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+	"ai-chat-backend/tokenizer"
+)
+
+const (
+	// contextSummaryType 摘要消息的 Metadata.type
+	contextSummaryType = "context_summary"
+	// defaultKeepFirst keep_first_last 策略默认保留的开头消息数
+	defaultKeepFirst = 2
+	// summaryMaxTokens 生成摘要时的最大输出长度，同时作为摘要在上下文中的预留空间
+	summaryMaxTokens = 1024
+	// defaultOutputReserve 智能体未设置 MaxTokens 时为回复预留的Token数
+	defaultOutputReserve = 4096
+	// messageTokenOverhead 每条消息的格式开销
+	messageTokenOverhead = 3
+)
+
+const summarizerPrompt = `你负责压缩对话历史。请将给出的对话内容（以及之前的摘要，如果有）合并为一份简洁的摘要，
+保留用户的目标、偏好、已确认的事实、重要结论和未完成的事项，省略寒暄和重复内容。直接输出摘要正文。`
+
+// ContextReport 上下文处理结果，保存在AI回复的 Metadata.context 中
+type ContextReport struct {
+	Strategy          models.ContextStrategy `json:"strategy"`
+	ContextLength     int                    `json:"context_length"`
+	Budget            int                    `json:"budget"`
+	PromptTokens      int                    `json:"prompt_tokens"`
+	DroppedMessageIDs []uint                 `json:"dropped_message_ids"`
+	SummaryMessageID  *uint                  `json:"summary_message_id,omitempty"`
+	SummaryError      string                 `json:"summary_error,omitempty"`
+}
+
+// ContextWindow 实际发送给模型的历史消息
+type ContextWindow struct {
+	Messages []models.Message
+	Report   ContextReport
+	// 生成摘要消耗的Token
+	SummaryInputTokens  int
+	SummaryOutputTokens int
+}
+
+// ContextManager 按模型的上下文长度和智能体的策略截断对话历史
+type ContextManager struct {
+	aiService *AIService
+}
+
+func NewContextManager() *ContextManager {
+	return &ContextManager{aiService: NewAIService()}
+}
+
+// Prepare 根据策略选择要发送的历史消息（messages 按时间升序，最后一条为本次用户消息）
+func (m *ContextManager) Prepare(ctx context.Context, agent models.Agent, messages []models.Message) *ContextWindow {
+	strategy := agent.ContextConfig.Strategy
+	if strategy == "" {
+		strategy = models.ContextSlidingWindow
+	}
+
+	// 分离历史摘要和普通消息
+	var summaries []*models.Message
+	history := make([]models.Message, 0, len(messages))
+	inHistory := make(map[uint]bool, len(messages))
+	for i := range messages {
+		if isContextSummary(messages[i]) {
+			summaries = append(summaries, &messages[i])
+			continue
+		}
+		history = append(history, messages[i])
+		inHistory[messages[i].ID] = true
+	}
+
+	// 只使用覆盖当前分支的最新摘要（其他分支生成的摘要不适用）
+	var summary *models.Message
+	for _, candidate := range summaries {
+		if inHistory[summarizedUntil(*candidate)] {
+			summary = candidate
+		}
+	}
+
+	contextLength := ModelContextLength(agent.ModelName)
+	window := &ContextWindow{Report: ContextReport{
+		Strategy:          strategy,
+		ContextLength:     contextLength,
+		Budget:            m.budget(agent, contextLength),
+		DroppedMessageIDs: []uint{},
+	}}
+
+	switch strategy {
+	case models.ContextNone:
+		window.Messages = history
+	case models.ContextKeepFirstLast:
+		window.Messages = m.keepFirstLast(agent, history, window.Report.Budget, &window.Report)
+	case models.ContextSummarize:
+		m.summarize(ctx, agent, history, summary, window)
+	default:
+		window.Report.Strategy = models.ContextSlidingWindow
+		kept := m.recent(agent, history, window.Report.Budget, agent.ContextConfig.KeepLast)
+		window.Messages = history[len(history)-kept:]
+		window.Report.DroppedMessageIDs = messageIDs(history[:len(history)-kept])
+	}
+
+	window.Report.PromptTokens = m.systemTokens(agent) + m.messagesTokens(agent.ModelName, window.Messages)
+	return window
+}
+
+// budget 历史消息可用的Token数：上下文长度减去回复预留和系统提示词，未知模型返回 0（不限制）
+func (m *ContextManager) budget(agent models.Agent, contextLength int) int {
+	if contextLength == 0 {
+		return 0
+	}
+
+	reserve := agent.ModelParams.MaxTokens
+	if reserve <= 0 {
+		reserve = defaultOutputReserve
+		if reserve > contextLength/4 {
+			reserve = contextLength / 4
+		}
+	}
+
+	budget := contextLength - reserve - m.systemTokens(agent)
+	if budget < 1 {
+		budget = 1
+	}
+	return budget
+}
+
+// recent 从最新的消息往前计算能放入预算的条数（至少保留最后一条），budget 为 0 时不限制Token
+func (m *ContextManager) recent(agent models.Agent, history []models.Message, budget int, keepLast int) int {
+	used, kept := 0, 0
+	for i := len(history) - 1; i >= 0; i-- {
+		if keepLast > 0 && kept >= keepLast {
+			break
+		}
+		tokens := m.messageTokens(agent.ModelName, history[i])
+		if budget > 0 && kept > 0 && used+tokens > budget {
+			break
+		}
+		used += tokens
+		kept++
+	}
+	return kept
+}
+
+// keepFirstLast 保留开头的 KeepFirst 条消息，剩余预算留给最近的消息
+func (m *ContextManager) keepFirstLast(agent models.Agent, history []models.Message, budget int, report *ContextReport) []models.Message {
+	keepFirst := agent.ContextConfig.KeepFirst
+	if keepFirst <= 0 {
+		keepFirst = defaultKeepFirst
+	}
+	if keepFirst >= len(history) {
+		return history
+	}
+
+	first := history[:keepFirst]
+	rest := history[keepFirst:]
+	remaining := budget
+	if budget > 0 {
+		remaining = budget - m.messagesTokens(agent.ModelName, first)
+		if remaining < 1 {
+			// 开头的消息已占满预算时退化为滑动窗口
+			kept := m.recent(agent, history, budget, agent.ContextConfig.KeepLast)
+			report.DroppedMessageIDs = messageIDs(history[:len(history)-kept])
+			return history[len(history)-kept:]
+		}
+	}
+
+	kept := m.recent(agent, rest, remaining, agent.ContextConfig.KeepLast)
+	report.DroppedMessageIDs = messageIDs(rest[:len(rest)-kept])
+
+	result := make([]models.Message, 0, keepFirst+kept)
+	result = append(result, first...)
+	return append(result, rest[len(rest)-kept:]...)
+}
+
+// summarize 超出预算的较早消息与已有摘要合并为新摘要（保存为 system 消息），摘要放在历史消息之前
+func (m *ContextManager) summarize(ctx context.Context, agent models.Agent, history []models.Message, summary *models.Message, window *ContextWindow) {
+	// 已被摘要覆盖的消息不再发送
+	pending := history
+	if summary != nil {
+		summarizedUntil := summarizedUntil(*summary)
+		for len(pending) > 1 && pending[0].ID <= summarizedUntil {
+			window.Report.DroppedMessageIDs = append(window.Report.DroppedMessageIDs, pending[0].ID)
+			pending = pending[1:]
+		}
+	}
+
+	// 为摘要预留空间（最多占预算的四分之一）
+	budget := window.Report.Budget
+	if budget > 0 {
+		reserve := summaryMaxTokens
+		if reserve > budget/4 {
+			reserve = budget / 4
+		}
+		budget -= reserve
+	}
+	kept := m.recent(agent, pending, budget, agent.ContextConfig.KeepLast)
+	overflow := pending[:len(pending)-kept]
+	window.Messages = pending[len(pending)-kept:]
+	window.Report.DroppedMessageIDs = append(window.Report.DroppedMessageIDs, messageIDs(overflow)...)
+
+	if len(overflow) > 0 {
+		newSummary, err := m.createSummary(ctx, agent, summary, overflow, window)
+		if err == nil {
+			summary = newSummary
+		} else {
+			// 摘要失败时退化为滑动窗口（仍然带上已有摘要）
+			log.Printf("生成对话摘要失败: %v", err)
+			window.Report.SummaryError = err.Error()
+		}
+	}
+
+	if summary != nil {
+		window.Report.SummaryMessageID = &summary.ID
+		window.Messages = append([]models.Message{*summary}, window.Messages...)
+	}
+}
+
+// createSummary 调用模型生成新摘要并保存
+func (m *ContextManager) createSummary(ctx context.Context, agent models.Agent, previous *models.Message, overflow []models.Message, window *ContextWindow) (*models.Message, error) {
+	var transcript strings.Builder
+	if previous != nil {
+		transcript.WriteString("之前的摘要：\n")
+		transcript.WriteString(previous.Content)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("需要合并的对话：\n")
+	for _, msg := range overflow {
+		fmt.Fprintf(&transcript, "[%s] %s\n", msg.Role, msg.Content)
+	}
+
+	summaryAgent := agent
+	summaryAgent.SystemPrompt = summarizerPrompt
+	summaryAgent.Tools = nil
+	summaryAgent.ModelParams = models.ModelParams{Temperature: 0.3, MaxTokens: summaryMaxTokens}
+
+	content, inputTokens, outputTokens, err := m.aiService.Chat(ctx, summaryAgent, []models.Message{{
+		Role:    models.RoleUser,
+		Content: transcript.String(),
+	}})
+	window.SummaryInputTokens += inputTokens
+	window.SummaryOutputTokens += outputTokens
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("模型返回了空摘要")
+	}
+
+	summary := &models.Message{
+		ConversationID: overflow[0].ConversationID,
+		Role:           models.RoleSystem,
+		Content:        content,
+		InputTokens:    inputTokens,
+		OutputTokens:   outputTokens,
+		Metadata: models.Metadata{
+			"type":             contextSummaryType,
+			"summarized_until": overflow[len(overflow)-1].ID,
+		},
+	}
+	if err := database.DB.Create(summary).Error; err != nil {
+		return nil, fmt.Errorf("保存摘要失败: %w", err)
+	}
+	return summary, nil
+}
+
+// systemTokens 系统提示词的Token数
+func (m *ContextManager) systemTokens(agent models.Agent) int {
+	if agent.SystemPrompt == "" {
+		return 0
+	}
+	return tokenizer.CountTokens(agent.ModelName, agent.SystemPrompt) + messageTokenOverhead
+}
+
+// messageTokens 单条消息的Token数，用户消息包含注入的文档文本和图片的估算
+func (m *ContextManager) messageTokens(modelName string, msg models.Message) int {
+	if msg.Role != models.RoleUser || len(msg.Attachments) == 0 {
+		return tokenizer.CountTokens(modelName, msg.Content) + messageTokenOverhead
+	}
+
+	files := loadAttachmentFiles(msg.Attachments)
+	return tokenizer.CountTokens(modelName, userMessageText(msg, files)) +
+		imageAttachmentCount(msg.Attachments, files)*imageTokenEstimate + messageTokenOverhead
+}
+
+// messagesTokens 多条消息的Token数
+func (m *ContextManager) messagesTokens(modelName string, messages []models.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += m.messageTokens(modelName, msg)
+	}
+	return total
+}
+
+// isContextSummary 是否为上下文摘要消息
+func isContextSummary(msg models.Message) bool {
+	return msg.Role == models.RoleSystem && msg.Metadata["type"] == contextSummaryType
+}
+
+// summarizedUntil 摘要覆盖到的最后一条消息 ID
+func summarizedUntil(summary models.Message) uint {
+	switch v := summary.Metadata["summarized_until"].(type) {
+	case float64:
+		return uint(v)
+	case uint:
+		return v
+	}
+	return 0
+}
+
+// messageIDs 提取消息 ID
+func messageIDs(messages []models.Message) []uint {
+	ids := make([]uint, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+	"ai-chat-backend/utils"
+)
+
+// EncryptPlaintextCredentials 加密历史遗留的明文凭证，返回处理的记录数
+func EncryptPlaintextCredentials() (int, error) {
+	return reencryptCredentials(func(credentials string) bool {
+		return !utils.IsEncryptedCredential(credentials)
+	})
+}
+
+// RotateCredentialKeys 使用当前主密钥重新加密所有凭证（包括明文凭证），返回处理的记录数
+// 轮换步骤：在 CREDENTIAL_MASTER_KEYS 中加入新密钥并设为 CREDENTIAL_ACTIVE_KEY_ID，
+// 执行轮换后即可从配置中移除旧密钥
+func RotateCredentialKeys() (int, error) {
+	activeKeyID, err := utils.ActiveCredentialKeyID()
+	if err != nil {
+		return 0, err
+	}
+	return reencryptCredentials(func(credentials string) bool {
+		return utils.CredentialKeyID(credentials) != activeKeyID
+	})
+}
+
+// reencryptCredentials 使用当前主密钥重新加密满足条件的凭证（包括已软删除的配置）
+func reencryptCredentials(needsUpdate func(credentials string) bool) (int, error) {
+	var configs []models.APIConfig
+	if err := database.DB.Unscoped().Select("id", "credentials").Find(&configs).Error; err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, config := range configs {
+		if config.Credentials == "" || !needsUpdate(config.Credentials) {
+			continue
+		}
+
+		plaintext, err := utils.DecryptCredential(config.Credentials)
+		if err != nil {
+			return updated, fmt.Errorf("解密配置 %d 的凭证失败: %w", config.ID, err)
+		}
+		encrypted, err := utils.EncryptCredential(plaintext)
+		if err != nil {
+			return updated, fmt.Errorf("加密配置 %d 的凭证失败: %w", config.ID, err)
+		}
+
+		// 只更新凭证字段，不修改 updated_at
+		if err := database.DB.Unscoped().Model(&models.APIConfig{}).Where("id = ?", config.ID).
+			UpdateColumn("credentials", encrypted).Error; err != nil {
+			return updated, fmt.Errorf("保存配置 %d 的凭证失败: %w", config.ID, err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
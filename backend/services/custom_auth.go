@@ -0,0 +1,198 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-chat-backend/models"
+)
+
+// authTemplateVar 匹配 {{变量}} 占位符
+var authTemplateVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.\-]+)\s*\}\}`)
+
+// defaultStringToSign 默认待签名字符串
+const defaultStringToSign = "{{timestamp}}\n{{body_sha256}}"
+
+// applyCustomAuth 按 AuthSpec 设置自定义认证的请求头、查询参数和签名，body 为最终发送的请求体
+func applyCustomAuth(req *http.Request, spec models.AuthSpec, credential string, body []byte) error {
+	vars, err := customAuthVars(req, spec, credential, body)
+	if err != nil {
+		return err
+	}
+
+	for name, tmpl := range spec.Headers {
+		value, err := renderAuthTemplate(tmpl, vars)
+		if err != nil {
+			return fmt.Errorf("请求头 %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	if len(spec.QueryParams) > 0 {
+		query := req.URL.Query()
+		for name, tmpl := range spec.QueryParams {
+			value, err := renderAuthTemplate(tmpl, vars)
+			if err != nil {
+				return fmt.Errorf("查询参数 %s: %w", name, err)
+			}
+			query.Set(name, value)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return nil
+}
+
+// customAuthVars 计算模板变量（签名在其他变量确定后计算）
+func customAuthVars(req *http.Request, spec models.AuthSpec, credential string, body []byte) (map[string]string, error) {
+	bodyHash := sha256.Sum256(body)
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{
+		"credential":  credential,
+		"nonce":       hex.EncodeToString(nonce),
+		"body_sha256": hex.EncodeToString(bodyHash[:]),
+		"method":      req.Method,
+		"path":        req.URL.Path,
+	}
+
+	// 多字段凭证：凭证为 JSON 对象时，字段可通过 {{credential.字段}} 引用
+	fields := parseCredentialFields(credential)
+	for name, value := range fields {
+		vars["credential."+name] = value
+	}
+
+	now := time.Now()
+	vars["timestamp"] = strconv.FormatInt(now.Unix(), 10)
+
+	signing := spec.Signing
+	if signing == nil {
+		return vars, nil
+	}
+
+	switch signing.TimestampFormat {
+	case "", "unix":
+	case "unix_ms":
+		vars["timestamp"] = strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	case "rfc3339":
+		vars["timestamp"] = now.UTC().Format(time.RFC3339)
+	default:
+		return nil, fmt.Errorf("不支持的时间戳格式: %s", signing.TimestampFormat)
+	}
+
+	signature, err := signRequest(signing, credential, fields, vars)
+	if err != nil {
+		return nil, err
+	}
+	vars["signature"] = signature
+
+	return vars, nil
+}
+
+// signRequest 计算 HMAC 签名
+func signRequest(signing *models.SigningSpec, credential string, fields map[string]string, vars map[string]string) (string, error) {
+	secret := credential
+	if fields != nil {
+		secretField := signing.SecretField
+		if secretField == "" {
+			secretField = "secret"
+		}
+		value, ok := fields[secretField]
+		if !ok {
+			return "", fmt.Errorf("凭证中缺少签名密钥字段 %s", secretField)
+		}
+		secret = value
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(signing.Algorithm) {
+	case "", "hmac-sha256":
+		newHash = sha256.New
+	case "hmac-sha1":
+		newHash = sha1.New
+	case "hmac-sha512":
+		newHash = sha512.New
+	default:
+		return "", fmt.Errorf("不支持的签名算法: %s", signing.Algorithm)
+	}
+
+	stringToSign := signing.StringToSign
+	if stringToSign == "" {
+		stringToSign = defaultStringToSign
+	}
+	message, err := renderAuthTemplate(stringToSign, vars)
+	if err != nil {
+		return "", fmt.Errorf("待签名字符串: %w", err)
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(message))
+	sum := mac.Sum(nil)
+
+	switch strings.ToLower(signing.Encoding) {
+	case "", "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	default:
+		return "", fmt.Errorf("不支持的签名编码: %s", signing.Encoding)
+	}
+}
+
+// parseCredentialFields 解析多字段凭证（JSON 对象），不是 JSON 对象时返回 nil
+func parseCredentialFields(credential string) map[string]string {
+	trimmed := strings.TrimSpace(credential)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &raw); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if str, ok := value.(string); ok {
+			fields[name] = str
+		} else {
+			fields[name] = fmt.Sprint(value)
+		}
+	}
+	return fields
+}
+
+// renderAuthTemplate 替换模板中的变量，未知变量返回错误
+func renderAuthTemplate(tmpl string, vars map[string]string) (string, error) {
+	var missing []string
+	result := authTemplateVar.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := authTemplateVar.FindStringSubmatch(match)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", errors.New("未知的模板变量: " + strings.Join(missing, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,548 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 支持提取文本的文档类型
+const (
+	MimeTypePDF      = "application/pdf"
+	MimeTypeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MimeTypeCSV      = "text/csv"
+	MimeTypeMarkdown = "text/markdown"
+	MimeTypeText     = "text/plain"
+)
+
+// maxDecompressedSize 文档解压后的大小上限（DOCX 的 word/document.xml、PDF 所有压缩流之和），防止压缩炸弹
+const maxDecompressedSize = 50 << 20
+
+var errDecompressedTooLarge = fmt.Errorf("文件解压后超过 %d MB", maxDecompressedSize>>20)
+
+// extractDocumentText 按文件类型提取文档中的文本
+func extractDocumentText(mimeType string, data []byte) (string, error) {
+	var text string
+	var err error
+	switch mimeType {
+	case MimeTypePDF:
+		text, err = extractPDFText(data)
+	case MimeTypeDOCX:
+		text, err = extractDOCXText(data)
+	case MimeTypeCSV:
+		text, err = extractCSVText(data)
+	default:
+		text, err = extractPlainText(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = normalizeExtractedText(text)
+	if text == "" {
+		return "", errors.New("文件中没有可提取的文本")
+	}
+	return text, nil
+}
+
+// normalizeExtractedText 去掉行尾空白并合并连续空行
+func normalizeExtractedText(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	normalized := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		normalized = append(normalized, line)
+	}
+	return strings.TrimSpace(strings.Join(normalized, "\n"))
+}
+
+// extractPlainText 读取 UTF-8 文本（Markdown、纯文本等）
+func extractPlainText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", errors.New("文本文件必须使用 UTF-8 编码")
+	}
+	return string(data), nil
+}
+
+// extractCSVText 将 CSV 转换为每行一条记录、字段以 " | " 分隔的文本
+func extractCSVText(data []byte) (string, error) {
+	text, err := extractPlainText(data)
+	if err != nil {
+		return "", err
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var lines []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("CSV 解析失败: %w", err)
+		}
+		lines = append(lines, strings.Join(record, " | "))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// extractDOCXText 读取 word/document.xml 中的段落文本
+func extractDOCXText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("DOCX 文件损坏: %w", err)
+	}
+
+	var document *zip.File
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			document = file
+			break
+		}
+	}
+	if document == nil {
+		return "", errors.New("DOCX 文件缺少 word/document.xml")
+	}
+
+	if document.UncompressedSize64 > maxDecompressedSize {
+		return "", errDecompressedTooLarge
+	}
+	reader, err := document.Open()
+	if err != nil {
+		return "", fmt.Errorf("DOCX 文件损坏: %w", err)
+	}
+	defer reader.Close()
+
+	// 压缩包中记录的大小可能不实，按实际解压的字节数再限制一次
+	limited := &io.LimitedReader{R: reader, N: maxDecompressedSize + 1}
+	var text strings.Builder
+	decoder := xml.NewDecoder(limited)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if limited.N <= 0 {
+			return "", errDecompressedTooLarge
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("DOCX 文件损坏: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br", "cr":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			case "tc":
+				text.WriteString("\t")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// extractPDFText 从 PDF 的页面内容流中提取文本
+// 只做轻量解析：支持未压缩和 FlateDecode 压缩的内容流，字符串按 PDFDocEncoding/UTF-16 解码，
+// 扫描件、加密文件以及使用自定义编码（CID）字体的 PDF 无法提取
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF")) {
+		return "", errors.New("不是有效的 PDF 文件")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errors.New("不支持加密的 PDF 文件")
+	}
+
+	streams, err := pdfContentStreams(data)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, content := range streams {
+		if chunk := pdfContentText(content); chunk != "" {
+			text.WriteString(chunk)
+			text.WriteString("\n")
+		}
+	}
+
+	extracted := text.String()
+	if !isReadableText(extracted) {
+		return "", errors.New("无法从 PDF 中提取文本（可能是扫描件或使用了不支持的字体编码）")
+	}
+	return extracted, nil
+}
+
+// pdfContentStreams 返回 PDF 中可能包含页面内容的流（已解压），解压后的总大小超过 maxDecompressedSize 时返回错误
+func pdfContentStreams(data []byte) ([][]byte, error) {
+	var streams [][]byte
+	remaining := int64(maxDecompressedSize)
+	pos := 0
+	for {
+		idx := bytes.Index(data[pos:], []byte("stream"))
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		pos = start + len("stream")
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+
+		// 流字典位于对象头（N 0 obj）和 stream 关键字之间
+		dictStart := bytes.LastIndex(data[:start], []byte(" obj"))
+		if dictStart < 0 {
+			dictStart = 0
+		}
+		dict := data[dictStart:start]
+
+		bodyStart := pos
+		if bodyStart < len(data) && data[bodyStart] == '\r' {
+			bodyStart++
+		}
+		if bodyStart < len(data) && data[bodyStart] == '\n' {
+			bodyStart++
+		}
+		end := bytes.Index(data[bodyStart:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := data[bodyStart : bodyStart+end]
+		pos = bodyStart + end + len("endstream")
+
+		// 跳过图片、字体、交叉引用等不含页面文本的流
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/Length1")) ||
+			bytes.Contains(dict, []byte("/XRef")) || bytes.Contains(dict, []byte("/ObjStm")) ||
+			bytes.Contains(dict, []byte("/Metadata")) {
+			continue
+		}
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			reader, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			// 流可能缺少校验和或带有尾部填充，保留已解压的部分
+			decoded, _ := io.ReadAll(io.LimitReader(reader, remaining+1))
+			reader.Close()
+			if int64(len(decoded)) > remaining {
+				return nil, errDecompressedTooLarge
+			}
+			remaining -= int64(len(decoded))
+			body = decoded
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue
+		}
+		streams = append(streams, body)
+	}
+	return streams, nil
+}
+
+// pdfContentText 解释内容流中的文本操作符（Tj、TJ、'、"）和换行操作符
+func pdfContentText(content []byte) string {
+	var text strings.Builder
+	var pending strings.Builder // 当前操作符的字符串操作数
+	var numbers []float64       // 当前操作符的数值操作数
+	arrayDepth := 0
+
+	newline := func() {
+		if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteString("\n")
+		}
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFWhitespace(c):
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			str, n := readPDFLiteralString(content[i:])
+			pending.WriteString(decodePDFString(str))
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return text.String()
+			}
+			pending.WriteString(decodePDFString(decodePDFHex(content[i+1 : i+end])))
+			i += end + 1
+		case c == '[':
+			arrayDepth++
+			i++
+		case c == ']':
+			if arrayDepth > 0 {
+				arrayDepth--
+			}
+			i++
+		case c == '/':
+			i++
+			for i < len(content) && !isPDFWhitespace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+		default:
+			start := i
+			for i < len(content) && !isPDFWhitespace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			if i == start {
+				i++
+				continue
+			}
+			word := string(content[start:i])
+
+			if number, err := strconv.ParseFloat(word, 64); err == nil {
+				// TJ 数组中较大的负偏移通常表示单词间距
+				if arrayDepth > 0 && number < -200 {
+					pending.WriteString(" ")
+				}
+				numbers = append(numbers, number)
+				continue
+			}
+
+			switch word {
+			case "Tj", "TJ":
+				text.WriteString(pending.String())
+			case "'", "\"":
+				newline()
+				text.WriteString(pending.String())
+			case "T*", "ET":
+				newline()
+			case "Td", "TD":
+				if len(numbers) >= 2 && numbers[len(numbers)-1] != 0 {
+					newline()
+				} else if text.Len() > 0 {
+					text.WriteString(" ")
+				}
+			}
+			pending.Reset()
+			numbers = numbers[:0]
+		}
+	}
+	return text.String()
+}
+
+// readPDFLiteralString 读取括号字符串，返回解码转义后的字节和消耗的长度
+func readPDFLiteralString(content []byte) ([]byte, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out, i + 1
+			}
+			out = append(out, c)
+		case '\\':
+			i++
+			if i >= len(content) {
+				return out, i
+			}
+			switch e := content[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if i+1 < len(content) && content[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					j := 0
+					for ; j < 3 && i+j < len(content) && content[i+j] >= '0' && content[i+j] <= '7'; j++ {
+						value = value*8 + int(content[i+j]-'0')
+					}
+					i += j - 1
+					out = append(out, byte(value))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, len(content)
+}
+
+// decodePDFHex 解码十六进制字符串，奇数位时末尾补 0
+func decodePDFHex(hex []byte) []byte {
+	var digits []byte
+	for _, c := range hex {
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i+1 < len(digits); i += 2 {
+		value, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return nil
+		}
+		out = append(out, byte(value))
+	}
+	return out
+}
+
+// decodePDFString 解码 PDF 字符串：带 BOM 的按 UTF-16BE，其余按 Latin-1（近似 PDFDocEncoding）
+func decodePDFString(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, 0, len(raw))
+	for _, b := range raw {
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
+
+// isReadableText 提取结果是否为可读文本（CID 字体等无法解码时会得到大量控制字符）
+func isReadableText(text string) bool {
+	total, readable := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsPrint(r) {
+			readable++
+		}
+	}
+	return total > 0 && readable*10 >= total*9
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// chunkText 将文本切分为不超过 size 个字符的片段，尽量在段落和句子边界切分，相邻片段重叠 overlap 个字符
+func chunkText(text string, size int, overlap int) []string {
+	if size <= 0 {
+		return nil
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	runes := []rune(strings.TrimSpace(text))
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			chunks = append(chunks, strings.TrimSpace(string(runes[start:])))
+			break
+		}
+
+		// 在片段后半部分寻找最靠后的段落或句子边界
+		cut := end
+		for _, boundary := range []string{"\n\n", "\n", "。", ". ", "！", "？", "; ", "；", " "} {
+			if idx := lastRuneIndex(runes[start+size/2:end], []rune(boundary)); idx >= 0 {
+				cut = start + size/2 + idx + len([]rune(boundary))
+				break
+			}
+		}
+
+		if chunk := strings.TrimSpace(string(runes[start:cut])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		next := cut - overlap
+		if next <= start {
+			next = cut
+		}
+		start = next
+	}
+	return chunks
+}
+
+// lastRuneIndex 返回 sep 在 runes 中最后一次出现的位置
+func lastRuneIndex(runes []rune, sep []rune) int {
+	for i := len(runes) - len(sep); i >= 0; i-- {
+		match := true
+		for j := range sep {
+			if runes[i+j] != sep[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
@@ -11,12 +11,24 @@ import (
 )
 
 type EinoService struct {
-	aiService *AIService
+	aiService        *AIService
+	templateService  *TemplateService
+	workflowExecutor *WorkflowExecutor
+	retriever        *Retriever
+	react            *ReActRunner
+	script           *ScriptRunner
 }
 
 func NewEinoService() *EinoService {
+	aiService := NewAIService()
+	retriever := NewRetriever(aiService)
 	return &EinoService{
-		aiService: NewAIService(),
+		aiService:        aiService,
+		templateService:  NewTemplateService(),
+		workflowExecutor: NewWorkflowExecutor(aiService, retriever),
+		retriever:        retriever,
+		react:            NewReActRunner(aiService),
+		script:           NewScriptRunner(aiService),
 	}
 }
 
@@ -24,8 +36,12 @@ func NewEinoService() *EinoService {
 func (s *EinoService) ExecuteAgent(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
 	switch agent.WorkflowType {
 	case models.WorkflowSimple, "":
-		// 使用原有的简单执行方式
-		return s.aiService.Chat(agent, messages)
+		// 使用原有的简单执行方式（配置了知识库时先检索参考资料）
+		agent, err := s.retriever.AugmentAgent(ctx, agent, messages)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		return s.aiService.Chat(ctx, agent, messages)
 		
 	case models.WorkflowTemplate:
 		// 基于模板执行
@@ -44,39 +60,184 @@ func (s *EinoService) ExecuteAgent(ctx context.Context, agent models.Agent, mess
 	}
 }
 
+// Attempts 返回执行过程中的所有模型请求尝试（用于排查重试和故障转移）
+func (s *EinoService) Attempts() []models.RequestAttempt {
+	return s.aiService.Attempts()
+}
+
+// Citations 返回执行过程中注入的知识库引用
+func (s *EinoService) Citations() []Citation {
+	return s.retriever.Citations()
+}
+
+// ReActTrace 返回 ReAct 智能体的执行轨迹，其他类型的智能体为 nil
+func (s *EinoService) ReActTrace() *ReActTrace {
+	return s.react.Trace()
+}
+
+// ScriptTrace 返回自定义代码智能体的脚本执行轨迹，其他类型的智能体为 nil
+func (s *EinoService) ScriptTrace() *ScriptTrace {
+	return s.script.Trace()
+}
+
+// ExecuteAgentStream 流式执行 Agent，返回的事件通道在执行结束后关闭
+// 最后一个事件为 done（携带完整内容和Token使用量）或 error
+func (s *EinoService) ExecuteAgentStream(ctx context.Context, agent models.Agent, messages []models.Message) (<-chan StreamEvent, error) {
+	var runner streamRunner
+
+	switch agent.WorkflowType {
+	case models.WorkflowSimple, "":
+		runner = s.streamChat(ctx, agent, messages)
+
+	case models.WorkflowTemplate:
+		agent = s.prepareTemplateAgent(agent)
+		if s.templateCategory(agent) == "react" {
+			runner = func(emit func(StreamEvent)) (string, int, int, error) {
+				return s.runReAct(ctx, agent, messages, emit)
+			}
+			break
+		}
+		runner = s.streamChat(ctx, agent, messages)
+
+	case models.WorkflowVisual:
+		if err := s.ValidateWorkflowDefinition(agent.WorkflowDefinition); err != nil {
+			return nil, fmt.Errorf("工作流验证失败: %w", err)
+		}
+		runner = func(emit func(StreamEvent)) (string, int, int, error) {
+			return s.workflowExecutor.ExecuteStream(ctx, agent, agent.WorkflowDefinition, messages, emit)
+		}
+
+	case models.WorkflowCode:
+		// 暂不支持增量输出，执行完成后一次性发送内容
+		runner = func(emit func(StreamEvent)) (string, int, int, error) {
+			content, inputTokens, outputTokens, err := s.executeCustomCode(ctx, agent, messages)
+			if err == nil {
+				emit(StreamEvent{Type: StreamEventContent, Content: content})
+			}
+			return content, inputTokens, outputTokens, err
+		}
+
+	default:
+		return nil, fmt.Errorf("不支持的工作流类型: %s", agent.WorkflowType)
+	}
+
+	events := make(chan StreamEvent, 16)
+	go func() {
+		defer close(events)
+
+		emit := func(event StreamEvent) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+
+		content, inputTokens, outputTokens, err := runner(emit)
+		if err != nil {
+			emit(StreamEvent{Type: StreamEventError, Error: err.Error()})
+			return
+		}
+		emit(StreamEvent{
+			Type:         StreamEventDone,
+			Content:      content,
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+		})
+	}()
+
+	return events, nil
+}
+
+// streamChat 流式对话；配置了工具的 Agent 需要完整的工具调用循环，执行完成后一次性发送内容
+// 配置了知识库的 Agent 先检索参考资料
+func (s *EinoService) streamChat(ctx context.Context, agent models.Agent, messages []models.Message) streamRunner {
+	return func(emit func(StreamEvent)) (string, int, int, error) {
+		agent, err := s.retriever.AugmentAgent(ctx, agent, messages)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		if len(s.aiService.tools.Definitions(agent.Tools)) > 0 {
+			content, inputTokens, outputTokens, err := s.aiService.Chat(ctx, agent, messages)
+			if err == nil {
+				emit(StreamEvent{Type: StreamEventContent, Content: content})
+			}
+			return content, inputTokens, outputTokens, err
+		}
+
+		return s.aiService.StreamChat(ctx, agent, messages, func(content string) {
+			emit(StreamEvent{Type: StreamEventContent, Content: content})
+		})
+	}
+}
+
 // executeTemplateAgent 执行模板 Agent
 func (s *EinoService) executeTemplateAgent(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
-	// 模板 Agent 目前使用简单的执行方式
-	// 未来会根据模板的 workflow_definition 构建复杂的 Eino 工作流
-	
-	// 目前直接使用 AIService 执行
-	return s.aiService.Chat(agent, messages)
+	// 推理决策类模板执行 ReAct 循环，其他模板使用简单的执行方式（rag 模板检索配置的知识库）
+	agent = s.prepareTemplateAgent(agent)
+	if s.templateCategory(agent) == "react" {
+		return s.runReAct(ctx, agent, messages, nil)
+	}
+
+	agent, err := s.retriever.AugmentAgent(ctx, agent, messages)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return s.aiService.Chat(ctx, agent, messages)
+}
+
+// runReAct 配置了知识库时先检索参考资料，再执行 ReAct 循环
+func (s *EinoService) runReAct(ctx context.Context, agent models.Agent, messages []models.Message, emit func(StreamEvent)) (string, int, int, error) {
+	agent, err := s.retriever.AugmentAgent(ctx, agent, messages)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return s.react.Run(ctx, agent, messages, emit)
+}
+
+// templateCategory 返回模板 Agent 所用模板的分类，模板不存在时为空
+func (s *EinoService) templateCategory(agent models.Agent) string {
+	template, err := s.templateService.GetTemplateByID(agent.TemplateID)
+	if err != nil {
+		return ""
+	}
+	return template.Category
+}
+
+// prepareTemplateAgent 根据模板补全 Agent 配置（工具调用和推理决策类模板确保带上模板要求的工具）
+func (s *EinoService) prepareTemplateAgent(agent models.Agent) models.Agent {
+	if template, err := s.templateService.GetTemplateByID(agent.TemplateID); err == nil &&
+		(template.Category == "tool_calling" || template.Category == "react") {
+		agent.Tools = mergeToolNames(agent.Tools, template.RequiredTools)
+	}
+	return agent
+}
+
+// mergeToolNames 合并工具列表并去重
+func mergeToolNames(tools models.Tools, required []string) models.Tools {
+	seen := make(map[string]bool, len(tools))
+	merged := make(models.Tools, 0, len(tools)+len(required))
+	for _, name := range append(append([]string{}, tools...), required...) {
+		if !seen[name] {
+			seen[name] = true
+			merged = append(merged, name)
+		}
+	}
+	return merged
 }
 
 // executeVisualWorkflow 执行可视化工作流
 func (s *EinoService) executeVisualWorkflow(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
-	// 第四步会实现
-	return "", 0, 0, errors.New("可视化工作流功能即将在第四步推出")
-}
+	if err := s.ValidateWorkflowDefinition(agent.WorkflowDefinition); err != nil {
+		return "", 0, 0, fmt.Errorf("工作流验证失败: %w", err)
+	}
 
-// executeCustomCode 执行自定义代码
-func (s *EinoService) executeCustomCode(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
-	// 第五步会实现
-	return "", 0, 0, errors.New("自定义代码功能即将在第五步推出")
+	return s.workflowExecutor.Execute(ctx, agent, agent.WorkflowDefinition, messages)
 }
 
-// BuildSimpleReActAgent 构建简单的 ReAct Agent（示例）
-func (s *EinoService) BuildSimpleReActAgent(ctx context.Context, agent models.Agent) error {
-	// 这是一个示例，展示如何使用 Eino 构建 Agent
-	// 实际使用时会根据 agent 配置动态构建
-	
-	// 注意：这只是示例代码结构，实际需要根据你的 API 配置来创建 ChatModel
-	// 真正的实现会在后续步骤中完善
-	
-	fmt.Printf("准备构建 ReAct Agent: %s (ID: %d)\n", agent.Name, agent.ID)
-	fmt.Printf("工作流类型: %s\n", agent.WorkflowType)
-	
-	return nil
+// executeCustomCode 在沙箱中执行智能体的自定义代码
+func (s *EinoService) executeCustomCode(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
+	return s.script.Run(ctx, agent, messages)
 }
 
 // ConvertMessagesToEinoFormat 将消息转换为 Eino 格式
@@ -145,24 +306,71 @@ func (s *EinoService) ValidateWorkflowDefinition(definition models.EinoWorkflowD
 		}
 	}
 	
-	// 检查是否有孤立节点（没有连接的节点）
-	if len(definition.Edges) > 0 {
-		connectedNodes := make(map[string]bool)
-		for _, edge := range definition.Edges {
-			connectedNodes[edge.Source] = true
-			connectedNodes[edge.Target] = true
-		}
-		
-		for _, node := range definition.Nodes {
-			if !connectedNodes[node.ID] && node.Type != "start" && node.Type != "end" {
-				fmt.Printf("警告: 节点 %s 没有连接到工作流\n", node.ID)
+	// 工作流从唯一的 start 节点开始、在唯一的 end 节点结束
+	var startID, endID string
+	for _, node := range definition.Nodes {
+		switch node.Type {
+		case "start":
+			if startID != "" {
+				return errors.New("工作流只能有一个开始节点")
 			}
+			startID = node.ID
+		case "end":
+			if endID != "" {
+				return errors.New("工作流只能有一个结束节点")
+			}
+			endID = node.ID
 		}
 	}
-	
+	if startID == "" || endID == "" {
+		return errors.New("工作流需要一个开始节点（start）和一个结束节点（end）")
+	}
+
+	successors := make(map[string][]string)
+	predecessors := make(map[string][]string)
+	for _, edge := range definition.Edges {
+		if edge.Target == startID {
+			return fmt.Errorf("开始节点 %s 不能有输入连接", startID)
+		}
+		if edge.Source == endID {
+			return fmt.Errorf("结束节点 %s 不能有输出连接", endID)
+		}
+		successors[edge.Source] = append(successors[edge.Source], edge.Target)
+		predecessors[edge.Target] = append(predecessors[edge.Target], edge.Source)
+	}
+
+	// 每个节点都必须能从 start 到达、并能到达 end，否则不会执行或其输出不会进入回复
+	fromStart := reachableNodes(startID, successors)
+	toEnd := reachableNodes(endID, predecessors)
+	for _, node := range definition.Nodes {
+		if !fromStart[node.ID] {
+			return fmt.Errorf("节点 %s 无法从开始节点到达", node.ID)
+		}
+		if !toEnd[node.ID] {
+			return fmt.Errorf("节点 %s 无法到达结束节点", node.ID)
+		}
+	}
+
 	return nil
 }
 
+// reachableNodes 沿 next 中的连接从 from 出发能到达的节点（包括 from）
+func reachableNodes(from string, next map[string][]string) map[string]bool {
+	reached := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, nextID := range next[id] {
+			if !reached[nextID] {
+				reached[nextID] = true
+				queue = append(queue, nextID)
+			}
+		}
+	}
+	return reached
+}
+
 // GetWorkflowSummary 获取工作流摘要信息
 func (s *EinoService) GetWorkflowSummary(definition models.EinoWorkflowDefinition) map[string]interface{} {
 	nodeTypeCount := make(map[string]int)
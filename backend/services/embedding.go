@@ -0,0 +1,205 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+	"ai-chat-backend/tokenizer"
+)
+
+// embeddingBatchSize 每次 embeddings 请求最多包含的文本数（Gemini batchEmbedContents 上限为 100）
+const embeddingBatchSize = 64
+
+// embeddingProvider 支持 embeddings 接口的服务商适配器（Anthropic 没有 embeddings 接口）
+type embeddingProvider interface {
+	Provider
+	// EmbeddingURL 返回计算 count 条文本向量的请求地址
+	EmbeddingURL(apiConfig *models.APIConfig, modelName string, count int) string
+	// EmbeddingBody 构建请求体
+	EmbeddingBody(modelName string, inputs []string) map[string]interface{}
+	// ParseEmbeddings 解析向量（与输入顺序一致）和输入Token数，服务商未返回使用量时为 0
+	ParseEmbeddings(body []byte) ([]models.Vector, int, error)
+}
+
+type usageAgentKey struct{}
+
+// withUsageAgent 将 ctx 中 embeddings 请求的使用量记入智能体（例如 rag 智能体检索时计算查询向量）
+func withUsageAgent(ctx context.Context, agentID uint) context.Context {
+	return context.WithValue(ctx, usageAgentKey{}, agentID)
+}
+
+func usageAgent(ctx context.Context) *uint {
+	if agentID, ok := ctx.Value(usageAgentKey{}).(uint); ok && agentID != 0 {
+		return &agentID
+	}
+	return nil
+}
+
+// embeddingsURL 由配置的 chat/completions 地址推导 embeddings 地址
+func embeddingsURL(endpointURL string) string {
+	url := strings.TrimRight(endpointURL, "/")
+	if idx := strings.LastIndex(url, "/chat/completions"); idx >= 0 {
+		return url[:idx] + "/embeddings"
+	}
+	if strings.HasSuffix(url, "/embeddings") {
+		return url
+	}
+	return url + "/embeddings"
+}
+
+// Embed 调用 API 配置的 embeddings 接口计算文本向量，返回的向量与 inputs 顺序一致
+// 支持 OpenAI 兼容的 /embeddings、Gemini embedContent / batchEmbedContents 和 Ollama /api/embed；
+// 输入按 embeddingBatchSize 分批请求，命中磁盘缓存的文本不再请求，使用量记入 API 配置所属用户的 token_usages
+func (s *AIService) Embed(ctx context.Context, apiConfig *models.APIConfig, model string, inputs []string) ([]models.Vector, error) {
+	if apiConfig == nil {
+		return nil, errors.New("未配置用于计算向量的 API 配置")
+	}
+	provider, ok := providerFor(apiConfig.APIType).(embeddingProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s 没有 embeddings 接口，请使用 OpenAI 兼容、Gemini 或 Ollama 的 API 配置", apiConfig.APIType)
+	}
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	cache := newEmbeddingCache()
+	vectors := make([]models.Vector, len(inputs))
+	keys := make([]string, len(inputs))
+	var pending []int
+	for i, input := range inputs {
+		keys[i] = cache.key(apiConfig, model, input)
+		if vector, ok := cache.get(keys[i]); ok {
+			vectors[i] = vector
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// 部分批次失败时，已成功的批次仍然计入使用量
+	tokens := 0
+	defer func() {
+		s.recordEmbeddingUsage(ctx, apiConfig, model, tokens)
+	}()
+
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := make([]string, 0, end-start)
+		for _, i := range pending[start:end] {
+			batch = append(batch, inputs[i])
+		}
+
+		batchVectors, batchTokens, err := s.embedBatch(ctx, provider, apiConfig, model, batch)
+		if err != nil {
+			return nil, err
+		}
+		tokens += batchTokens
+		for j, i := range pending[start:end] {
+			vectors[i] = batchVectors[j]
+			cache.put(keys[i], batchVectors[j])
+		}
+	}
+	return vectors, nil
+}
+
+// embedBatch 发送一次 embeddings 请求，429、5xx 和网络错误按指数退避重试
+func (s *AIService) embedBatch(ctx context.Context, provider embeddingProvider, apiConfig *models.APIConfig, model string, inputs []string) ([]models.Vector, int, error) {
+	apiKey, err := configAPIKey(apiConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	jsonData, err := json.Marshal(provider.EmbeddingBody(model, inputs))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", provider.EmbeddingURL(apiConfig, model, len(inputs)), bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		provider.SetHeaders(req, apiConfig, apiKey)
+		if apiConfig.AuthType == models.AuthCustom {
+			if err := applyCustomAuth(req, apiConfig.AuthSpec, apiKey, jsonData); err != nil {
+				return nil, 0, &StageError{Stage: StageAuth, Err: fmt.Errorf("自定义认证配置错误: %w", err)}
+			}
+		}
+
+		var retryErr error
+		resp, err := s.client.Do(req)
+		if err != nil {
+			retryErr = fmt.Errorf("embeddings 请求失败: %w", err)
+		} else {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, 0, err
+			}
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+			retryErr = fmt.Errorf("embeddings 请求失败 (状态码: %d): %s", resp.StatusCode, string(body))
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return nil, 0, retryErr
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if attempt > s.retry.maxRetries {
+			return nil, 0, retryErr
+		}
+		select {
+		case <-time.After(s.retry.backoff(attempt)):
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	vectors, tokens, err := provider.ParseEmbeddings(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(vectors) != len(inputs) {
+		return nil, 0, fmt.Errorf("embeddings 响应数量不匹配: 请求 %d 条，返回 %d 条", len(inputs), len(vectors))
+	}
+	for _, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, 0, errors.New("无效的 embeddings 响应")
+		}
+	}
+
+	// 服务商未返回使用量时，使用本地估算
+	if tokens == 0 {
+		for _, input := range inputs {
+			tokens += tokenizer.CountTokens(model, input)
+		}
+	}
+	return vectors, tokens, nil
+}
+
+// recordEmbeddingUsage 将 embeddings 的输入Token和成本计入 API 配置所属用户当天的使用统计
+func (s *AIService) recordEmbeddingUsage(ctx context.Context, apiConfig *models.APIConfig, model string, tokens int) {
+	if tokens == 0 || database.DB == nil {
+		return
+	}
+	cost := NewPricingService().CalculateCost(model, tokens, 0, 0)
+	if err := addTokenUsage(apiConfig.UserID, usageAgent(ctx), nil, tokens, 0, cost.TotalCost); err != nil {
+		log.Printf("记录 embeddings 使用量失败: %v", err)
+	}
+}
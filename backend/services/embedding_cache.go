@@ -0,0 +1,68 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/models"
+)
+
+// embeddingCache 以（服务地址，模型，文本）的哈希为键缓存向量，避免重复索引相同内容时再次请求
+// 缓存只用于减少请求，可以随时清空目录
+type embeddingCache struct {
+	storage *LocalFileStorage
+}
+
+// newEmbeddingCache 未配置 EMBEDDING_CACHE_DIR 时返回 nil（不缓存）
+func newEmbeddingCache() *embeddingCache {
+	if config.AppConfig == nil || config.AppConfig.EmbeddingCacheDir == "" {
+		return nil
+	}
+	return &embeddingCache{storage: NewLocalFileStorage(config.AppConfig.EmbeddingCacheDir)}
+}
+
+// key 缓存文件路径，例如 3f/9a...c1.vec
+func (c *embeddingCache) key(apiConfig *models.APIConfig, model string, text string) string {
+	if c == nil {
+		return ""
+	}
+	hash := sha256.New()
+	for _, part := range []string{apiConfig.APIType, apiConfig.EndpointURL, model, text} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	return sum[:2] + "/" + sum[2:] + ".vec"
+}
+
+func (c *embeddingCache) get(key string) (models.Vector, bool) {
+	if c == nil {
+		return nil, false
+	}
+	reader, err := c.storage.Open(key)
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+
+	var vector models.Vector
+	if err := vector.Scan(data); err != nil {
+		return nil, false
+	}
+	return vector, true
+}
+
+// put 写入失败时忽略（只影响缓存命中）
+func (c *embeddingCache) put(key string, vector models.Vector) {
+	if c == nil {
+		return
+	}
+	data, _ := vector.Value()
+	c.storage.Put(key, data.([]byte))
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// FileRejectedError 上传的文件不符合要求（类型不支持、超出大小或配额、无法提取文本）
+type FileRejectedError struct {
+	Reason string
+}
+
+func (e *FileRejectedError) Error() string {
+	return e.Reason
+}
+
+// imageMimeTypes 允许上传的图片类型
+var imageMimeTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// FileService 用户文件：上传、配额、类型识别和文本提取
+type FileService struct {
+	backend string
+}
+
+func NewFileService() *FileService {
+	return &FileService{backend: config.AppConfig.StorageBackend}
+}
+
+// Upload 保存用户上传的文件，文档类文件同时提取文本
+func (s *FileService) Upload(userID uint, name string, data []byte) (*models.File, error) {
+	if len(data) == 0 {
+		return nil, &FileRejectedError{Reason: "文件内容为空"}
+	}
+	if maxSize := config.AppConfig.MaxUploadSize; maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, &FileRejectedError{Reason: fmt.Sprintf("文件超过 %d MB", maxSize>>20)}
+	}
+
+	used, err := s.UsedBytes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if quota := config.AppConfig.UserStorageQuota; quota > 0 && used+int64(len(data)) > quota {
+		return nil, &FileRejectedError{Reason: fmt.Sprintf("存储空间不足（已使用 %.1f MB，上限 %d MB），请删除不需要的文件", float64(used)/(1<<20), quota>>20)}
+	}
+
+	mimeType, kind, err := detectFileType(name, data)
+	if err != nil {
+		return nil, &FileRejectedError{Reason: err.Error()}
+	}
+
+	var extractedText string
+	if kind == models.FileKindDocument {
+		if extractedText, err = extractDocumentText(mimeType, data); err != nil {
+			return nil, &FileRejectedError{Reason: err.Error()}
+		}
+	}
+
+	storage, err := fileStorage(s.backend)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newStorageKey(userID, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Put(key, data); err != nil {
+		return nil, fmt.Errorf("保存文件失败: %w", err)
+	}
+
+	file := &models.File{
+		UserID:         userID,
+		Name:           filepath.Base(name),
+		MimeType:       mimeType,
+		Kind:           kind,
+		Size:           int64(len(data)),
+		StorageBackend: s.backend,
+		StorageKey:     key,
+		ExtractedText:  extractedText,
+		TextLength:     utf8.RuneCountInString(extractedText),
+	}
+	if err := database.DB.Create(file).Error; err != nil {
+		storage.Delete(key)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Get 获取用户的文件
+func (s *FileService) Get(userID uint, fileID uint) (*models.File, error) {
+	var file models.File
+	if err := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// List 列出用户的文件
+func (s *FileService) List(userID uint) ([]models.File, error) {
+	var files []models.File
+	err := database.DB.Omit("extracted_text").Where("user_id = ?", userID).Order("created_at DESC").Find(&files).Error
+	return files, err
+}
+
+// UsedBytes 用户已使用的存储空间
+func (s *FileService) UsedBytes(userID uint) (int64, error) {
+	var used int64
+	err := database.DB.Model(&models.File{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").Scan(&used).Error
+	return used, err
+}
+
+// Content 读取文件内容
+func (s *FileService) Content(file *models.File) ([]byte, error) {
+	return readStoredFile(file.StorageBackend, file.StorageKey)
+}
+
+// Delete 删除用户的文件（已发送的消息中的附件随之失效）
+func (s *FileService) Delete(userID uint, fileID uint) error {
+	file, err := s.Get(userID, fileID)
+	if err != nil {
+		return err
+	}
+	storage, err := fileStorage(file.StorageBackend)
+	if err != nil {
+		return err
+	}
+	if err := storage.Delete(file.StorageKey); err != nil {
+		return err
+	}
+	return database.DB.Delete(file).Error
+}
+
+// CheckAttachments 检查消息附件引用的文件属于该用户且类型匹配，并补充文件名等展示信息
+func (s *FileService) CheckAttachments(userID uint, attachments models.Attachments) error {
+	for i, attachment := range attachments {
+		fileID, ok := attachmentFileID(attachment)
+		if !ok {
+			continue
+		}
+
+		file, err := s.Get(userID, fileID)
+		if err != nil {
+			return fmt.Errorf("附件 %d: 文件不存在", i+1)
+		}
+		attachmentType, _ := attachment["type"].(string)
+		if attachmentType == AttachmentTypeImage && file.Kind != models.FileKindImage {
+			return fmt.Errorf("附件 %d: %s 不是图片", i+1, file.Name)
+		}
+		if attachmentType == AttachmentTypeFile && file.Kind != models.FileKindDocument {
+			return fmt.Errorf("附件 %d: %s 不是文档，图片请使用 image 类型的附件", i+1, file.Name)
+		}
+
+		attachment["name"] = file.Name
+		attachment["mime_type"] = file.MimeType
+		attachment["size"] = file.Size
+	}
+	return nil
+}
+
+// detectFileType 根据文件内容识别类型（扩展名只用于区分 Markdown、CSV 等纯文本格式）
+func detectFileType(name string, data []byte) (string, models.FileKind, error) {
+	detected := mimetype.Detect(data)
+	ext := strings.ToLower(filepath.Ext(name))
+
+	for _, imageType := range imageMimeTypes {
+		if detected.Is(imageType) {
+			return imageType, models.FileKindImage, nil
+		}
+	}
+
+	switch {
+	case detected.Is(MimeTypePDF):
+		return MimeTypePDF, models.FileKindDocument, nil
+	case detected.Is(MimeTypeDOCX):
+		return MimeTypeDOCX, models.FileKindDocument, nil
+	case detected.Is(MimeTypeCSV):
+		return MimeTypeCSV, models.FileKindDocument, nil
+	}
+
+	for mime := detected; mime != nil; mime = mime.Parent() {
+		if !mime.Is(MimeTypeText) {
+			continue
+		}
+		switch ext {
+		case ".md", ".markdown":
+			return MimeTypeMarkdown, models.FileKindDocument, nil
+		case ".csv":
+			return MimeTypeCSV, models.FileKindDocument, nil
+		}
+		return MimeTypeText, models.FileKindDocument, nil
+	}
+
+	return "", "", fmt.Errorf("不支持的文件类型 %s，支持 PDF、DOCX、Markdown、CSV、纯文本和 PNG/JPEG/GIF/WebP 图片", detected.String())
+}
+
+// newStorageKey 生成文件的存储路径：<用户 ID>/<随机名><扩展名>
+func newStorageKey(userID uint, name string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(ext) > 10 || strings.ContainsAny(ext, `/\`) {
+		ext = ""
+	}
+	return fmt.Sprintf("%d/%s%s", userID, hex.EncodeToString(buf), ext), nil
+}
+
+// loadAttachmentFiles 加载消息附件引用的文件
+func loadAttachmentFiles(attachments models.Attachments) map[uint]*models.File {
+	var ids []uint
+	for _, attachment := range attachments {
+		if fileID, ok := attachmentFileID(attachment); ok {
+			ids = append(ids, fileID)
+		}
+	}
+	files := make(map[uint]*models.File, len(ids))
+	if len(ids) == 0 || database.DB == nil {
+		return files
+	}
+
+	var found []models.File
+	if err := database.DB.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return files
+	}
+	for i := range found {
+		files[found[i].ID] = &found[i]
+	}
+	return files
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"ai-chat-backend/config"
+)
+
+// StorageBackendLocal 本地磁盘存储
+const StorageBackendLocal = "local"
+
+// FileStorage 文件存储后端，key 为相对路径形式（例如 12/3f9a....pdf）
+// 新增后端（例如 S3 兼容的对象存储）时实现该接口并在 fileStorageFactories 中注册
+type FileStorage interface {
+	Put(key string, data []byte) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// fileStorageFactories 存储后端名称 -> 构造函数
+var fileStorageFactories = map[string]func() (FileStorage, error){
+	StorageBackendLocal: func() (FileStorage, error) {
+		return NewLocalFileStorage(config.AppConfig.UploadDir), nil
+	},
+}
+
+var (
+	fileStorages   = map[string]FileStorage{}
+	fileStoragesMu sync.Mutex
+)
+
+// fileStorage 返回指定名称的存储后端（同一后端只创建一次）
+func fileStorage(backend string) (FileStorage, error) {
+	fileStoragesMu.Lock()
+	defer fileStoragesMu.Unlock()
+
+	if storage, ok := fileStorages[backend]; ok {
+		return storage, nil
+	}
+	factory, ok := fileStorageFactories[backend]
+	if !ok {
+		return nil, fmt.Errorf("不支持的存储后端: %s", backend)
+	}
+	storage, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	fileStorages[backend] = storage
+	return storage, nil
+}
+
+// readStoredFile 读取存储后端中的文件内容
+func readStoredFile(backend string, key string) ([]byte, error) {
+	storage, err := fileStorage(backend)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := storage.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// LocalFileStorage 将文件保存在本地目录中
+type LocalFileStorage struct {
+	root string
+}
+
+// NewLocalFileStorage 创建以 root 为根目录的本地存储
+func NewLocalFileStorage(root string) *LocalFileStorage {
+	return &LocalFileStorage{root: root}
+}
+
+// path 将 key 转换为磁盘路径，拒绝跳出根目录的 key
+func (s *LocalFileStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(key))
+	if cleaned == "." || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.New("无效的文件路径")
+	}
+	return filepath.Join(s.root, cleaned), nil
+}
+
+func (s *LocalFileStorage) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalFileStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalFileStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
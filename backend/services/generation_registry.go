@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"sync"
+	"time"
+)
+
+// FinishReasonCancelled 生成被取消时AI回复的 Metadata.finish_reason
+const FinishReasonCancelled = "cancelled"
+
+// GenerationRegistry 记录正在进行的生成，用于取消和流式重连（只在当前进程内有效，多实例部署时需要请求落到同一实例）
+type GenerationRegistry struct {
+	mu      sync.Mutex
+	nextID  uint64
+	active  map[uint]map[uint64]context.CancelFunc // 对话 ID -> 生成 ID -> 取消函数
+	streams map[uint]*GenerationStream             // 对话最近一次流式生成（结束后保留 streamRetention）
+}
+
+// DefaultGenerationRegistry 默认的生成注册表
+var DefaultGenerationRegistry = NewGenerationRegistry()
+
+// NewGenerationRegistry 创建空的生成注册表
+func NewGenerationRegistry() *GenerationRegistry {
+	return &GenerationRegistry{
+		active:  make(map[uint]map[uint64]context.CancelFunc),
+		streams: make(map[uint]*GenerationStream),
+	}
+}
+
+// Start 登记一次生成，返回可被取消的 ctx 和结束时调用的 finish
+func (r *GenerationRegistry) Start(parent context.Context, conversationID uint) (context.Context, func()) {
+	ctx, _, finish := r.start(parent, conversationID)
+	return ctx, finish
+}
+
+func (r *GenerationRegistry) start(parent context.Context, conversationID uint) (context.Context, uint64, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	if r.active[conversationID] == nil {
+		r.active[conversationID] = make(map[uint64]context.CancelFunc)
+	}
+	r.active[conversationID][id] = cancel
+	r.mu.Unlock()
+
+	finish := func() {
+		r.mu.Lock()
+		delete(r.active[conversationID], id)
+		if len(r.active[conversationID]) == 0 {
+			delete(r.active, conversationID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+	return ctx, id, finish
+}
+
+// StartStream 登记一次后台流式生成：生成不随请求连接结束，事件写入缓冲供客户端连接和重连
+// 生成结束时调用 finish，缓冲会继续保留 streamRetention
+func (r *GenerationRegistry) StartStream(conversationID uint) (*GenerationStream, context.Context, func()) {
+	ctx, id, finishGeneration := r.start(context.Background(), conversationID)
+
+	r.mu.Lock()
+	stream := newGenerationStream(id, finishGeneration)
+	r.streams[conversationID] = stream
+	r.mu.Unlock()
+
+	finish := func() {
+		stream.close()
+		finishGeneration()
+		time.AfterFunc(streamRetention, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.streams[conversationID] == stream {
+				delete(r.streams, conversationID)
+			}
+		})
+	}
+	return stream, ctx, finish
+}
+
+// Stream 返回对话最近一次流式生成的事件缓冲，没有时返回 nil
+func (r *GenerationRegistry) Stream(conversationID uint) *GenerationStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[conversationID]
+}
+
+// StreamByTicket 返回票据对应的对话最近一次流式生成，票据无效或已失效时返回 nil
+func (r *GenerationRegistry) StreamByTicket(conversationID uint, ticket string) *GenerationStream {
+	stream := r.Stream(conversationID)
+	if stream == nil || stream.ticket == "" || subtle.ConstantTimeCompare([]byte(stream.ticket), []byte(ticket)) != 1 {
+		return nil
+	}
+	return stream
+}
+
+// Cancel 取消对话中所有正在进行的生成，返回取消的数量
+func (r *GenerationRegistry) Cancel(conversationID uint) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	generations := r.active[conversationID]
+	for _, cancel := range generations {
+		cancel()
+	}
+	return len(generations)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamRetention 生成结束后保留事件缓冲的时间，供断线的客户端重新连接补齐事件
+	streamRetention = 5 * time.Minute
+	// streamDetachTimeout 没有客户端连接超过该时间时取消生成
+	streamDetachTimeout = 2 * time.Minute
+)
+
+// SSEEvent 带 ID 的 SSE 事件，ID 格式为 "<生成 ID>-<序号>"
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  json.RawMessage
+}
+
+// GenerationStream 一次流式生成的事件缓冲：生成在后台进行，与发起请求的连接无关，
+// 客户端断线后可以通过最后收到的事件 ID 重新连接，补齐错过的事件并继续接收
+type GenerationStream struct {
+	id     uint64
+	ticket string
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	events      []SSEEvent
+	closed      bool
+	notify      chan struct{} // 有新事件或结束时关闭并替换
+	subscribers int
+	idleTimer   *time.Timer
+}
+
+func newGenerationStream(id uint64, cancel context.CancelFunc) *GenerationStream {
+	stream := &GenerationStream{
+		id:     id,
+		cancel: cancel,
+		notify: make(chan struct{}),
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err == nil {
+		stream.ticket = hex.EncodeToString(buf)
+	}
+	return stream
+}
+
+// Ticket 返回连接该流的票据，供无法设置请求头的浏览器 EventSource 认证使用
+// 票据只对这一次生成有效：流被新的生成替换或在结束 streamRetention 后移除时随之失效
+func (s *GenerationStream) Ticket() string {
+	return s.ticket
+}
+
+// Publish 追加事件并通知所有连接
+func (s *GenerationStream) Publish(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte("null")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.events = append(s.events, SSEEvent{
+		ID:    strconv.FormatUint(s.id, 10) + "-" + strconv.Itoa(len(s.events)+1),
+		Event: event,
+		Data:  payload,
+	})
+	s.broadcast()
+}
+
+// close 结束事件流
+func (s *GenerationStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	s.broadcast()
+}
+
+func (s *GenerationStream) broadcast() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Since 返回 lastEventID 之后的事件、等待新事件的通道，以及事件流是否已结束
+// lastEventID 为空或不属于本次生成时从头返回
+func (s *GenerationStream) Since(lastEventID string) ([]SSEEvent, <-chan struct{}, bool) {
+	seq := s.sequence(lastEventID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq > len(s.events) {
+		seq = len(s.events)
+	}
+	return append([]SSEEvent(nil), s.events[seq:]...), s.notify, s.closed
+}
+
+// sequence 解析事件 ID 中的序号
+func (s *GenerationStream) sequence(eventID string) int {
+	prefix := strconv.FormatUint(s.id, 10) + "-"
+	if !strings.HasPrefix(eventID, prefix) {
+		return 0
+	}
+	seq, err := strconv.Atoi(strings.TrimPrefix(eventID, prefix))
+	if err != nil || seq < 0 {
+		return 0
+	}
+	return seq
+}
+
+// Attach 登记一个连接，返回断开时调用的 detach；所有连接断开超过 streamDetachTimeout 后取消生成
+func (s *GenerationStream) Attach() func() {
+	s.mu.Lock()
+	s.subscribers++
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.subscribers--
+			if s.subscribers == 0 && !s.closed {
+				s.idleTimer = time.AfterFunc(streamDetachTimeout, s.cancelIfDetached)
+			}
+		})
+	}
+}
+
+func (s *GenerationStream) cancelIfDetached() {
+	s.mu.Lock()
+	detached := s.subscribers == 0 && !s.closed
+	s.mu.Unlock()
+	if detached {
+		s.cancel()
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 简化的 JSONPath 实现，支持：
+// $ 根节点（可省略）、.key 或 ['key'] 取字段、[0] 取数组元素（负数从末尾计）、[*] 或 .* 遍历全部元素（对象按键名排序）
+// 例如 $.choices[0].message.content、$.content[*].text、usage.prompt_tokens
+
+// pathSegment 路径中的一段
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath 解析路径
+func parseJSONPath(path string) ([]pathSegment, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, fmt.Errorf("路径为空")
+	}
+	original := path
+	path = strings.TrimPrefix(path, "$")
+
+	var segments []pathSegment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("路径 %s 中缺少字段名", original)
+			}
+			key := path[i:end]
+			if key == "*" {
+				segments = append(segments, pathSegment{wildcard: true})
+			} else {
+				segments = append(segments, pathSegment{key: key})
+			}
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("路径 %s 中的 [ 没有闭合", original)
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+
+			switch {
+			case inner == "*":
+				segments = append(segments, pathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, pathSegment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("路径 %s 中的下标 %s 无效", original, inner)
+				}
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			}
+		default:
+			// 允许省略开头的点，例如 choices[0].message
+			if len(segments) > 0 {
+				return nil, fmt.Errorf("路径 %s 格式错误", original)
+			}
+			path = "." + path[i:]
+			i = 0
+		}
+	}
+
+	return segments, nil
+}
+
+// lookupJSONPath 按路径查找所有匹配的值，路径无效或未匹配时返回 nil
+func lookupJSONPath(data interface{}, path string) []interface{} {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil
+	}
+
+	current := []interface{}{data}
+	for _, segment := range segments {
+		var next []interface{}
+		for _, value := range current {
+			switch node := value.(type) {
+			case map[string]interface{}:
+				if segment.wildcard {
+					// 对象的字段按键名排序，保证多个匹配拼接时的顺序固定
+					keys := make([]string, 0, len(node))
+					for key := range node {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, node[key])
+					}
+				} else if child, ok := node[segment.key]; ok && !segment.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if segment.wildcard {
+					next = append(next, node...)
+				} else if segment.isIndex {
+					index := segment.index
+					if index < 0 {
+						index += len(node)
+					}
+					if index >= 0 && index < len(node) {
+						next = append(next, node[index])
+					}
+				}
+			}
+		}
+		current = next
+		if len(current) == 0 {
+			return nil
+		}
+	}
+
+	return current
+}
+
+// jsonPathString 按路径取字符串，多个匹配时按顺序拼接
+func jsonPathString(data interface{}, path string) (string, bool) {
+	values := lookupJSONPath(data, path)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	var builder strings.Builder
+	found := false
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			builder.WriteString(v)
+			found = true
+		case float64:
+			builder.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+			found = true
+		case bool:
+			builder.WriteString(strconv.FormatBool(v))
+			found = true
+		}
+	}
+	return builder.String(), found
+}
+
+// jsonPathInt 按路径取整数，多个匹配时求和
+func jsonPathInt(data interface{}, path string) (int, bool) {
+	values := lookupJSONPath(data, path)
+	total, found := 0, false
+	for _, value := range values {
+		switch v := value.(type) {
+		case float64:
+			total += int(v)
+			found = true
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				total += n
+				found = true
+			}
+		}
+	}
+	return total, found
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultChunkSize    = 800
+	DefaultChunkOverlap = 100
+	MaxChunkSize        = 4000
+
+	// indexTimeout 单个文档索引的超时时间
+	indexTimeout = 30 * time.Minute
+)
+
+// RetrievedChunk 检索命中的分段
+type RetrievedChunk struct {
+	ChunkID         uint    `json:"chunk_id"`
+	KnowledgeBaseID uint    `json:"knowledge_base_id"`
+	DocumentID      uint    `json:"document_id"`
+	DocumentName    string  `json:"document_name"`
+	ChunkIndex      int     `json:"chunk_index"`
+	Content         string  `json:"content"`
+	Score           float64 `json:"score"`
+}
+
+// KnowledgeBaseService 知识库：文档切分、计算向量、建立索引和检索
+type KnowledgeBaseService struct {
+	aiService *AIService
+	index     VectorIndex
+}
+
+func NewKnowledgeBaseService(aiService *AIService) *KnowledgeBaseService {
+	return &KnowledgeBaseService{
+		aiService: aiService,
+		index:     DefaultVectorIndex,
+	}
+}
+
+// AddDocument 将上传的文件加入知识库，切分和计算向量由索引队列在后台进行（文档状态为 processing）
+func (s *KnowledgeBaseService) AddDocument(kb *models.KnowledgeBase, file *models.File) (*models.KnowledgeDocument, error) {
+	if file.Kind != models.FileKindDocument {
+		return nil, errors.New("只能将文档加入知识库")
+	}
+
+	document := &models.KnowledgeDocument{
+		KnowledgeBaseID: kb.ID,
+		FileID:          &file.ID,
+		Name:            file.Name,
+		Status:          models.KnowledgeDocumentProcessing,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(document).Error; err != nil {
+			return err
+		}
+		return tx.Model(kb).UpdateColumn("document_count", gorm.Expr("document_count + 1")).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := DefaultKnowledgeIndexer.Enqueue(document.ID); err != nil {
+		if deleteErr := s.DeleteDocument(context.Background(), kb, document.ID); deleteErr != nil {
+			log.Printf("知识库 %d 删除未加入队列的文档 %d 失败: %v", kb.ID, document.ID, deleteErr)
+		}
+		return nil, err
+	}
+	return document, nil
+}
+
+// indexDocument 切分文档、计算向量并写入分段，完成后更新文档状态
+func (s *KnowledgeBaseService) indexDocument(documentID uint) {
+	var document models.KnowledgeDocument
+	if err := database.DB.First(&document, documentID).Error; err != nil || document.Status != models.KnowledgeDocumentProcessing {
+		// 排队期间文档已被删除
+		return
+	}
+	var kb models.KnowledgeBase
+	if err := database.DB.First(&kb, document.KnowledgeBaseID).Error; err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+
+	// 服务重启前未完成的索引可能已写入部分分段
+	chunkCount := 0
+	err := s.deleteChunks(ctx, "document_id = ?", document.ID)
+	if err == nil {
+		chunkCount, err = s.embedDocument(ctx, &kb, &document)
+	}
+	if err != nil {
+		log.Printf("知识库 %d 文档 %d 索引失败: %v", kb.ID, document.ID, err)
+		database.DB.Model(&document).Updates(map[string]interface{}{
+			"status": models.KnowledgeDocumentFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	// 索引期间文档可能已被删除，此时清理写入的分段
+	result := database.DB.Model(&document).Updates(map[string]interface{}{
+		"status":      models.KnowledgeDocumentReady,
+		"chunk_count": chunkCount,
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		s.deleteChunks(ctx, "document_id = ?", document.ID)
+		return
+	}
+	database.DB.Model(&kb).UpdateColumn("chunk_count", gorm.Expr("chunk_count + ?", chunkCount))
+}
+
+func (s *KnowledgeBaseService) embedDocument(ctx context.Context, kb *models.KnowledgeBase, document *models.KnowledgeDocument) (int, error) {
+	var apiConfig models.APIConfig
+	if kb.APIConfigID == nil || database.DB.First(&apiConfig, *kb.APIConfigID).Error != nil {
+		return 0, errors.New("知识库的 API 配置不存在")
+	}
+	var file models.File
+	if document.FileID == nil || database.DB.First(&file, *document.FileID).Error != nil {
+		return 0, errors.New("文档对应的文件已被删除")
+	}
+
+	contents := chunkText(file.ExtractedText, kb.ChunkSize, kb.ChunkOverlap)
+	if len(contents) == 0 {
+		return 0, errors.New("文档中没有可索引的文本")
+	}
+
+	vectors, err := s.aiService.Embed(ctx, &apiConfig, kb.EmbeddingModel, contents)
+	if err != nil {
+		return 0, err
+	}
+	chunks := make([]models.KnowledgeChunk, 0, len(contents))
+	for i, vector := range vectors {
+		if err := s.checkDimensions(kb, len(vector)); err != nil {
+			return 0, err
+		}
+		chunks = append(chunks, models.KnowledgeChunk{
+			KnowledgeBaseID: kb.ID,
+			DocumentID:      document.ID,
+			ChunkIndex:      i,
+			Content:         contents[i],
+			Embedding:       vector,
+		})
+	}
+
+	if err := database.DB.CreateInBatches(&chunks, 100).Error; err != nil {
+		return 0, err
+	}
+	if err := s.index.Index(ctx, chunks); err != nil {
+		s.deleteChunks(ctx, "document_id = ?", document.ID)
+		return 0, err
+	}
+	return len(chunks), nil
+}
+
+// checkDimensions 知识库的向量维度在首次索引时确定，之后的文档必须一致
+func (s *KnowledgeBaseService) checkDimensions(kb *models.KnowledgeBase, dimensions int) error {
+	if kb.Dimensions == 0 {
+		database.DB.Model(kb).Where("dimensions = 0").UpdateColumn("dimensions", dimensions)
+		database.DB.Model(kb).Select("dimensions").First(kb)
+	}
+	if kb.Dimensions != dimensions {
+		return fmt.Errorf("向量维度不一致（知识库为 %d，当前为 %d），嵌入模型可能已更改", kb.Dimensions, dimensions)
+	}
+	return nil
+}
+
+// DeleteDocument 删除知识库中的文档及其分段
+func (s *KnowledgeBaseService) DeleteDocument(ctx context.Context, kb *models.KnowledgeBase, documentID uint) error {
+	var document models.KnowledgeDocument
+	if err := database.DB.Where("id = ? AND knowledge_base_id = ?", documentID, kb.ID).First(&document).Error; err != nil {
+		return err
+	}
+
+	if err := s.deleteChunks(ctx, "document_id = ?", document.ID); err != nil {
+		return err
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&document).Error; err != nil {
+			return err
+		}
+		return tx.Model(kb).UpdateColumns(map[string]interface{}{
+			"document_count": gorm.Expr("GREATEST(document_count - 1, 0)"),
+			"chunk_count":    gorm.Expr("GREATEST(chunk_count - ?, 0)", document.ChunkCount),
+		}).Error
+	})
+}
+
+// DeleteKnowledgeBase 删除知识库及其所有文档和分段
+func (s *KnowledgeBaseService) DeleteKnowledgeBase(ctx context.Context, kb *models.KnowledgeBase) error {
+	if err := s.deleteChunks(ctx, "knowledge_base_id = ?", kb.ID); err != nil {
+		return err
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("knowledge_base_id = ?", kb.ID).Delete(&models.KnowledgeDocument{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(kb).Error
+	})
+}
+
+// deleteChunks 从索引和数据库中删除满足条件的分段
+func (s *KnowledgeBaseService) deleteChunks(ctx context.Context, query string, args ...interface{}) error {
+	var chunkIDs []uint
+	if err := database.DB.Model(&models.KnowledgeChunk{}).Where(query, args...).Pluck("id", &chunkIDs).Error; err != nil {
+		return err
+	}
+	if len(chunkIDs) == 0 {
+		return nil
+	}
+	if err := s.index.Remove(ctx, chunkIDs); err != nil {
+		return err
+	}
+	return database.DB.Where("id IN ?", chunkIDs).Delete(&models.KnowledgeChunk{}).Error
+}
+
+// Search 在多个知识库中检索与 query 最相关的 topK 个分段
+// 不同知识库可能使用不同的嵌入模型，按（API 配置，模型）分组分别计算查询向量
+func (s *KnowledgeBaseService) Search(ctx context.Context, knowledgeBases []models.KnowledgeBase, query string, topK int) ([]RetrievedChunk, error) {
+	type embeddingKey struct {
+		apiConfigID uint
+		model       string
+	}
+	groups := make(map[embeddingKey][]uint)
+	configs := make(map[uint]*models.APIConfig)
+	for _, kb := range knowledgeBases {
+		if kb.APIConfigID == nil || kb.APIConfig == nil || kb.ChunkCount == 0 {
+			continue
+		}
+		key := embeddingKey{apiConfigID: *kb.APIConfigID, model: kb.EmbeddingModel}
+		groups[key] = append(groups[key], kb.ID)
+		configs[*kb.APIConfigID] = kb.APIConfig
+	}
+
+	var matches []VectorMatch
+	for key, ids := range groups {
+		vectors, err := s.aiService.Embed(ctx, configs[key.apiConfigID], key.model, []string{query})
+		if err != nil {
+			return nil, err
+		}
+		found, err := s.index.Search(ctx, ids, vectors[0], topK)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return s.loadChunks(matches)
+}
+
+// loadChunks 加载命中分段的内容和所属文档名称，保持相似度顺序
+func (s *KnowledgeBaseService) loadChunks(matches []VectorMatch) ([]RetrievedChunk, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ChunkID
+	}
+
+	var chunks []models.KnowledgeChunk
+	if err := database.DB.Omit("embedding").Where("id IN ?", ids).Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.KnowledgeChunk, len(chunks))
+	documentIDs := make([]uint, 0, len(chunks))
+	for _, chunk := range chunks {
+		byID[chunk.ID] = chunk
+		documentIDs = append(documentIDs, chunk.DocumentID)
+	}
+
+	var documents []models.KnowledgeDocument
+	if err := database.DB.Select("id", "name").Where("id IN ?", documentIDs).Find(&documents).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(documents))
+	for _, document := range documents {
+		names[document.ID] = document.Name
+	}
+
+	results := make([]RetrievedChunk, 0, len(matches))
+	for _, match := range matches {
+		chunk, ok := byID[match.ChunkID]
+		if !ok {
+			continue
+		}
+		results = append(results, RetrievedChunk{
+			ChunkID:         chunk.ID,
+			KnowledgeBaseID: chunk.KnowledgeBaseID,
+			DocumentID:      chunk.DocumentID,
+			DocumentName:    names[chunk.DocumentID],
+			ChunkIndex:      chunk.ChunkIndex,
+			Content:         chunk.Content,
+			Score:           match.Score,
+		})
+	}
+	return results, nil
+}
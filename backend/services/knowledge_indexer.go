@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+)
+
+const (
+	// knowledgeIndexWorkers 同时索引的文档数
+	knowledgeIndexWorkers = 2
+	// knowledgeIndexQueueSize 等待索引的文档上限，队列已满时拒绝新加入的文档
+	knowledgeIndexQueueSize = 100
+)
+
+// ErrIndexQueueFull 等待索引的文档过多
+var ErrIndexQueueFull = errors.New("等待索引的文档过多，请稍后再试")
+
+// KnowledgeIndexer 知识库文档的索引队列：固定数量的 worker 依次处理 processing 状态的文档
+type KnowledgeIndexer struct {
+	jobs      chan uint
+	startOnce sync.Once
+}
+
+// DefaultKnowledgeIndexer 全局的文档索引队列，服务启动时调用 Start
+var DefaultKnowledgeIndexer = NewKnowledgeIndexer(knowledgeIndexQueueSize)
+
+func NewKnowledgeIndexer(queueSize int) *KnowledgeIndexer {
+	return &KnowledgeIndexer{jobs: make(chan uint, queueSize)}
+}
+
+// Start 启动 worker，并将上次退出时仍处于 processing 状态的文档重新加入队列
+func (q *KnowledgeIndexer) Start() error {
+	var documentIDs []uint
+	if err := database.DB.Model(&models.KnowledgeDocument{}).Where("status = ?", models.KnowledgeDocumentProcessing).
+		Order("id ASC").Pluck("id", &documentIDs).Error; err != nil {
+		return err
+	}
+
+	q.startOnce.Do(func() {
+		for i := 0; i < knowledgeIndexWorkers; i++ {
+			go q.work()
+		}
+	})
+
+	// 遗留的文档可能超过队列容量，在后台依次加入
+	go func() {
+		for _, id := range documentIDs {
+			q.jobs <- id
+		}
+	}()
+	return nil
+}
+
+// Enqueue 将文档加入索引队列，队列已满时返回 ErrIndexQueueFull
+func (q *KnowledgeIndexer) Enqueue(documentID uint) error {
+	select {
+	case q.jobs <- documentID:
+		return nil
+	default:
+		return ErrIndexQueueFull
+	}
+}
+
+func (q *KnowledgeIndexer) work() {
+	service := NewKnowledgeBaseService(NewAIService())
+	for documentID := range q.jobs {
+		service.indexDocument(documentID)
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// MessageTreeService 对话消息树：消息通过 ParentID 组成树，重新生成和编辑会产生兄弟消息（分支），
+// 对话的 ActiveMessageID 指向当前分支的最后一条消息
+type MessageTreeService struct{}
+
+func NewMessageTreeService() *MessageTreeService {
+	return &MessageTreeService{}
+}
+
+// messageTree 一个对话的所有消息（不含上下文摘要）
+type messageTree struct {
+	byID     map[uint]*models.Message
+	children map[uint][]*models.Message // 根消息的 key 为 0
+}
+
+// load 加载对话的消息树
+func (s *MessageTreeService) load(conversationID uint) (*messageTree, error) {
+	var messages []models.Message
+	if err := database.DB.Where("conversation_id = ? AND role <> ?", conversationID, models.RoleSystem).
+		Order("id ASC").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	tree := &messageTree{
+		byID:     make(map[uint]*models.Message, len(messages)),
+		children: make(map[uint][]*models.Message),
+	}
+	for i := range messages {
+		msg := &messages[i]
+		tree.byID[msg.ID] = msg
+		parentID := uint(0)
+		if msg.ParentID != nil {
+			parentID = *msg.ParentID
+		}
+		tree.children[parentID] = append(tree.children[parentID], msg)
+	}
+	return tree, nil
+}
+
+// EnsureTree 为分支功能上线前的对话补全父子关系：按时间顺序串成一条分支，并指向最后一条消息
+func (s *MessageTreeService) EnsureTree(conversation *models.Conversation) error {
+	if conversation.ActiveMessageID != nil {
+		return nil
+	}
+
+	var messages []models.Message
+	if err := database.DB.Where("conversation_id = ? AND role <> ?", conversation.ID, models.RoleSystem).
+		Order("created_at ASC, id ASC").Find(&messages).Error; err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		for i := 1; i < len(messages); i++ {
+			if messages[i].ParentID != nil {
+				continue
+			}
+			if err := tx.Model(&messages[i]).UpdateColumn("parent_id", messages[i-1].ID).Error; err != nil {
+				return err
+			}
+		}
+		lastID := messages[len(messages)-1].ID
+		if err := tx.Model(conversation).UpdateColumn("active_message_id", lastID).Error; err != nil {
+			return err
+		}
+		conversation.ActiveMessageID = &lastID
+		return nil
+	})
+}
+
+// ActiveBranch 返回当前分支从第一条到最后一条的消息
+func (s *MessageTreeService) ActiveBranch(conversation *models.Conversation) ([]models.Message, error) {
+	if err := s.EnsureTree(conversation); err != nil {
+		return nil, err
+	}
+	if conversation.ActiveMessageID == nil {
+		return []models.Message{}, nil
+	}
+	return s.PathTo(conversation.ID, *conversation.ActiveMessageID)
+}
+
+// PathTo 返回从第一条消息到指定消息的路径
+func (s *MessageTreeService) PathTo(conversationID uint, messageID uint) ([]models.Message, error) {
+	tree, err := s.load(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.pathTo(messageID)
+}
+
+func (t *messageTree) pathTo(messageID uint) ([]models.Message, error) {
+	var path []models.Message
+	current, ok := t.byID[messageID]
+	if !ok {
+		return nil, errors.New("消息不存在")
+	}
+	for current != nil {
+		path = append(path, *current)
+		if current.ParentID == nil {
+			break
+		}
+		current = t.byID[*current.ParentID]
+	}
+
+	// 反转为从旧到新
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// ContextMessages 返回生成回复所需的历史消息：指定消息所在的路径和对话的上下文摘要
+func (s *MessageTreeService) ContextMessages(conversationID uint, messageID uint) ([]models.Message, error) {
+	path, err := s.PathTo(conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []models.Message
+	if err := database.DB.Where("conversation_id = ? AND role = ?", conversationID, models.RoleSystem).
+		Order("id ASC").Find(&summaries).Error; err != nil {
+		return nil, err
+	}
+	return append(summaries, path...), nil
+}
+
+// Responses 转换为响应并附带兄弟消息信息
+func (s *MessageTreeService) Responses(conversationID uint, messages []models.Message) ([]models.MessageResponse, error) {
+	tree, err := s.load(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.MessageResponse, 0, len(messages))
+	for _, msg := range messages {
+		resp := msg.ToResponse()
+		parentID := uint(0)
+		if msg.ParentID != nil {
+			parentID = *msg.ParentID
+		}
+		for i, sibling := range tree.children[parentID] {
+			resp.SiblingIDs = append(resp.SiblingIDs, sibling.ID)
+			if sibling.ID == msg.ID {
+				resp.SiblingIndex = i
+			}
+		}
+		resp.SiblingCount = len(resp.SiblingIDs)
+		responses = append(responses, resp)
+	}
+	return responses, nil
+}
+
+// SelectBranch 切换到包含指定消息的分支（沿最新的子消息走到叶子）
+func (s *MessageTreeService) SelectBranch(conversation *models.Conversation, messageID uint) error {
+	tree, err := s.load(conversation.ID)
+	if err != nil {
+		return err
+	}
+	if _, ok := tree.byID[messageID]; !ok {
+		return errors.New("消息不存在")
+	}
+
+	leafID := tree.latestLeaf(messageID)
+	return s.setActive(conversation, &leafID)
+}
+
+// latestLeaf 从指定消息开始，每一层选择最新的子消息，返回叶子消息 ID
+func (t *messageTree) latestLeaf(messageID uint) uint {
+	for {
+		children := t.children[messageID]
+		if len(children) == 0 {
+			return messageID
+		}
+		messageID = children[len(children)-1].ID
+	}
+}
+
+// DeleteSubtree 删除消息及其后续的所有消息，当前分支被删除时切换到相邻分支
+func (s *MessageTreeService) DeleteSubtree(conversation *models.Conversation, messageID uint) error {
+	tree, err := s.load(conversation.ID)
+	if err != nil {
+		return err
+	}
+	target, ok := tree.byID[messageID]
+	if !ok {
+		return errors.New("消息不存在")
+	}
+
+	ids := []uint{}
+	activeDeleted := false
+	queue := []uint{messageID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		ids = append(ids, id)
+		if conversation.ActiveMessageID != nil && *conversation.ActiveMessageID == id {
+			activeDeleted = true
+		}
+		for _, child := range tree.children[id] {
+			queue = append(queue, child.ID)
+		}
+	}
+
+	// 优先切换到被删除消息的兄弟分支，没有时回到父消息
+	var nextActiveID *uint
+	if activeDeleted {
+		parentID := uint(0)
+		if target.ParentID != nil {
+			parentID = *target.ParentID
+		}
+		var siblings []*models.Message
+		for _, sibling := range tree.children[parentID] {
+			if sibling.ID != messageID {
+				siblings = append(siblings, sibling)
+			}
+		}
+		tree.children[parentID] = siblings
+
+		switch {
+		case len(siblings) > 0:
+			leafID := tree.latestLeaf(siblings[len(siblings)-1].ID)
+			nextActiveID = &leafID
+		case target.ParentID != nil:
+			nextActiveID = target.ParentID
+		}
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN ?", ids).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+		if !activeDeleted {
+			return nil
+		}
+		if err := tx.Model(conversation).UpdateColumn("active_message_id", nextActiveID).Error; err != nil {
+			return err
+		}
+		conversation.ActiveMessageID = nextActiveID
+		return nil
+	})
+}
+
+// DeleteMessage 只删除一条消息，子消息接到被删除消息的父消息下；当前分支的最后一条消息被删除时指向父消息
+func (s *MessageTreeService) DeleteMessage(conversation *models.Conversation, message *models.Message) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Message{}).Where("conversation_id = ? AND parent_id = ?", message.ConversationID, message.ID).
+			UpdateColumn("parent_id", message.ParentID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(message).Error; err != nil {
+			return err
+		}
+		if conversation.ActiveMessageID == nil || *conversation.ActiveMessageID != message.ID {
+			return nil
+		}
+		if err := tx.Model(conversation).UpdateColumn("active_message_id", message.ParentID).Error; err != nil {
+			return err
+		}
+		conversation.ActiveMessageID = message.ParentID
+		return nil
+	})
+}
+
+// SetActive 设置当前分支的最后一条消息
+func (s *MessageTreeService) SetActive(conversation *models.Conversation, messageID uint) error {
+	return s.setActive(conversation, &messageID)
+}
+
+func (s *MessageTreeService) setActive(conversation *models.Conversation, messageID *uint) error {
+	if err := database.DB.Model(conversation).UpdateColumn("active_message_id", messageID).Error; err != nil {
+		return err
+	}
+	conversation.ActiveMessageID = messageID
+	return nil
+}
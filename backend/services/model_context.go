@@ -0,0 +1,68 @@
+package services
+
+import (
+	"strings"
+	"sync"
+)
+
+// modelContextLengths 常见模型的上下文长度（按模型名称前缀匹配，取最长前缀）
+var modelContextLengths = map[string]int{
+	"gpt-4o":            128000,
+	"gpt-4-turbo":       128000,
+	"gpt-4-1106":        128000,
+	"gpt-4-0125":        128000,
+	"gpt-4-32k":         32768,
+	"gpt-4":             8192,
+	"gpt-3.5-turbo":     16385,
+	"claude-3":          200000,
+	"claude-2.1":        200000,
+	"claude-2.0":        100000,
+	"gemini-pro-vision": 16384,
+	"gemini-pro":        32768,
+	"gemini-ultra":      32768,
+	"gemini-1.5":        1048576,
+	"gemini-2":          1048576,
+	"llama-3":           8192,
+	"llama-2":           4096,
+}
+
+// knownContextLengths 从模型列表接口（例如 OpenRouter）获取到的精确上下文长度，优先于前缀匹配
+var (
+	knownContextLengths   = map[string]int{}
+	knownContextLengthsMu sync.RWMutex
+)
+
+// RegisterModelContextLength 记录模型的上下文长度
+func RegisterModelContextLength(modelName string, contextLength int) {
+	if modelName == "" || contextLength <= 0 {
+		return
+	}
+	knownContextLengthsMu.Lock()
+	defer knownContextLengthsMu.Unlock()
+	knownContextLengths[strings.ToLower(modelName)] = contextLength
+}
+
+// ModelContextLength 获取模型的上下文长度，未知模型返回 0
+func ModelContextLength(modelName string) int {
+	name := strings.ToLower(modelName)
+
+	knownContextLengthsMu.RLock()
+	contextLength, ok := knownContextLengths[name]
+	knownContextLengthsMu.RUnlock()
+	if ok {
+		return contextLength
+	}
+
+	// 去掉 OpenRouter 等平台的服务商前缀，例如 anthropic/claude-3.5-sonnet
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	bestPrefix := ""
+	for prefix := range modelContextLengths {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+		}
+	}
+	return modelContextLengths[bestPrefix]
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"strings"
+	"sync"
+)
+
+// modelImageSupport 常见模型是否支持图片输入（按模型名称前缀匹配，取最长前缀），未列出的模型不做限制
+var modelImageSupport = map[string]bool{
+	"gpt-4o":            true,
+	"gpt-4.1":           true,
+	"gpt-4.5":           true,
+	"gpt-5":             true,
+	"gpt-4-turbo":       true,
+	"gpt-4-vision":      true,
+	"gpt-4":             false,
+	"gpt-3.5":           false,
+	"o1":                true,
+	"o1-mini":           false,
+	"o3":                true,
+	"o3-mini":           false,
+	"o4":                true,
+	"claude-3":          true,
+	"claude-sonnet-4":   true,
+	"claude-opus-4":     true,
+	"claude-haiku-4":    true,
+	"claude-2":          false,
+	"claude-instant":    false,
+	"gemini-pro-vision": true,
+	"gemini-pro":        false,
+	"gemini-1.5":        true,
+	"gemini-2":          true,
+	"llava":             true,
+	"bakllava":          true,
+	"llama3.2-vision":   true,
+	"llama-3.2-vision":  true,
+	"qwen-vl":           true,
+	"qwen2.5-vl":        true,
+	"pixtral":           true,
+	"llama-2":           false,
+	"llama-3":           false,
+	"llama2":            false,
+	"llama3":            false,
+	"mistral":           false,
+	"deepseek":          false,
+}
+
+// knownImageSupport 从模型列表接口获取到的输入模态，优先于前缀匹配
+var (
+	knownImageSupport   = map[string]bool{}
+	knownImageSupportMu sync.RWMutex
+)
+
+// RegisterModelModality 记录模型的模态（OpenRouter 的 architecture.modality，例如 text+image->text）
+func RegisterModelModality(modelName string, modality string) {
+	if modelName == "" || modality == "" {
+		return
+	}
+	input := strings.SplitN(strings.ToLower(modality), "->", 2)[0]
+
+	knownImageSupportMu.Lock()
+	defer knownImageSupportMu.Unlock()
+	knownImageSupport[strings.ToLower(modelName)] = strings.Contains(input, "image")
+}
+
+// ModelSupportsImages 模型是否支持图片输入，known 为 false 表示未知模型
+func ModelSupportsImages(modelName string) (supported bool, known bool) {
+	name := strings.ToLower(modelName)
+
+	knownImageSupportMu.RLock()
+	supported, ok := knownImageSupport[name]
+	knownImageSupportMu.RUnlock()
+	if ok {
+		return supported, true
+	}
+
+	// 去掉 OpenRouter 等平台的服务商前缀，例如 openai/gpt-4o
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	bestPrefix := ""
+	for prefix := range modelImageSupport {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+		}
+	}
+	if bestPrefix == "" {
+		return false, false
+	}
+	return modelImageSupport[bestPrefix], true
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+)
+
+const (
+	// 未配置定价时使用的默认价格（每百万 Token，USD）
+	defaultInputPrice  = 0.1
+	defaultOutputPrice = 0.2
+	defaultCurrency    = "USD"
+
+	PriceSourceManual     = "manual"
+	PriceSourceOpenRouter = "openrouter"
+
+	openRouterModelsURL = "https://openrouter.ai/api/v1/models"
+)
+
+// OpenRouterModel OpenRouter 模型信息
+type OpenRouterModel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Pricing     struct {
+		Prompt         string `json:"prompt"`
+		Completion     string `json:"completion"`
+		InputCacheRead string `json:"input_cache_read"`
+	} `json:"pricing"`
+	ContextLength int `json:"context_length"`
+	Architecture  struct {
+		Modality string `json:"modality"`
+	} `json:"architecture"`
+	TopProvider struct {
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"`
+}
+
+type OpenRouterResponse struct {
+	Data []OpenRouterModel `json:"data"`
+}
+
+// syncMu 避免并发同步时重复写入同一模型的价格
+var syncMu sync.Mutex
+
+type PricingService struct{}
+
+func NewPricingService() *PricingService {
+	return &PricingService{}
+}
+
+// FetchOpenRouterModels 从 OpenRouter 获取模型列表（包含定价和上下文长度）
+func FetchOpenRouterModels() ([]OpenRouterModel, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(openRouterModelsURL)
+	if err != nil {
+		return nil, fmt.Errorf("获取模型列表失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取模型列表失败 (状态码: %d): %s", resp.StatusCode, string(body))
+	}
+
+	var openRouterResp OpenRouterResponse
+	if err := json.Unmarshal(body, &openRouterResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	// 记录模型的上下文长度和输入模态，供上下文窗口管理和图片附件检查使用
+	for _, model := range openRouterResp.Data {
+		RegisterModelContextLength(model.ID, model.ContextLength)
+		RegisterModelModality(model.ID, model.Architecture.Modality)
+	}
+
+	return openRouterResp.Data, nil
+}
+
+// SyncOpenRouterPrices 根据 OpenRouter 的定价写入价格表，返回新增的价格记录数
+// 已有手动定价的模型不会被覆盖；OpenRouter 价格变化时新增一条当天生效的记录，保留历史价格
+func (ps *PricingService) SyncOpenRouterPrices(openRouterModels []OpenRouterModel) (int, error) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	var existing []models.ModelPrice
+	if err := database.DB.Order("effective_date ASC, id ASC").Find(&existing).Error; err != nil {
+		return 0, err
+	}
+
+	// 每个模型名称最新的一条价格
+	latest := make(map[string]models.ModelPrice)
+	manual := make(map[string]bool)
+	for _, price := range existing {
+		latest[price.ModelPattern] = price
+		if price.Source != PriceSourceOpenRouter {
+			manual[price.ModelPattern] = true
+		}
+	}
+
+	today := truncateToDate(time.Now())
+	created := 0
+	for _, model := range openRouterModels {
+		inputPrice, ok1 := parsePerTokenPrice(model.Pricing.Prompt)
+		outputPrice, ok2 := parsePerTokenPrice(model.Pricing.Completion)
+		if model.ID == "" || !ok1 || !ok2 || manual[model.ID] {
+			continue
+		}
+		cachedPrice, ok := parsePerTokenPrice(model.Pricing.InputCacheRead)
+		if !ok {
+			cachedPrice = inputPrice
+		}
+
+		if current, ok := latest[model.ID]; ok &&
+			samePrice(current.InputPrice, inputPrice) &&
+			samePrice(current.OutputPrice, outputPrice) &&
+			samePrice(current.CachedInputPrice, cachedPrice) {
+			continue
+		}
+
+		price := models.ModelPrice{
+			ModelPattern:     model.ID,
+			InputPrice:       inputPrice,
+			OutputPrice:      outputPrice,
+			CachedInputPrice: cachedPrice,
+			Currency:         defaultCurrency,
+			EffectiveDate:    today,
+			Source:           PriceSourceOpenRouter,
+		}
+		if current, ok := latest[model.ID]; ok && !current.EffectiveDate.Before(today) {
+			// 同一天内价格再次变化，直接更新当天的记录
+			price.ID = current.ID
+			price.CreatedAt = current.CreatedAt
+		}
+		if err := database.DB.Save(&price).Error; err != nil {
+			return created, err
+		}
+		latest[model.ID] = price
+		created++
+	}
+
+	return created, nil
+}
+
+// FindPrice 查找模型当前生效的价格，未配置时返回 nil
+// 匹配规则：精确匹配优先于通配符匹配，通配符中非 * 字符越多越优先；同一名称取生效日期最新的记录
+func (ps *PricingService) FindPrice(modelName string, at time.Time) *models.ModelPrice {
+	if modelName == "" {
+		return nil
+	}
+
+	// 只查询可能匹配的记录：同名、通配符，以及模型名称不带服务商前缀时的 <服务商>/<模型>（表使用不区分大小写的排序规则）
+	// LIKE 中的 _ 会匹配任意字符，多查出的记录由 matchPricePattern 排除
+	condition := "model_pattern = ? OR model_pattern LIKE ?"
+	args := []interface{}{modelName, "%*%"}
+	if !strings.Contains(modelName, "/") {
+		condition += " OR model_pattern LIKE ?"
+		args = append(args, "%/"+modelName)
+	}
+
+	var prices []models.ModelPrice
+	if err := database.DB.Where("effective_date <= ?", truncateToDate(at)).Where(condition, args...).
+		Order("effective_date DESC, id DESC").Find(&prices).Error; err != nil {
+		return nil
+	}
+
+	var best *models.ModelPrice
+	bestScore := -1
+	for i := range prices {
+		score := matchPricePattern(prices[i].ModelPattern, modelName)
+		// 按生效日期倒序，同分时保留先遇到的（最新的）记录
+		if score > bestScore {
+			best = &prices[i]
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// CalculateCost 计算一次调用的成本，cachedTokens 为输入中命中缓存的部分
+func (ps *PricingService) CalculateCost(modelName string, inputTokens, outputTokens, cachedTokens int) models.MessageCost {
+	cost := models.MessageCost{
+		Model:        modelName,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Currency:     defaultCurrency,
+	}
+
+	inputPrice, outputPrice, cachedPrice := defaultInputPrice, defaultOutputPrice, defaultInputPrice
+	if price := ps.FindPrice(modelName, time.Now()); price != nil {
+		inputPrice, outputPrice, cachedPrice = price.InputPrice, price.OutputPrice, price.CachedInputPrice
+		if price.Currency != "" {
+			cost.Currency = price.Currency
+		}
+		priceID := price.ID
+		cost.PriceID = &priceID
+	}
+
+	if cachedTokens > inputTokens {
+		cachedTokens = inputTokens
+	}
+	cost.InputCost = (float64(inputTokens-cachedTokens)*inputPrice + float64(cachedTokens)*cachedPrice) / 1e6
+	cost.OutputCost = float64(outputTokens) * outputPrice / 1e6
+	cost.TotalCost = cost.InputCost + cost.OutputCost
+
+	return cost
+}
+
+// matchPricePattern 计算价格名称与模型名称的匹配程度，不匹配返回 -1
+// 模型名称不带服务商前缀时（例如 gpt-4o），也会匹配 openai/gpt-4o 这类 OpenRouter 名称
+func matchPricePattern(pattern, modelName string) int {
+	pattern = strings.ToLower(pattern)
+	modelName = strings.ToLower(modelName)
+
+	literal := len(strings.ReplaceAll(pattern, "*", ""))
+	if pattern == modelName {
+		return literal*2 + 1
+	}
+	if wildcardMatch(pattern, modelName) {
+		return literal * 2
+	}
+
+	if !strings.Contains(modelName, "/") {
+		if idx := strings.Index(pattern, "/"); idx >= 0 {
+			stripped := pattern[idx+1:]
+			literal = len(strings.ReplaceAll(stripped, "*", ""))
+			if stripped == modelName {
+				return literal*2 + 1
+			}
+			if wildcardMatch(stripped, modelName) {
+				return literal * 2
+			}
+		}
+	}
+
+	return -1
+}
+
+// wildcardMatch 支持 * 通配符的匹配
+func wildcardMatch(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(name, part)
+		if idx < 0 {
+			return false
+		}
+		name = name[idx+len(part):]
+	}
+
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
+
+// parsePerTokenPrice 将 OpenRouter 的每 Token 价格字符串转换为每百万 Token 价格
+func parsePerTokenPrice(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	// OpenRouter 对自动路由等无固定价格的模型返回 -1
+	if err != nil || price < 0 {
+		return 0, false
+	}
+	return math.Round(price*1e6*1e6) / 1e6, true
+}
+
+func samePrice(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
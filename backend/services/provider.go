@@ -0,0 +1,70 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+
+	"ai-chat-backend/models"
+)
+
+// Provider 模型服务商适配器
+// AIService 内部统一使用 OpenAI 风格的消息格式（role/content/tool_calls/tool_call_id），
+// 由适配器转换为各服务商的请求格式，并把响应解析为统一的结果
+type Provider interface {
+	// RequestURL 返回请求地址
+	RequestURL(apiConfig *models.APIConfig, modelName string, stream bool) string
+	// RequestBody 根据消息列表和工具定义构建请求体
+	RequestBody(agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) map[string]interface{}
+	// SetHeaders 设置认证等请求头
+	SetHeaders(req *http.Request, apiConfig *models.APIConfig, apiKey string)
+	// ParseResponse 解析非流式响应
+	ParseResponse(result map[string]interface{}) (*ChatResult, error)
+	// ParseStreamLine 解析流式响应的一行，done 为 true 表示流结束
+	ParseStreamLine(line string) (delta StreamDelta, done bool)
+}
+
+// ChatResult 一次非流式请求的解析结果
+type ChatResult struct {
+	Content      string
+	ToolCalls    []map[string]interface{} // OpenAI 格式的工具调用
+	InputTokens  int
+	OutputTokens int
+	FinishReason string
+}
+
+// StreamDelta 流式响应中的一个增量，Token 为 0 表示该数据块未携带使用量
+type StreamDelta struct {
+	Content      string
+	InputTokens  int
+	OutputTokens int
+}
+
+// providerFor 根据 API 类型选择服务商适配器，未知类型按 OpenAI 兼容接口处理
+func providerFor(apiType string) Provider {
+	switch strings.ToLower(apiType) {
+	case "anthropic", "claude":
+		return &anthropicProvider{}
+	case "gemini", "google":
+		return &geminiProvider{}
+	case "ollama":
+		return &ollamaProvider{}
+	default:
+		return &openAIProvider{apiType: apiType}
+	}
+}
+
+// sseData 提取 SSE 数据行的内容
+func sseData(line string) (string, bool) {
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "data:")), true
+}
+
+// intValue 读取 JSON 数字字段
+func intValue(data map[string]interface{}, key string) int {
+	if val, ok := data[key].(float64); ok {
+		return int(val)
+	}
+	return 0
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ai-chat-backend/models"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// Anthropic 要求必须指定 max_tokens，未配置时使用该默认值
+	anthropicDefaultMaxTokens = 4096
+)
+
+// anthropicProvider Anthropic Messages API（/v1/messages）
+type anthropicProvider struct{}
+
+// RequestURL 直接使用配置的地址
+func (p *anthropicProvider) RequestURL(apiConfig *models.APIConfig, modelName string, stream bool) string {
+	return apiConfig.EndpointURL
+}
+
+// RequestBody 构建 Messages API 请求体：系统提示词放在顶层 system 字段，消息内容使用 content blocks
+func (p *anthropicProvider) RequestBody(agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) map[string]interface{} {
+	system, messages := p.convertMessages(chatMessages)
+
+	maxTokens := agent.ModelParams.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	requestBody := map[string]interface{}{
+		"model":      agent.ModelName,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     stream,
+	}
+	if system != "" {
+		requestBody["system"] = system
+	}
+
+	// 添加工具定义
+	if len(tools) > 0 {
+		requestBody["tools"] = p.convertTools(tools)
+		requestBody["tool_choice"] = map[string]interface{}{"type": "auto"}
+	}
+
+	// 添加模型参数（Anthropic 不支持 frequency_penalty / presence_penalty）
+	if agent.ModelParams.Temperature > 0 {
+		requestBody["temperature"] = agent.ModelParams.Temperature
+	}
+	if agent.ModelParams.TopP > 0 {
+		requestBody["top_p"] = agent.ModelParams.TopP
+	}
+
+	return requestBody
+}
+
+// SetHeaders 设置 x-api-key 和 anthropic-version 请求头（自定义认证时由 AuthSpec 设置认证信息）
+func (p *anthropicProvider) SetHeaders(req *http.Request, apiConfig *models.APIConfig, apiKey string) {
+	if apiConfig.AuthType != models.AuthCustom {
+		req.Header.Set("x-api-key", apiKey)
+	}
+	req.Header.Set("anthropic-version", anthropicVersion)
+}
+
+// ParseResponse 解析 content blocks 中的文本和 tool_use
+func (p *anthropicProvider) ParseResponse(result map[string]interface{}) (*ChatResult, error) {
+	blocks, ok := result["content"].([]interface{})
+	if !ok {
+		return nil, errors.New("无效的响应格式")
+	}
+
+	chatResult := &ChatResult{}
+	chatResult.FinishReason, _ = result["stop_reason"].(string)
+
+	var text strings.Builder
+	for _, rawBlock := range blocks {
+		block, ok := rawBlock.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			content, _ := block["text"].(string)
+			text.WriteString(content)
+		case "tool_use":
+			// 转换为 OpenAI 格式的工具调用
+			id, _ := block["id"].(string)
+			name, _ := block["name"].(string)
+			arguments, _ := json.Marshal(block["input"])
+			chatResult.ToolCalls = append(chatResult.ToolCalls, map[string]interface{}{
+				"id":   id,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      name,
+					"arguments": string(arguments),
+				},
+			})
+		}
+	}
+	chatResult.Content = text.String()
+
+	// 提取Token使用量
+	if usage, ok := result["usage"].(map[string]interface{}); ok {
+		chatResult.InputTokens = intValue(usage, "input_tokens")
+		chatResult.OutputTokens = intValue(usage, "output_tokens")
+	}
+
+	return chatResult, nil
+}
+
+// ParseStreamLine 解析流式事件：
+// message_start 携带输入Token，content_block_delta 携带文本增量，message_delta 携带累计输出Token
+func (p *anthropicProvider) ParseStreamLine(line string) (StreamDelta, bool) {
+	data, ok := sseData(line)
+	if !ok {
+		return StreamDelta{}, false
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return StreamDelta{}, false
+	}
+
+	var delta StreamDelta
+	switch event["type"] {
+	case "message_start":
+		if message, ok := event["message"].(map[string]interface{}); ok {
+			if usage, ok := message["usage"].(map[string]interface{}); ok {
+				delta.InputTokens = intValue(usage, "input_tokens")
+				delta.OutputTokens = intValue(usage, "output_tokens")
+			}
+		}
+	case "content_block_delta":
+		if blockDelta, ok := event["delta"].(map[string]interface{}); ok && blockDelta["type"] == "text_delta" {
+			delta.Content, _ = blockDelta["text"].(string)
+		}
+	case "message_delta":
+		if usage, ok := event["usage"].(map[string]interface{}); ok {
+			delta.OutputTokens = intValue(usage, "output_tokens")
+		}
+	case "message_stop":
+		return delta, true
+	}
+
+	return delta, false
+}
+
+// convertMessages 将内部消息转换为 Anthropic 格式：
+// 系统消息合并为顶层 system，工具调用转换为 tool_use，工具结果转换为 user 角色的 tool_result，
+// 相邻的同角色消息合并为一条（Anthropic 要求 user/assistant 交替出现）
+func (p *anthropicProvider) convertMessages(chatMessages []map[string]interface{}) (string, []map[string]interface{}) {
+	var systemParts []string
+	messages := make([]map[string]interface{}, 0, len(chatMessages))
+
+	appendBlocks := func(role string, blocks []map[string]interface{}) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(messages); n > 0 && messages[n-1]["role"] == role {
+			messages[n-1]["content"] = append(messages[n-1]["content"].([]map[string]interface{}), blocks...)
+			return
+		}
+		messages = append(messages, map[string]interface{}{
+			"role":    role,
+			"content": blocks,
+		})
+	}
+
+	for _, msg := range chatMessages {
+		role, _ := msg["role"].(string)
+		content, _ := msg["content"].(string)
+
+		switch role {
+		case "system":
+			if content != "" {
+				systemParts = append(systemParts, content)
+			}
+		case "tool":
+			toolCallID, _ := msg["tool_call_id"].(string)
+			appendBlocks("user", []map[string]interface{}{{
+				"type":        "tool_result",
+				"tool_use_id": toolCallID,
+				"content":     content,
+			}})
+		case "assistant":
+			var blocks []map[string]interface{}
+			if content != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": content})
+			}
+			toolCalls, _ := msg["tool_calls"].([]map[string]interface{})
+			for _, call := range toolCalls {
+				id, _ := call["id"].(string)
+				function, _ := call["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				arguments, _ := function["arguments"].(string)
+
+				input := map[string]interface{}{}
+				if arguments != "" {
+					json.Unmarshal([]byte(arguments), &input)
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    id,
+					"name":  name,
+					"input": input,
+				})
+			}
+			appendBlocks("assistant", blocks)
+		default:
+			appendBlocks("user", p.userBlocks(msg["content"]))
+		}
+	}
+
+	return strings.Join(systemParts, "\n\n"), messages
+}
+
+// userBlocks 将用户消息内容转换为 text 和 image 内容块
+func (p *anthropicProvider) userBlocks(content interface{}) []map[string]interface{} {
+	parts := contentParts(content)
+	if parts == nil {
+		if text, _ := content.(string); text != "" {
+			return []map[string]interface{}{{"type": "text", "text": text}}
+		}
+		return nil
+	}
+
+	blocks := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		if url, ok := partImageURL(part); ok {
+			source := map[string]interface{}{"type": "url", "url": url}
+			if mimeType, data, err := parseImageDataURI(url); err == nil {
+				source = map[string]interface{}{"type": "base64", "media_type": mimeType, "data": data}
+			}
+			blocks = append(blocks, map[string]interface{}{"type": "image", "source": source})
+			continue
+		}
+		if text, _ := part["text"].(string); text != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+		}
+	}
+	return blocks
+}
+
+// convertTools 将 OpenAI 格式的工具定义转换为 Anthropic 格式
+func (p *anthropicProvider) convertTools(tools []map[string]interface{}) []map[string]interface{} {
+	converted := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		function, _ := tool["function"].(map[string]interface{})
+		if function == nil {
+			continue
+		}
+		converted = append(converted, map[string]interface{}{
+			"name":         function["name"],
+			"description":  function["description"],
+			"input_schema": function["parameters"],
+		})
+	}
+	return converted
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ai-chat-backend/models"
+)
+
+// geminiProvider Google Gemini generateContent / streamGenerateContent 接口
+type geminiProvider struct{}
+
+// RequestURL 根据模型拼接请求地址，配置的地址可以是 https://generativelanguage.googleapis.com/v1beta/models，
+// 也可以是某个模型的完整地址（模型和方法会按智能体配置替换）
+func (p *geminiProvider) RequestURL(apiConfig *models.APIConfig, modelName string, stream bool) string {
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent"
+	}
+	url := geminiMethodURL(apiConfig.EndpointURL, modelName, method)
+	if stream {
+		// 使用 SSE 格式返回流式数据
+		url += "?alt=sse"
+	}
+	return url
+}
+
+// geminiMethodURL 拼接模型方法的地址，例如 .../v1beta/models/gemini-1.5-flash:generateContent
+func geminiMethodURL(endpointURL string, modelName string, method string) string {
+	url := strings.TrimRight(endpointURL, "/")
+	if idx := strings.Index(url, "/models/"); idx >= 0 {
+		url = url[:idx+len("/models")]
+	}
+	return url + "/" + geminiModelID(modelName) + ":" + method
+}
+
+// geminiModelID 去掉 models/ 或 OpenRouter 风格的 google/ 前缀
+func geminiModelID(modelName string) string {
+	if idx := strings.LastIndex(modelName, "/"); idx >= 0 {
+		return modelName[idx+1:]
+	}
+	return modelName
+}
+
+// RequestBody 构建 generateContent 请求体：系统提示词放在 systemInstruction，消息转换为 contents/parts
+func (p *geminiProvider) RequestBody(agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) map[string]interface{} {
+	system, contents := p.convertMessages(chatMessages)
+
+	requestBody := map[string]interface{}{
+		"contents": contents,
+	}
+	if system != "" {
+		requestBody["systemInstruction"] = map[string]interface{}{
+			"parts": []map[string]interface{}{{"text": system}},
+		}
+	}
+
+	// 添加工具定义
+	if len(tools) > 0 {
+		requestBody["tools"] = []map[string]interface{}{
+			{"functionDeclarations": p.convertTools(tools)},
+		}
+	}
+
+	// 添加模型参数
+	generationConfig := map[string]interface{}{}
+	if agent.ModelParams.Temperature > 0 {
+		generationConfig["temperature"] = agent.ModelParams.Temperature
+	}
+	if agent.ModelParams.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = agent.ModelParams.MaxTokens
+	}
+	if agent.ModelParams.TopP > 0 {
+		generationConfig["topP"] = agent.ModelParams.TopP
+	}
+	if agent.ModelParams.FrequencyPenalty != 0 {
+		generationConfig["frequencyPenalty"] = agent.ModelParams.FrequencyPenalty
+	}
+	if agent.ModelParams.PresencePenalty != 0 {
+		generationConfig["presencePenalty"] = agent.ModelParams.PresencePenalty
+	}
+	if len(generationConfig) > 0 {
+		requestBody["generationConfig"] = generationConfig
+	}
+
+	return requestBody
+}
+
+// SetHeaders 使用 x-goog-api-key 请求头认证（自定义认证时由 AuthSpec 设置认证信息，无需认证时不发送）
+func (p *geminiProvider) SetHeaders(req *http.Request, apiConfig *models.APIConfig, apiKey string) {
+	if apiConfig.AuthType != models.AuthCustom && apiKey != "" {
+		req.Header.Set("x-goog-api-key", apiKey)
+	}
+}
+
+// ParseResponse 解析 candidates[0].content.parts 中的文本和 functionCall
+func (p *geminiProvider) ParseResponse(result map[string]interface{}) (*ChatResult, error) {
+	candidates, ok := result["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		// 提示词被拦截时没有 candidates，返回拦截原因
+		if feedback, ok := result["promptFeedback"].(map[string]interface{}); ok {
+			if reason, ok := feedback["blockReason"].(string); ok {
+				return nil, fmt.Errorf("请求被 Gemini 拦截: %s", reason)
+			}
+		}
+		return nil, errors.New("无效的响应格式")
+	}
+
+	candidate, ok := candidates[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("无效的响应格式")
+	}
+
+	chatResult := &ChatResult{}
+	chatResult.FinishReason, _ = candidate["finishReason"].(string)
+	chatResult.Content, chatResult.ToolCalls = p.parseParts(candidate)
+	chatResult.InputTokens, chatResult.OutputTokens = p.parseUsage(result)
+
+	return chatResult, nil
+}
+
+// ParseStreamLine 解析 SSE 数据块，每个数据块都是一个完整的 GenerateContentResponse
+// Gemini 没有结束标记，流在连接关闭时结束
+func (p *geminiProvider) ParseStreamLine(line string) (StreamDelta, bool) {
+	data, ok := sseData(line)
+	if !ok {
+		return StreamDelta{}, false
+	}
+
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return StreamDelta{}, false
+	}
+
+	var delta StreamDelta
+	if candidates, ok := chunk["candidates"].([]interface{}); ok && len(candidates) > 0 {
+		if candidate, ok := candidates[0].(map[string]interface{}); ok {
+			delta.Content, _ = p.parseParts(candidate)
+		}
+	}
+	// usageMetadata 为截至当前数据块的累计使用量
+	delta.InputTokens, delta.OutputTokens = p.parseUsage(chunk)
+
+	return delta, false
+}
+
+// parseParts 提取候选结果中的文本和工具调用（转换为 OpenAI 格式）
+func (p *geminiProvider) parseParts(candidate map[string]interface{}) (string, []map[string]interface{}) {
+	content, ok := candidate["content"].(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+	parts, _ := content["parts"].([]interface{})
+
+	var text strings.Builder
+	var toolCalls []map[string]interface{}
+	for _, rawPart := range parts {
+		part, ok := rawPart.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// 跳过思考过程
+		if thought, _ := part["thought"].(bool); thought {
+			continue
+		}
+		if partText, ok := part["text"].(string); ok {
+			text.WriteString(partText)
+		}
+		if functionCall, ok := part["functionCall"].(map[string]interface{}); ok {
+			name, _ := functionCall["name"].(string)
+			arguments, _ := json.Marshal(functionCall["args"])
+			// Gemini 的函数调用不一定带 ID，回传结果时按名称对应
+			id, _ := functionCall["id"].(string)
+			if id == "" {
+				id = fmt.Sprintf("call_%d_%s", len(toolCalls), name)
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   id,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      name,
+					"arguments": string(arguments),
+				},
+			})
+		}
+	}
+
+	return text.String(), toolCalls
+}
+
+// parseUsage 提取 usageMetadata 中的Token使用量
+func (p *geminiProvider) parseUsage(result map[string]interface{}) (int, int) {
+	usage, ok := result["usageMetadata"].(map[string]interface{})
+	if !ok {
+		return 0, 0
+	}
+	// 思考模型的思考Token单独计数，同样按输出计费
+	return intValue(usage, "promptTokenCount"), intValue(usage, "candidatesTokenCount") + intValue(usage, "thoughtsTokenCount")
+}
+
+// convertMessages 将内部消息转换为 Gemini 的 contents：
+// assistant 对应 model 角色，工具调用转换为 functionCall，工具结果转换为 functionResponse，
+// 相邻的同角色消息合并为一条
+func (p *geminiProvider) convertMessages(chatMessages []map[string]interface{}) (string, []map[string]interface{}) {
+	var systemParts []string
+	contents := make([]map[string]interface{}, 0, len(chatMessages))
+
+	appendParts := func(role string, parts []map[string]interface{}) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+			contents[n-1]["parts"] = append(contents[n-1]["parts"].([]map[string]interface{}), parts...)
+			return
+		}
+		contents = append(contents, map[string]interface{}{
+			"role":  role,
+			"parts": parts,
+		})
+	}
+
+	for _, msg := range chatMessages {
+		role, _ := msg["role"].(string)
+		content, _ := msg["content"].(string)
+
+		switch role {
+		case "system":
+			if content != "" {
+				systemParts = append(systemParts, content)
+			}
+		case "tool":
+			name, _ := msg["name"].(string)
+			appendParts("user", []map[string]interface{}{{
+				"functionResponse": map[string]interface{}{
+					"name":     name,
+					"response": map[string]interface{}{"content": content},
+				},
+			}})
+		case "assistant":
+			var parts []map[string]interface{}
+			if content != "" {
+				parts = append(parts, map[string]interface{}{"text": content})
+			}
+			toolCalls, _ := msg["tool_calls"].([]map[string]interface{})
+			for _, call := range toolCalls {
+				function, _ := call["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				arguments, _ := function["arguments"].(string)
+
+				args := map[string]interface{}{}
+				if arguments != "" {
+					json.Unmarshal([]byte(arguments), &args)
+				}
+				parts = append(parts, map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": name,
+						"args": args,
+					},
+				})
+			}
+			appendParts("model", parts)
+		default:
+			appendParts("user", p.userParts(msg["content"]))
+		}
+	}
+
+	return strings.Join(systemParts, "\n\n"), contents
+}
+
+// inlineImages Gemini 的图片需要以 inline_data 发送
+func (p *geminiProvider) inlineImages() bool {
+	return true
+}
+
+// userParts 将用户消息内容转换为 text 和 inline_data 部分
+func (p *geminiProvider) userParts(content interface{}) []map[string]interface{} {
+	parts := contentParts(content)
+	if parts == nil {
+		if text, _ := content.(string); text != "" {
+			return []map[string]interface{}{{"text": text}}
+		}
+		return nil
+	}
+
+	converted := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		if url, ok := partImageURL(part); ok {
+			if mimeType, data, err := parseImageDataURI(url); err == nil {
+				converted = append(converted, map[string]interface{}{
+					"inline_data": map[string]interface{}{"mime_type": mimeType, "data": data},
+				})
+			}
+			continue
+		}
+		if text, _ := part["text"].(string); text != "" {
+			converted = append(converted, map[string]interface{}{"text": text})
+		}
+	}
+	return converted
+}
+
+// convertTools 将 OpenAI 格式的工具定义转换为 functionDeclarations
+func (p *geminiProvider) convertTools(tools []map[string]interface{}) []map[string]interface{} {
+	declarations := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		function, _ := tool["function"].(map[string]interface{})
+		if function == nil {
+			continue
+		}
+		declarations = append(declarations, map[string]interface{}{
+			"name":        function["name"],
+			"description": function["description"],
+			"parameters":  function["parameters"],
+		})
+	}
+	return declarations
+}
+
+// EmbeddingURL 单条文本使用 embedContent，多条使用 batchEmbedContents
+func (p *geminiProvider) EmbeddingURL(apiConfig *models.APIConfig, modelName string, count int) string {
+	if count == 1 {
+		return geminiMethodURL(apiConfig.EndpointURL, modelName, "embedContent")
+	}
+	return geminiMethodURL(apiConfig.EndpointURL, modelName, "batchEmbedContents")
+}
+
+func (p *geminiProvider) EmbeddingBody(modelName string, inputs []string) map[string]interface{} {
+	model := "models/" + geminiModelID(modelName)
+	requests := make([]map[string]interface{}, len(inputs))
+	for i, input := range inputs {
+		requests[i] = map[string]interface{}{
+			"model":   model,
+			"content": map[string]interface{}{"parts": []map[string]interface{}{{"text": input}}},
+		}
+	}
+	if len(requests) == 1 {
+		return requests[0]
+	}
+	return map[string]interface{}{"requests": requests}
+}
+
+// ParseEmbeddings 解析 embedding.values 或 embeddings[].values，Gemini 不返回Token使用量
+func (p *geminiProvider) ParseEmbeddings(body []byte) ([]models.Vector, int, error) {
+	type contentEmbedding struct {
+		Values []float32 `json:"values"`
+	}
+	var result struct {
+		Embedding  *contentEmbedding  `json:"embedding"`
+		Embeddings []contentEmbedding `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, 0, fmt.Errorf("无效的 embeddings 响应: %w", err)
+	}
+	if result.Embedding != nil {
+		result.Embeddings = append(result.Embeddings, *result.Embedding)
+	}
+
+	vectors := make([]models.Vector, len(result.Embeddings))
+	for i, embedding := range result.Embeddings {
+		vectors[i] = embedding.Values
+	}
+	return vectors, 0, nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-chat-backend/models"
+	"ai-chat-backend/utils"
+)
+
+// ollamaProvider Ollama 原生接口（/api/chat，流式响应为 NDJSON）
+type ollamaProvider struct{}
+
+// OllamaModel Ollama 本地模型信息（/api/tags）
+type OllamaModel struct {
+	Name       string    `json:"name"`
+	Model      string    `json:"model"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Size       int64     `json:"size"`
+	Details    struct {
+		Family            string `json:"family"`
+		ParameterSize     string `json:"parameter_size"`
+		QuantizationLevel string `json:"quantization_level"`
+	} `json:"details"`
+}
+
+// ollamaBaseURL 从配置的地址中取出服务根地址，例如 http://localhost:11434/api/chat -> http://localhost:11434
+func ollamaBaseURL(endpointURL string) string {
+	url := strings.TrimRight(endpointURL, "/")
+	if idx := strings.Index(url, "/api/"); idx >= 0 {
+		url = url[:idx]
+	}
+	return strings.TrimSuffix(url, "/api")
+}
+
+// RequestURL 配置的地址可以是服务根地址，也可以是完整的 /api/chat 地址
+func (p *ollamaProvider) RequestURL(apiConfig *models.APIConfig, modelName string, stream bool) string {
+	return ollamaBaseURL(apiConfig.EndpointURL) + "/api/chat"
+}
+
+// RequestBody 构建 /api/chat 请求体，模型参数放在 options 中
+func (p *ollamaProvider) RequestBody(agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) map[string]interface{} {
+	requestBody := map[string]interface{}{
+		"model":    agent.ModelName,
+		"messages": p.convertMessages(chatMessages),
+		"stream":   stream,
+	}
+
+	// 添加工具定义（与 OpenAI 格式相同）
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+
+	// 添加模型参数
+	options := map[string]interface{}{}
+	if agent.ModelParams.Temperature > 0 {
+		options["temperature"] = agent.ModelParams.Temperature
+	}
+	if agent.ModelParams.MaxTokens > 0 {
+		options["num_predict"] = agent.ModelParams.MaxTokens
+	}
+	if agent.ModelParams.TopP > 0 {
+		options["top_p"] = agent.ModelParams.TopP
+	}
+	if agent.ModelParams.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = agent.ModelParams.FrequencyPenalty
+	}
+	if agent.ModelParams.PresencePenalty != 0 {
+		options["presence_penalty"] = agent.ModelParams.PresencePenalty
+	}
+	if len(options) > 0 {
+		requestBody["options"] = options
+	}
+
+	return requestBody
+}
+
+// SetHeaders 本地服务通常无需认证；部署在反向代理后面时按认证方式设置请求头
+func (p *ollamaProvider) SetHeaders(req *http.Request, apiConfig *models.APIConfig, apiKey string) {
+	if apiKey == "" {
+		return
+	}
+	(&openAIProvider{}).SetHeaders(req, apiConfig, apiKey)
+}
+
+// ParseResponse 解析 message 中的内容和工具调用
+func (p *ollamaProvider) ParseResponse(result map[string]interface{}) (*ChatResult, error) {
+	if errMsg, ok := result["error"].(string); ok {
+		return nil, errors.New(errMsg)
+	}
+
+	message, ok := result["message"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("无效的响应格式")
+	}
+
+	chatResult := &ChatResult{}
+	chatResult.Content, _ = message["content"].(string)
+	chatResult.FinishReason, _ = result["done_reason"].(string)
+	chatResult.InputTokens = intValue(result, "prompt_eval_count")
+	chatResult.OutputTokens = intValue(result, "eval_count")
+
+	// Ollama 的工具调用没有 ID，参数为对象，转换为 OpenAI 格式
+	rawCalls, _ := message["tool_calls"].([]interface{})
+	for i, rawCall := range rawCalls {
+		call, ok := rawCall.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		function, _ := call["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		arguments, _ := json.Marshal(function["arguments"])
+		chatResult.ToolCalls = append(chatResult.ToolCalls, map[string]interface{}{
+			"id":   fmt.Sprintf("call_%d_%s", i, name),
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      name,
+				"arguments": string(arguments),
+			},
+		})
+	}
+
+	return chatResult, nil
+}
+
+// ParseStreamLine 解析 NDJSON 的一行，最后一行 done 为 true 并携带Token使用量
+func (p *ollamaProvider) ParseStreamLine(line string) (StreamDelta, bool) {
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+		return StreamDelta{}, false
+	}
+
+	var delta StreamDelta
+	if message, ok := chunk["message"].(map[string]interface{}); ok {
+		delta.Content, _ = message["content"].(string)
+	}
+
+	done, _ := chunk["done"].(bool)
+	if done {
+		delta.InputTokens = intValue(chunk, "prompt_eval_count")
+		delta.OutputTokens = intValue(chunk, "eval_count")
+	}
+
+	return delta, done
+}
+
+// convertMessages Ollama 的消息格式与 OpenAI 基本一致，但工具调用参数需要是对象而不是 JSON 字符串，
+// 图片以 base64 放在消息的 images 字段中
+func (p *ollamaProvider) convertMessages(chatMessages []map[string]interface{}) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(chatMessages))
+	for _, msg := range chatMessages {
+		if parts := contentParts(msg["content"]); parts != nil {
+			var images []string
+			for _, part := range parts {
+				if url, ok := partImageURL(part); ok {
+					if _, data, err := parseImageDataURI(url); err == nil {
+						images = append(images, data)
+					}
+				}
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    msg["role"],
+				"content": contentText(msg["content"]),
+				"images":  images,
+			})
+			continue
+		}
+
+		toolCalls, ok := msg["tool_calls"].([]map[string]interface{})
+		if !ok {
+			messages = append(messages, msg)
+			continue
+		}
+
+		converted := make([]map[string]interface{}, 0, len(toolCalls))
+		for _, call := range toolCalls {
+			function, _ := call["function"].(map[string]interface{})
+			arguments, _ := function["arguments"].(string)
+			args := map[string]interface{}{}
+			if arguments != "" {
+				json.Unmarshal([]byte(arguments), &args)
+			}
+			converted = append(converted, map[string]interface{}{
+				"function": map[string]interface{}{
+					"name":      function["name"],
+					"arguments": args,
+				},
+			})
+		}
+
+		messages = append(messages, map[string]interface{}{
+			"role":       msg["role"],
+			"content":    msg["content"],
+			"tool_calls": converted,
+		})
+	}
+	return messages
+}
+
+// inlineImages Ollama 的图片需要以 base64 发送
+func (p *ollamaProvider) inlineImages() bool {
+	return true
+}
+
+// ListOllamaModels 通过 /api/tags 获取 Ollama 服务上已下载的模型
+func ListOllamaModels(apiConfig *models.APIConfig) ([]OllamaModel, error) {
+	req, err := http.NewRequest("GET", ollamaBaseURL(apiConfig.EndpointURL)+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, err := utils.DecryptCredential(apiConfig.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("读取 API 密钥失败: %w", err)
+	}
+	(&ollamaProvider{}).SetHeaders(req, apiConfig, apiKey)
+	if apiConfig.AuthType == models.AuthCustom {
+		if err := applyCustomAuth(req, apiConfig.AuthSpec, apiKey, nil); err != nil {
+			return nil, fmt.Errorf("自定义认证配置错误: %w", err)
+		}
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("连接 Ollama 服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取模型列表失败 (状态码: %d): %s", resp.StatusCode, string(body))
+	}
+
+	var tagsResp struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.Unmarshal(body, &tagsResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	return tagsResp.Models, nil
+}
+
+// EmbeddingURL Ollama 的 /api/embed 接口（一次请求可包含多条文本）
+func (p *ollamaProvider) EmbeddingURL(apiConfig *models.APIConfig, modelName string, count int) string {
+	return ollamaBaseURL(apiConfig.EndpointURL) + "/api/embed"
+}
+
+func (p *ollamaProvider) EmbeddingBody(modelName string, inputs []string) map[string]interface{} {
+	return map[string]interface{}{
+		"model": modelName,
+		"input": inputs,
+	}
+}
+
+// ParseEmbeddings 解析 embeddings 和 prompt_eval_count
+func (p *ollamaProvider) ParseEmbeddings(body []byte) ([]models.Vector, int, error) {
+	var result struct {
+		Embeddings      []models.Vector `json:"embeddings"`
+		PromptEvalCount int             `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, 0, fmt.Errorf("无效的 embeddings 响应: %w", err)
+	}
+	return result.Embeddings, result.PromptEvalCount, nil
+}
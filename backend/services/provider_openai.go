@@ -0,0 +1,196 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ai-chat-backend/models"
+)
+
+// openAIProvider OpenAI chat/completions 兼容接口（OpenAI、OpenRouter 及大部分自定义服务）
+type openAIProvider struct {
+	apiType string
+}
+
+// RequestURL 直接使用配置的地址
+func (p *openAIProvider) RequestURL(apiConfig *models.APIConfig, modelName string, stream bool) string {
+	return apiConfig.EndpointURL
+}
+
+// RequestBody 构建 chat/completions 请求体
+func (p *openAIProvider) RequestBody(agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) map[string]interface{} {
+	requestBody := map[string]interface{}{
+		"model":    agent.ModelName,
+		"messages": chatMessages,
+		"stream":   stream,
+	}
+
+	// 流式请求时要求服务商在最后返回使用量
+	if stream && supportsStreamUsage(p.apiType) {
+		requestBody["stream_options"] = map[string]interface{}{
+			"include_usage": true,
+		}
+	}
+
+	// 添加工具定义
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+		requestBody["tool_choice"] = "auto"
+	}
+
+	// 添加模型参数
+	if agent.ModelParams.Temperature > 0 {
+		requestBody["temperature"] = agent.ModelParams.Temperature
+	}
+	if agent.ModelParams.MaxTokens > 0 {
+		requestBody["max_tokens"] = agent.ModelParams.MaxTokens
+	}
+	if agent.ModelParams.TopP > 0 {
+		requestBody["top_p"] = agent.ModelParams.TopP
+	}
+	if agent.ModelParams.FrequencyPenalty != 0 {
+		requestBody["frequency_penalty"] = agent.ModelParams.FrequencyPenalty
+	}
+	if agent.ModelParams.PresencePenalty != 0 {
+		requestBody["presence_penalty"] = agent.ModelParams.PresencePenalty
+	}
+
+	return requestBody
+}
+
+// SetHeaders 根据认证方式设置请求头
+func (p *openAIProvider) SetHeaders(req *http.Request, apiConfig *models.APIConfig, apiKey string) {
+	switch apiConfig.AuthType {
+	case models.AuthAPIKey:
+		req.Header.Set("X-API-Key", apiKey)
+	case models.AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+// ParseResponse 解析 choices[0].message
+func (p *openAIProvider) ParseResponse(result map[string]interface{}) (*ChatResult, error) {
+	choices, ok := result["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil, errors.New("无效的响应格式")
+	}
+
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("无效的响应格式")
+	}
+	message, ok := choice["message"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("无效的消息格式")
+	}
+
+	chatResult := &ChatResult{}
+	chatResult.FinishReason, _ = choice["finish_reason"].(string)
+
+	// 提取工具调用
+	if rawCalls, ok := message["tool_calls"].([]interface{}); ok {
+		for _, rawCall := range rawCalls {
+			if call, ok := rawCall.(map[string]interface{}); ok {
+				chatResult.ToolCalls = append(chatResult.ToolCalls, call)
+			}
+		}
+	}
+
+	// 提取内容（只有工具调用时 content 可能为 null）
+	content, ok := message["content"].(string)
+	if !ok && len(chatResult.ToolCalls) == 0 {
+		return nil, errors.New("无法提取内容")
+	}
+	chatResult.Content = content
+
+	// 提取Token使用量
+	if usage, ok := result["usage"].(map[string]interface{}); ok {
+		chatResult.InputTokens = intValue(usage, "prompt_tokens")
+		chatResult.OutputTokens = intValue(usage, "completion_tokens")
+	}
+
+	return chatResult, nil
+}
+
+// ParseStreamLine 解析 SSE 数据块中的 choices[0].delta.content 和 usage
+func (p *openAIProvider) ParseStreamLine(line string) (StreamDelta, bool) {
+	data, ok := sseData(line)
+	if !ok {
+		return StreamDelta{}, false
+	}
+	if data == "[DONE]" {
+		return StreamDelta{}, true
+	}
+
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return StreamDelta{}, false
+	}
+
+	var delta StreamDelta
+	if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]interface{}); ok {
+			if choiceDelta, ok := choice["delta"].(map[string]interface{}); ok {
+				delta.Content, _ = choiceDelta["content"].(string)
+			}
+		}
+	}
+
+	// 开启 include_usage 后，最后一个数据块携带整次请求的使用量
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		delta.InputTokens = intValue(usage, "prompt_tokens")
+		delta.OutputTokens = intValue(usage, "completion_tokens")
+	}
+
+	return delta, false
+}
+
+// supportsStreamUsage 判断服务商是否支持 stream_options.include_usage
+func supportsStreamUsage(apiType string) bool {
+	switch strings.ToLower(apiType) {
+	case "openai", "openrouter":
+		return true
+	default:
+		return false
+	}
+}
+
+// EmbeddingURL 由配置的 chat/completions 地址推导 embeddings 地址
+func (p *openAIProvider) EmbeddingURL(apiConfig *models.APIConfig, modelName string, count int) string {
+	return embeddingsURL(apiConfig.EndpointURL)
+}
+
+func (p *openAIProvider) EmbeddingBody(modelName string, inputs []string) map[string]interface{} {
+	return map[string]interface{}{
+		"model": modelName,
+		"input": inputs,
+	}
+}
+
+// ParseEmbeddings 解析 data[].embedding（按 index 排序）和 usage.prompt_tokens
+func (p *openAIProvider) ParseEmbeddings(body []byte) ([]models.Vector, int, error) {
+	var result struct {
+		Data []struct {
+			Index     int           `json:"index"`
+			Embedding models.Vector `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, 0, fmt.Errorf("无效的 embeddings 响应: %w", err)
+	}
+
+	vectors := make([]models.Vector, len(result.Data))
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, 0, errors.New("无效的 embeddings 响应")
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, result.Usage.PromptTokens, nil
+}
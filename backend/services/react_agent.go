@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ai-chat-backend/models"
+)
+
+const (
+	DefaultReActMaxIterations = 6
+	MaxReActIterations        = 15
+	DefaultReActTokenBudget   = 20000
+
+	// reactObservationLimit 单次观察结果写入提示词的最大长度（字符）
+	reactObservationLimit = 4000
+)
+
+// ReAct 循环的结束原因
+const (
+	ReActStopFinalAnswer   = "final_answer"   // 模型给出了最终答案
+	ReActStopMaxIterations = "max_iterations" // 达到推理步数上限
+	ReActStopTokenBudget   = "token_budget"   // 达到Token预算
+)
+
+// ReActConfig ReAct 循环的限制，来自模板智能体工作流定义中 type 为 react 的节点配置
+type ReActConfig struct {
+	MaxIterations int `json:"max_iterations"`
+	TokenBudget   int `json:"token_budget"`
+}
+
+// ReActStep 一轮推理：思考、调用的工具及其观察结果
+type ReActStep struct {
+	Iteration    int    `json:"iteration"`
+	Thought      string `json:"thought,omitempty"`
+	Action       string `json:"action,omitempty"`
+	ActionInput  string `json:"action_input,omitempty"`
+	Observation  string `json:"observation,omitempty"`
+	Error        string `json:"error,omitempty"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+}
+
+// ReActTrace ReAct 执行轨迹，保存在AI回复的 metadata.react 中
+type ReActTrace struct {
+	Steps         []ReActStep `json:"steps"`
+	MaxIterations int         `json:"max_iterations"`
+	TokenBudget   int         `json:"token_budget"`
+	StopReason    string      `json:"stop_reason"`
+}
+
+// ReActRunner 以文本协议（Thought / Action / Action Input / Observation / Final Answer）执行 ReAct 循环，
+// 不依赖服务商的函数调用接口，适用于所有模型
+type ReActRunner struct {
+	aiService *AIService
+
+	mu    sync.Mutex
+	trace *ReActTrace
+}
+
+func NewReActRunner(aiService *AIService) *ReActRunner {
+	return &ReActRunner{aiService: aiService}
+}
+
+// Trace 返回最近一次执行的轨迹，未执行时为 nil
+func (r *ReActRunner) Trace() *ReActTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.trace == nil {
+		return nil
+	}
+	trace := *r.trace
+	trace.Steps = append([]ReActStep(nil), r.trace.Steps...)
+	return &trace
+}
+
+func (r *ReActRunner) setStopReason(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trace.StopReason = reason
+}
+
+func (r *ReActRunner) addStep(step ReActStep) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trace.Steps = append(r.trace.Steps, step)
+}
+
+// reactConfigFor 读取智能体工作流定义中 react 节点的配置，缺省或越界时使用默认值
+func reactConfigFor(definition models.EinoWorkflowDefinition) ReActConfig {
+	config := ReActConfig{MaxIterations: DefaultReActMaxIterations, TokenBudget: DefaultReActTokenBudget}
+	for _, node := range definition.Nodes {
+		if configString(node.Config, "type") != "react" {
+			continue
+		}
+		if maxIterations, ok := configFloat(node.Config, "max_iterations"); ok && maxIterations >= 1 {
+			config.MaxIterations = int(maxIterations)
+		}
+		if tokenBudget, ok := configFloat(node.Config, "token_budget"); ok && tokenBudget > 0 {
+			config.TokenBudget = int(tokenBudget)
+		}
+	}
+	if config.MaxIterations > MaxReActIterations {
+		config.MaxIterations = MaxReActIterations
+	}
+	return config
+}
+
+// Run 执行 ReAct 循环，每完成一步发送 react_step 事件，最终答案以 content 事件发送（emit 可以为 nil）
+// Token 预算在每次请求模型前检查，超出预算或步数上限后再请求一次，要求模型根据已有观察直接给出最终答案
+func (r *ReActRunner) Run(ctx context.Context, agent models.Agent, messages []models.Message, emit func(StreamEvent)) (string, int, int, error) {
+	if emit == nil {
+		emit = func(StreamEvent) {}
+	}
+	config := reactConfigFor(agent.WorkflowDefinition)
+
+	r.mu.Lock()
+	r.trace = &ReActTrace{MaxIterations: config.MaxIterations, TokenBudget: config.TokenBudget}
+	r.mu.Unlock()
+
+	tools := r.tools(agent)
+	agent.SystemPrompt = reactSystemPrompt(agent.SystemPrompt, tools)
+	chatMessages := r.aiService.buildChatMessages(agent, messages)
+
+	totalInputTokens, totalOutputTokens := 0, 0
+	stopReason := ReActStopMaxIterations
+	for iteration := 1; iteration <= config.MaxIterations; iteration++ {
+		if totalInputTokens+totalOutputTokens >= config.TokenBudget {
+			stopReason = ReActStopTokenBudget
+			break
+		}
+
+		result, inputTokens, outputTokens, err := r.aiService.complete(ctx, agent, chatMessages)
+		if err != nil {
+			return "", totalInputTokens, totalOutputTokens, err
+		}
+		totalInputTokens += inputTokens
+		totalOutputTokens += outputTokens
+
+		parsed := parseReActOutput(result.Content)
+		step := ReActStep{
+			Iteration:    iteration,
+			Thought:      parsed.Thought,
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+		}
+
+		// 没有调用工具：给出最终答案（未按格式输出时把整段回复当作答案）
+		if parsed.Action == "" {
+			r.addStep(step)
+			emit(StreamEvent{Type: StreamEventReActStep, Step: &step})
+			answer := parsed.FinalAnswer
+			if answer == "" {
+				answer = strings.TrimSpace(result.Content)
+			}
+			r.setStopReason(ReActStopFinalAnswer)
+			emit(StreamEvent{Type: StreamEventContent, Content: answer})
+			return answer, totalInputTokens, totalOutputTokens, nil
+		}
+
+		step.Action = parsed.Action
+		step.ActionInput = parsed.ActionInput
+		observation, err := r.act(ctx, tools, parsed.Action, parsed.ActionInput)
+		if err != nil {
+			step.Error = err.Error()
+			observation = "工具执行失败: " + err.Error()
+		}
+		step.Observation = truncateRunes(observation, reactObservationLimit)
+		r.addStep(step)
+		emit(StreamEvent{Type: StreamEventReActStep, Step: &step})
+
+		chatMessages = append(chatMessages,
+			map[string]interface{}{"role": "assistant", "content": parsed.Text},
+			map[string]interface{}{"role": "user", "content": "Observation: " + step.Observation},
+		)
+	}
+
+	// 达到限制：不再调用工具，要求模型直接给出最终答案
+	r.setStopReason(stopReason)
+	chatMessages = append(chatMessages, map[string]interface{}{
+		"role":    "user",
+		"content": "已达到推理步数或Token预算上限，请不要再调用工具，根据已有的观察结果直接给出 Final Answer。",
+	})
+	result, inputTokens, outputTokens, err := r.aiService.complete(ctx, agent, chatMessages)
+	if err != nil {
+		return "", totalInputTokens, totalOutputTokens, err
+	}
+	totalInputTokens += inputTokens
+	totalOutputTokens += outputTokens
+
+	answer := parseReActOutput(result.Content).FinalAnswer
+	if answer == "" {
+		answer = strings.TrimSpace(result.Content)
+	}
+	emit(StreamEvent{Type: StreamEventContent, Content: answer})
+	return answer, totalInputTokens, totalOutputTokens, nil
+}
+
+// tools 智能体可以调用的工具（未注册的工具名称会被忽略）
+func (r *ReActRunner) tools(agent models.Agent) []Tool {
+	tools := make([]Tool, 0, len(agent.Tools))
+	for _, name := range agent.Tools {
+		if tool, ok := r.aiService.tools.Get(name); ok {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// act 执行模型选择的工具，返回观察结果
+func (r *ReActRunner) act(ctx context.Context, tools []Tool, action string, input string) (string, error) {
+	for _, tool := range tools {
+		if tool.Name() == action {
+			return tool.Execute(ctx, reactToolArguments(tool, input))
+		}
+	}
+
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Name()
+	}
+	return "", fmt.Errorf("工具 %s 不存在，可用的工具: %s", action, strings.Join(names, ", "))
+}
+
+// reactToolArguments Action Input 不是 JSON 对象时，作为工具的第一个必填参数
+func reactToolArguments(tool Tool, input string) string {
+	var object map[string]interface{}
+	if json.Unmarshal([]byte(input), &object) == nil {
+		return input
+	}
+
+	paramName := "input"
+	if required, ok := tool.Parameters()["required"].([]string); ok && len(required) > 0 {
+		paramName = required[0]
+	}
+	arguments, _ := json.Marshal(map[string]string{paramName: input})
+	return string(arguments)
+}
+
+// reactSystemPrompt 在智能体的系统提示词后追加 ReAct 输出格式和可用工具说明
+func reactSystemPrompt(systemPrompt string, tools []Tool) string {
+	var prompt strings.Builder
+	if systemPrompt != "" {
+		prompt.WriteString(systemPrompt)
+		prompt.WriteString("\n\n")
+	}
+
+	prompt.WriteString("请通过逐步推理解决问题。每一步严格按以下格式输出，然后停止，等待工具返回 Observation：\n\n")
+	prompt.WriteString("Thought: 分析当前情况，决定下一步做什么\n")
+	prompt.WriteString("Action: 要调用的工具名称\n")
+	prompt.WriteString("Action Input: 工具参数（JSON 对象）\n\n")
+	prompt.WriteString("得到足够的信息后输出：\n\n")
+	prompt.WriteString("Thought: 总结推理过程\n")
+	prompt.WriteString("Final Answer: 给用户的最终答案\n\n")
+	prompt.WriteString("不要自己编写 Observation。\n\n")
+
+	if len(tools) == 0 {
+		prompt.WriteString("当前没有可用的工具，请直接推理并给出 Final Answer。")
+		return prompt.String()
+	}
+	prompt.WriteString("可用的工具：")
+	for _, tool := range tools {
+		parameters, _ := json.Marshal(tool.Parameters())
+		fmt.Fprintf(&prompt, "\n- %s: %s\n  参数: %s", tool.Name(), tool.Description(), parameters)
+	}
+	return prompt.String()
+}
+
+// reactOutput 模型一轮输出的解析结果
+type reactOutput struct {
+	Text        string // 截断到模型自行编写的 Observation 之前的原文
+	Thought     string
+	Action      string
+	ActionInput string
+	FinalAnswer string
+}
+
+var (
+	reactLabelPattern       = regexp.MustCompile(`(?mi)^[ \t*]*(Thought|Action Input|Action|Final Answer|Observation)[ \t*]*[:：]`)
+	reactCodeFencePattern   = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+	reactActionNameReplacer = strings.NewReplacer("`", "", "*", "", "\"", "")
+)
+
+// parseReActOutput 按标签切分模型输出；Action 出现在 Final Answer 之前时执行 Action，忽略之后的内容
+func parseReActOutput(content string) reactOutput {
+	output := reactOutput{Text: strings.TrimSpace(content)}
+	matches := reactLabelPattern.FindAllStringSubmatchIndex(content, -1)
+
+	for i, match := range matches {
+		label := strings.ToLower(content[match[2]:match[3]])
+		end := len(content)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		value := strings.TrimSpace(content[match[1]:end])
+
+		switch label {
+		case "thought":
+			if output.Thought == "" {
+				output.Thought = value
+			}
+		case "action":
+			if output.Action == "" && output.FinalAnswer == "" {
+				output.Action = strings.TrimSpace(reactActionNameReplacer.Replace(value))
+			}
+		case "action input":
+			if output.Action != "" && output.ActionInput == "" {
+				output.ActionInput = reactCodeFencePattern.ReplaceAllString(value, "$1")
+			}
+		case "final answer":
+			if output.Action != "" {
+				// 已经选择了工具，之后的内容需要等工具返回后再生成
+				output.Text = strings.TrimSpace(content[:match[0]])
+				return output
+			}
+			if output.FinalAnswer == "" {
+				output.FinalAnswer = value
+			}
+		case "observation":
+			// 模型自行编写了 Observation，丢弃之后的内容
+			output.Text = strings.TrimSpace(content[:match[0]])
+			return output
+		}
+	}
+	return output
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"ai-chat-backend/models"
+)
+
+// 请求映射（FieldMapping.RequestMapping）的键为请求体中的字段路径（用 . 分隔嵌套字段），值为映射规则：
+//
+//	"max_tokens": "generation_config.max_output_tokens"          移动字段（兼容原来的重命名写法）
+//	"temperature": {"target": "params.temp", "scale": 100, "cast": "int"}  移动并转换
+//	"stream": {"drop": true}                                     删除字段
+//	"safety.level": {"constant": "strict"}                       注入常量
+//	"$template": "{\"input\": {{json .Messages}}}"               用 Go 模板生成完整请求体
+//
+// 配置了 $template 时先渲染模板得到请求体，再应用其余字段规则
+const requestMappingTemplateKey = "$template"
+
+// requestFieldRule 单个字段的映射规则
+type requestFieldRule struct {
+	Source   string
+	Target   string
+	Scale    float64
+	Cast     string
+	Drop     bool
+	Constant interface{}
+	HasConst bool
+}
+
+// requestTemplateData 请求体模板可以使用的数据
+type requestTemplateData struct {
+	Model    string
+	Messages []map[string]interface{}
+	Tools    []map[string]interface{}
+	Params   models.ModelParams
+	Stream   bool
+	Body     map[string]interface{} // 服务商适配器生成的默认请求体
+}
+
+// requestTemplateFuncs 请求体模板的辅助函数
+var requestTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"last": func(items []map[string]interface{}) map[string]interface{} {
+		if len(items) == 0 {
+			return nil
+		}
+		return items[len(items)-1]
+	},
+}
+
+// applyRequestMapping 按请求映射改写请求体
+func applyRequestMapping(body map[string]interface{}, mapping map[string]interface{}, agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) (map[string]interface{}, error) {
+	if tmpl, ok := mapping[requestMappingTemplateKey].(string); ok && tmpl != "" {
+		rendered, err := renderRequestTemplate(tmpl, requestTemplateData{
+			Model:    agent.ModelName,
+			Messages: chatMessages,
+			Tools:    tools,
+			Params:   agent.ModelParams,
+			Stream:   stream,
+			Body:     body,
+		})
+		if err != nil {
+			return nil, err
+		}
+		body = rendered
+	}
+
+	rules, err := parseRequestFieldRules(mapping)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return body, nil
+	}
+
+	// 先按原请求体取出所有源字段的值，再统一写入，避免规则之间互相影响
+	values := make([]interface{}, len(rules))
+	found := make([]bool, len(rules))
+	for i, rule := range rules {
+		if rule.HasConst {
+			values[i], found[i] = rule.Constant, true
+			continue
+		}
+		values[i], found[i] = getBodyPath(body, rule.Source)
+	}
+	for _, rule := range rules {
+		if !rule.HasConst && (rule.Drop || rule.Target != rule.Source) {
+			deleteBodyPath(body, rule.Source)
+		}
+	}
+
+	for i, rule := range rules {
+		if rule.Drop || !found[i] {
+			continue
+		}
+		value, err := transformRequestValue(values[i], rule)
+		if err != nil {
+			return nil, fmt.Errorf("请求映射 %s: %w", rule.Source, err)
+		}
+		setBodyPath(body, rule.Target, value)
+	}
+
+	return body, nil
+}
+
+// renderRequestTemplate 渲染请求体模板，结果必须是 JSON 对象
+func renderRequestTemplate(tmpl string, data requestTemplateData) (map[string]interface{}, error) {
+	t, err := template.New("request").Funcs(requestTemplateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("请求体模板格式错误: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("渲染请求体模板失败: %w", err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &body); err != nil {
+		return nil, fmt.Errorf("请求体模板生成的不是有效的 JSON 对象: %w", err)
+	}
+	return body, nil
+}
+
+// parseRequestFieldRules 解析字段规则，按源字段排序保证结果稳定
+func parseRequestFieldRules(mapping map[string]interface{}) ([]requestFieldRule, error) {
+	var rules []requestFieldRule
+	for key, value := range mapping {
+		if key == requestMappingTemplateKey {
+			continue
+		}
+		if strings.TrimSpace(key) == "" {
+			return nil, errors.New("请求映射的字段路径不能为空")
+		}
+
+		rule := requestFieldRule{Source: key, Target: key}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				rule.Target = v
+			}
+		case map[string]interface{}:
+			if target, ok := v["target"].(string); ok && target != "" {
+				rule.Target = target
+			}
+			if scale, ok := v["scale"].(float64); ok {
+				rule.Scale = scale
+			}
+			rule.Cast, _ = v["cast"].(string)
+			rule.Drop, _ = v["drop"].(bool)
+			rule.Constant, rule.HasConst = v["constant"]
+
+			switch rule.Cast {
+			case "", "int", "float", "string", "bool":
+			default:
+				return nil, fmt.Errorf("请求映射 %s: 不支持的类型转换 %s", key, rule.Cast)
+			}
+		default:
+			return nil, fmt.Errorf("请求映射 %s 必须是字符串或对象", key)
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Source < rules[j].Source })
+	return rules, nil
+}
+
+// ValidateRequestMapping 校验请求映射的规则和模板格式
+func ValidateRequestMapping(mapping map[string]interface{}) error {
+	if value, ok := mapping[requestMappingTemplateKey]; ok {
+		tmpl, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("请求映射 %s 必须是字符串", requestMappingTemplateKey)
+		}
+		if _, err := template.New("request").Funcs(requestTemplateFuncs).Parse(tmpl); err != nil {
+			return fmt.Errorf("请求体模板格式错误: %w", err)
+		}
+	}
+	_, err := parseRequestFieldRules(mapping)
+	return err
+}
+
+// transformRequestValue 按规则缩放和转换字段值
+func transformRequestValue(value interface{}, rule requestFieldRule) (interface{}, error) {
+	if rule.Scale != 0 {
+		number, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("字段值 %v 不是数字，无法缩放", value)
+		}
+		value = number * rule.Scale
+	}
+
+	switch rule.Cast {
+	case "int":
+		if number, ok := toFloat(value); ok {
+			return int64(number), nil
+		}
+		if str, ok := value.(string); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("无法将 %q 转换为整数", str)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("无法将 %v 转换为整数", value)
+	case "float":
+		if number, ok := toFloat(value); ok {
+			return number, nil
+		}
+		if str, ok := value.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				return nil, fmt.Errorf("无法将 %q 转换为数字", str)
+			}
+			return f, nil
+		}
+		return nil, fmt.Errorf("无法将 %v 转换为数字", value)
+	case "string":
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+		if number, ok := toFloat(value); ok {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+		data, err := json.Marshal(value)
+		return string(data), err
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("无法将 %q 转换为布尔值", v)
+			}
+			return b, nil
+		}
+		if number, ok := toFloat(value); ok {
+			return number != 0, nil
+		}
+		return nil, fmt.Errorf("无法将 %v 转换为布尔值", value)
+	}
+
+	return value, nil
+}
+
+// toFloat 将各种数字类型转换为 float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// getBodyPath 读取 a.b.c 形式路径上的值
+func getBodyPath(body map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	current := body
+	for i, key := range keys {
+		value, ok := current[key]
+		if !ok {
+			return nil, false
+		}
+		if i == len(keys)-1 {
+			return value, true
+		}
+		if current, ok = value.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// setBodyPath 写入 a.b.c 形式路径上的值，中间对象不存在时自动创建
+func setBodyPath(body map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := body
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+		}
+		current[key] = next
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+// deleteBodyPath 删除 a.b.c 形式路径上的字段
+func deleteBodyPath(body map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	current := body
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, keys[len(keys)-1])
+}
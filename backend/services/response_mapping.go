@@ -0,0 +1,202 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ai-chat-backend/models"
+)
+
+// 响应映射（FieldMapping.ResponseMapping）支持的配置项，值为 JSONPath（stream_terminator 除外）
+// 配置了的项覆盖服务商适配器的默认解析，未配置的项仍由适配器解析
+const (
+	responseMappingContent            = "content"              // 回复内容，例如 $.choices[0].message.content
+	responseMappingInputTokens        = "input_tokens"         // 输入Token，例如 $.usage.prompt_tokens
+	responseMappingOutputTokens       = "output_tokens"        // 输出Token，例如 $.usage.completion_tokens
+	responseMappingFinishReason       = "finish_reason"        // 结束原因，例如 $.choices[0].finish_reason
+	responseMappingError              = "error"                // 错误信息，匹配到时视为请求失败，例如 $.error.message
+	responseMappingStreamDelta        = "stream_delta"         // 流式数据块中的内容增量，例如 $.choices[0].delta.content
+	responseMappingStreamInputTokens  = "stream_input_tokens"  // 流式数据块中的输入Token，未配置时使用 input_tokens
+	responseMappingStreamOutputTokens = "stream_output_tokens" // 流式数据块中的输出Token，未配置时使用 output_tokens
+	responseMappingStreamTerminator   = "stream_terminator"    // 流结束标记，数据行内容等于该值时结束，例如 [DONE]
+)
+
+// responseMapping 解析后的响应映射
+type responseMapping struct {
+	content            string
+	inputTokens        string
+	outputTokens       string
+	finishReason       string
+	errorMessage       string
+	streamDelta        string
+	streamInputTokens  string
+	streamOutputTokens string
+	streamTerminator   string
+}
+
+// parseResponseMapping 读取响应映射配置，未配置任何项时返回 nil
+func parseResponseMapping(mapping map[string]interface{}) *responseMapping {
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	get := func(key string) string {
+		value, _ := mapping[key].(string)
+		return strings.TrimSpace(value)
+	}
+
+	m := &responseMapping{
+		content:            get(responseMappingContent),
+		inputTokens:        get(responseMappingInputTokens),
+		outputTokens:       get(responseMappingOutputTokens),
+		finishReason:       get(responseMappingFinishReason),
+		errorMessage:       get(responseMappingError),
+		streamDelta:        get(responseMappingStreamDelta),
+		streamInputTokens:  get(responseMappingStreamInputTokens),
+		streamOutputTokens: get(responseMappingStreamOutputTokens),
+		streamTerminator:   get(responseMappingStreamTerminator),
+	}
+	if m.streamInputTokens == "" {
+		m.streamInputTokens = m.inputTokens
+	}
+	if m.streamOutputTokens == "" {
+		m.streamOutputTokens = m.outputTokens
+	}
+
+	if *m == (responseMapping{}) {
+		return nil
+	}
+	return m
+}
+
+// hasStreamMapping 是否配置了流式响应的映射
+func (m *responseMapping) hasStreamMapping() bool {
+	return m.streamDelta != "" || m.streamInputTokens != "" || m.streamOutputTokens != "" || m.streamTerminator != ""
+}
+
+// ValidateResponseMapping 校验响应映射的配置项和路径格式
+func ValidateResponseMapping(mapping map[string]interface{}) error {
+	for key, value := range mapping {
+		switch key {
+		case responseMappingContent, responseMappingInputTokens, responseMappingOutputTokens, responseMappingFinishReason,
+			responseMappingError, responseMappingStreamDelta, responseMappingStreamInputTokens, responseMappingStreamOutputTokens:
+			path, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("响应映射 %s 必须是字符串", key)
+			}
+			if _, err := parseJSONPath(path); err != nil {
+				return fmt.Errorf("响应映射 %s: %w", key, err)
+			}
+		case responseMappingStreamTerminator:
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("响应映射 %s 必须是字符串", key)
+			}
+		default:
+			return fmt.Errorf("不支持的响应映射项: %s", key)
+		}
+	}
+	return nil
+}
+
+// mappedProvider 在服务商适配器的基础上按响应映射解析响应
+type mappedProvider struct {
+	Provider
+	mapping *responseMapping
+}
+
+// providerForConfig 根据 API 配置选择适配器，配置了响应映射时包装为 mappedProvider
+func providerForConfig(apiConfig *models.APIConfig) Provider {
+	provider := providerFor(apiConfig.APIType)
+	if mapping := parseResponseMapping(apiConfig.FieldMapping.ResponseMapping); mapping != nil {
+		return &mappedProvider{Provider: provider, mapping: mapping}
+	}
+	return provider
+}
+
+// inlineImages 沿用底层适配器对图片格式的要求
+func (p *mappedProvider) inlineImages() bool {
+	inline, ok := p.Provider.(inlineImageProvider)
+	return ok && inline.inlineImages()
+}
+
+// ParseResponse 先由适配器解析，再用映射的路径覆盖对应字段
+func (p *mappedProvider) ParseResponse(result map[string]interface{}) (*ChatResult, error) {
+	m := p.mapping
+	if m.errorMessage != "" {
+		if message, ok := jsonPathString(result, m.errorMessage); ok && message != "" {
+			return nil, errors.New(message)
+		}
+	}
+
+	chatResult, err := p.Provider.ParseResponse(result)
+	if err != nil {
+		// 适配器无法识别响应格式时，只要配置了内容路径仍按映射解析
+		if m.content == "" {
+			return nil, err
+		}
+		chatResult = &ChatResult{}
+	}
+
+	if m.content != "" {
+		content, ok := jsonPathString(result, m.content)
+		if !ok && len(chatResult.ToolCalls) == 0 {
+			return nil, fmt.Errorf("响应中未找到内容字段 %s", m.content)
+		}
+		chatResult.Content = content
+	}
+	if m.inputTokens != "" {
+		chatResult.InputTokens, _ = jsonPathInt(result, m.inputTokens)
+	}
+	if m.outputTokens != "" {
+		chatResult.OutputTokens, _ = jsonPathInt(result, m.outputTokens)
+	}
+	if m.finishReason != "" {
+		chatResult.FinishReason, _ = jsonPathString(result, m.finishReason)
+	}
+
+	return chatResult, nil
+}
+
+// ParseStreamLine 配置了流式映射时按映射解析数据行（支持 SSE 的 data: 行和 NDJSON），否则交给适配器
+func (p *mappedProvider) ParseStreamLine(line string) (StreamDelta, bool) {
+	m := p.mapping
+	if !m.hasStreamMapping() {
+		return p.Provider.ParseStreamLine(line)
+	}
+
+	data := strings.TrimSpace(line)
+	if sse, ok := sseData(data); ok {
+		data = sse
+	}
+	if data == "" {
+		return StreamDelta{}, false
+	}
+	if m.streamTerminator != "" && data == m.streamTerminator {
+		return StreamDelta{}, true
+	}
+
+	// 未配置的项沿用适配器的解析结果
+	delta, done := p.Provider.ParseStreamLine(line)
+
+	var chunk interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return delta, done
+	}
+	if m.streamDelta != "" {
+		delta.Content, _ = jsonPathString(chunk, m.streamDelta)
+	}
+	if m.streamInputTokens != "" {
+		delta.InputTokens, _ = jsonPathInt(chunk, m.streamInputTokens)
+	}
+	if m.streamOutputTokens != "" {
+		delta.OutputTokens, _ = jsonPathInt(chunk, m.streamOutputTokens)
+	}
+
+	// 配置了结束标记时以结束标记为准
+	if m.streamTerminator != "" {
+		done = false
+	}
+	return delta, done
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+)
+
+const (
+	DefaultRetrievalTopK = 4
+	MaxRetrievalTopK     = 20
+
+	// citationSnippetLength 引用中保存的分段摘录长度（字符）
+	citationSnippetLength = 200
+)
+
+// Citation AI回复引用的知识库分段，保存在回复的 metadata.citations 中，Index 对应回复中的 [n] 标注
+type Citation struct {
+	Index           int     `json:"index"`
+	KnowledgeBaseID uint    `json:"knowledge_base_id"`
+	DocumentID      uint    `json:"document_id"`
+	DocumentName    string  `json:"document_name"`
+	ChunkID         uint    `json:"chunk_id"`
+	ChunkIndex      int     `json:"chunk_index"`
+	Score           float64 `json:"score"`
+	Snippet         string  `json:"snippet"`
+}
+
+// Retriever 为 rag 智能体检索知识库，并记录本次生成中注入的引用
+type Retriever struct {
+	knowledge *KnowledgeBaseService
+
+	mu        sync.Mutex
+	citations []Citation
+}
+
+func NewRetriever(aiService *AIService) *Retriever {
+	return &Retriever{knowledge: NewKnowledgeBaseService(aiService)}
+}
+
+// Citations 返回本次生成中注入的所有引用
+func (r *Retriever) Citations() []Citation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Citation(nil), r.citations...)
+}
+
+// Retrieve 在智能体所有者的知识库中检索与 query 相关的分段，返回注入提示词的参考资料（没有命中时为空）
+// 引用编号在同一次生成的多次检索之间连续递增
+func (r *Retriever) Retrieve(ctx context.Context, ownerID uint, config models.RetrievalConfig, query string) (string, error) {
+	query = strings.TrimSpace(query)
+	if len(config.KnowledgeBaseIDs) == 0 || query == "" {
+		return "", nil
+	}
+
+	var knowledgeBases []models.KnowledgeBase
+	if err := database.DB.Preload("APIConfig").Where("id IN ? AND user_id = ?", config.KnowledgeBaseIDs, ownerID).
+		Find(&knowledgeBases).Error; err != nil {
+		return "", err
+	}
+
+	topK := config.TopK
+	if topK <= 0 {
+		topK = DefaultRetrievalTopK
+	}
+	if topK > MaxRetrievalTopK {
+		topK = MaxRetrievalTopK
+	}
+
+	chunks, err := r.knowledge.Search(ctx, knowledgeBases, query, topK)
+	if err != nil {
+		return "", fmt.Errorf("知识库检索失败: %w", err)
+	}
+
+	var relevant []RetrievedChunk
+	for _, chunk := range chunks {
+		if chunk.Score >= config.MinScore {
+			relevant = append(relevant, chunk)
+		}
+	}
+	if len(relevant) == 0 {
+		return "", nil
+	}
+	return r.record(relevant), nil
+}
+
+// record 登记引用并格式化参考资料
+func (r *Retriever) record(chunks []RetrievedChunk) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var prompt strings.Builder
+	prompt.WriteString("以下是从知识库中检索到的参考资料。请优先依据这些资料回答，引用时在句末用 [编号] 标注来源；资料中没有相关信息时请如实说明，不要编造。\n")
+	for _, chunk := range chunks {
+		index := len(r.citations) + 1
+		r.citations = append(r.citations, Citation{
+			Index:           index,
+			KnowledgeBaseID: chunk.KnowledgeBaseID,
+			DocumentID:      chunk.DocumentID,
+			DocumentName:    chunk.DocumentName,
+			ChunkID:         chunk.ChunkID,
+			ChunkIndex:      chunk.ChunkIndex,
+			Score:           chunk.Score,
+			Snippet:         truncateRunes(chunk.Content, citationSnippetLength),
+		})
+		fmt.Fprintf(&prompt, "\n[%d] 《%s》\n%s\n", index, chunk.DocumentName, chunk.Content)
+	}
+	return prompt.String()
+}
+
+// AugmentAgent 为配置了知识库的智能体检索最后一条用户消息，并将参考资料追加到系统提示词
+func (r *Retriever) AugmentAgent(ctx context.Context, agent models.Agent, messages []models.Message) (models.Agent, error) {
+	if len(agent.Retrieval.KnowledgeBaseIDs) == 0 {
+		return agent, nil
+	}
+
+	references, err := r.Retrieve(withUsageAgent(ctx, agent.ID), agent.UserID, agent.Retrieval, lastUserContent(messages))
+	if err != nil || references == "" {
+		return agent, err
+	}
+	if agent.SystemPrompt != "" {
+		agent.SystemPrompt += "\n\n"
+	}
+	agent.SystemPrompt += references
+	return agent, nil
+}
+
+// truncateRunes 截取前 n 个字符
+func truncateRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+)
+
+// retryPolicy 请求重试策略
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// attemptLog 记录一次对话中的所有请求尝试（工具调用循环会发送多次请求）
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []models.RequestAttempt
+}
+
+func (l *attemptLog) add(attempt models.RequestAttempt) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts = append(l.attempts, attempt)
+}
+
+func (l *attemptLog) list() []models.RequestAttempt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]models.RequestAttempt(nil), l.attempts...)
+}
+
+// aiHTTPClients 所有 AIService 共用的 HTTP 客户端，共用同一个 Transport 以复用连接
+var (
+	aiHTTPClientsOnce sync.Once
+	aiHTTPClient      *http.Client
+	aiStreamClient    *http.Client
+	aiRetryPolicy     retryPolicy
+)
+
+// newAIHTTPClients 返回非流式和流式请求使用的 HTTP 客户端及重试策略，第一次调用时按配置创建
+// 流式请求不设置总超时（回复可能持续较长时间），只限制连接和等待响应头的时间
+func newAIHTTPClients() (*http.Client, *http.Client, retryPolicy) {
+	aiHTTPClientsOnce.Do(func() {
+		connectTimeout := 10 * time.Second
+		headerTimeout := 60 * time.Second
+		requestTimeout := 120 * time.Second
+		aiRetryPolicy = retryPolicy{maxRetries: 2, baseDelay: 500 * time.Millisecond, maxDelay: 10 * time.Second}
+
+		if cfg := config.AppConfig; cfg != nil {
+			connectTimeout = cfg.AIConnectTimeout
+			headerTimeout = cfg.AIResponseHeaderTimeout
+			requestTimeout = cfg.AIRequestTimeout
+			aiRetryPolicy = retryPolicy{maxRetries: cfg.AIMaxRetries, baseDelay: cfg.AIRetryBaseDelay, maxDelay: cfg.AIRetryMaxDelay}
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.ResponseHeaderTimeout = headerTimeout
+
+		aiHTTPClient = &http.Client{Transport: transport, Timeout: requestTimeout}
+		aiStreamClient = &http.Client{Transport: transport}
+	})
+	return aiHTTPClient, aiStreamClient, aiRetryPolicy
+}
+
+// Attempts 返回本服务实例发出的所有请求尝试
+func (s *AIService) Attempts() []models.RequestAttempt {
+	return s.attempts.list()
+}
+
+// ServedModel 返回最后一次成功请求使用的模型（发生故障转移时与 Agent 配置的模型不同）
+func ServedModel(attempts []models.RequestAttempt, defaultModel string) string {
+	for i := len(attempts) - 1; i >= 0; i-- {
+		if attempts[i].Success {
+			return attempts[i].ModelName
+		}
+	}
+	return defaultModel
+}
+
+// requestCandidates 返回依次尝试的配置：主配置和备用配置
+func (s *AIService) requestCandidates(agent models.Agent) []models.Agent {
+	candidates := []models.Agent{agent}
+	for _, fallback := range agent.Fallbacks {
+		candidate := agent
+		if fallback.ModelName != "" {
+			candidate.ModelName = fallback.ModelName
+		}
+		if fallback.APIConfigID != nil {
+			if database.DB == nil {
+				continue
+			}
+			var apiConfig models.APIConfig
+			if err := database.DB.Where("id = ? AND user_id = ? AND is_active = ?", *fallback.APIConfigID, agent.UserID, true).First(&apiConfig).Error; err != nil {
+				s.attempts.add(models.RequestAttempt{
+					APIConfigID: *fallback.APIConfigID,
+					ModelName:   candidate.ModelName,
+					Error:       "备用API配置不存在或未启用",
+					StartedAt:   time.Now(),
+				})
+				continue
+			}
+			candidate.APIConfig = &apiConfig
+			candidate.APIConfigID = fallback.APIConfigID
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// send 发送请求：429、5xx 和网络错误按指数退避重试，仍然失败时依次切换备用配置
+// 返回状态码为 200 的响应和实际使用的配置；ctx 取消后不再重试
+func (s *AIService) send(ctx context.Context, agent models.Agent, chatMessages []map[string]interface{}, tools []map[string]interface{}, stream bool) (*http.Response, models.Agent, error) {
+	client := s.client
+	if stream {
+		client = s.streamClient
+	}
+
+	candidates := s.requestCandidates(agent)
+	var lastErr error
+	for _, candidate := range candidates {
+		for attempt := 1; ; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return nil, agent, err
+			}
+
+			record := models.RequestAttempt{
+				ModelName: candidate.ModelName,
+				Attempt:   attempt,
+				StartedAt: time.Now(),
+			}
+			if candidate.APIConfig != nil {
+				record.APIConfigID = candidate.APIConfig.ID
+				record.APIType = candidate.APIConfig.APIType
+			}
+
+			// 每次尝试重新构建请求（请求体只能读取一次，自定义认证的时间戳和签名也需要更新）
+			req, err := s.buildChatRequest(ctx, candidate, chatMessages, tools, stream)
+			if err != nil {
+				record.Error = err.Error()
+				s.attempts.add(record)
+				lastErr = err
+				break
+			}
+
+			resp, err := client.Do(req)
+			record.LatencyMs = time.Since(record.StartedAt).Milliseconds()
+			if err == nil && resp.StatusCode == http.StatusOK {
+				record.Success = true
+				record.StatusCode = resp.StatusCode
+				s.attempts.add(record)
+				return resp, candidate, nil
+			}
+
+			var retryAfter time.Duration
+			retryable := true
+			if err != nil {
+				lastErr = err
+			} else {
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				lastErr = fmt.Errorf("API请求失败: %s", string(body))
+				record.StatusCode = resp.StatusCode
+				retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			}
+			record.Error = lastErr.Error()
+			s.attempts.add(record)
+			if ctx.Err() != nil {
+				return nil, agent, ctx.Err()
+			}
+
+			if !retryable || attempt > s.retry.maxRetries {
+				break
+			}
+
+			delay := s.retry.backoff(attempt)
+			if retryAfter > 0 {
+				// 服务端要求等待的时间过长时直接切换备用配置
+				if retryAfter > s.retry.maxDelay {
+					break
+				}
+				delay = retryAfter
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, agent, ctx.Err()
+			}
+		}
+	}
+
+	if len(candidates) > 1 {
+		return nil, agent, fmt.Errorf("主配置和 %d 个备用配置均请求失败: %w", len(candidates)-1, lastErr)
+	}
+	return nil, agent, lastErr
+}
+
+// backoff 计算第 attempt 次失败后的等待时间：指数增长，加入随机抖动避免多个请求同时重试
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay << uint(attempt-1)
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter 解析 Retry-After 响应头（秒数或 HTTP 日期）
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
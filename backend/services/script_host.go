@@ -0,0 +1,415 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// maxScriptStateSize 一个对话中脚本 KV 状态的总大小上限（键和 JSON 编码后的值，字节）
+	maxScriptStateSize = 64 << 10
+	// maxScriptValueDepth 传给宿主接口的值（工具参数、KV 值、消息列表）允许的最大嵌套层数
+	maxScriptValueDepth = 64
+)
+
+// scriptPredeclared 脚本可以使用的宿主接口，此外只有 Starlark 的内置函数
+var scriptPredeclared = starlark.StringDict{
+	"call_model": starlark.NewBuiltin("call_model", scriptCallModel),
+	"call_tool":  starlark.NewBuiltin("call_tool", scriptCallTool),
+	"list_tools": starlark.NewBuiltin("list_tools", scriptListTools),
+	"kv": &starlarkstruct.Module{
+		Name: "kv",
+		Members: starlark.StringDict{
+			"get":    starlark.NewBuiltin("kv.get", scriptKVGet),
+			"set":    starlark.NewBuiltin("kv.set", scriptKVSet),
+			"delete": starlark.NewBuiltin("kv.delete", scriptKVDelete),
+			"keys":   starlark.NewBuiltin("kv.keys", scriptKVKeys),
+		},
+	},
+	"json": starlarkjson.Module,
+	"math": starlarkmath.Module,
+}
+
+// scriptHostError 宿主接口执行失败（模型请求、工具执行、状态超限、与宿主进程通信失败等），区别于脚本自身的错误
+type scriptHostError struct {
+	function string
+	err      error
+}
+
+func (e *scriptHostError) Error() string {
+	return e.function + ": " + e.err.Error()
+}
+
+func (e *scriptHostError) Unwrap() error {
+	return e.err
+}
+
+func scriptSessionOf(thread *starlark.Thread) *scriptSession {
+	return thread.Local(scriptSessionKey).(*scriptSession)
+}
+
+// scriptMessages 将对话消息转换为脚本中的列表，每条消息为 {"id", "role", "content"}
+func scriptMessages(messages []scriptMessage) starlark.Value {
+	values := make([]starlark.Value, 0, len(messages))
+	for _, msg := range messages {
+		dict := starlark.NewDict(3)
+		dict.SetKey(starlark.String("id"), starlark.MakeUint(msg.ID))
+		dict.SetKey(starlark.String("role"), starlark.String(msg.Role))
+		dict.SetKey(starlark.String("content"), starlark.String(msg.Content))
+		values = append(values, dict)
+	}
+	return starlark.NewList(values)
+}
+
+// scriptCallModel call_model(prompt, system=, model=, temperature=, max_tokens=)
+// 使用智能体的 API 配置请求一次模型（不提供工具），prompt 为字符串或 {"role", "content"} 列表，返回回复文本
+func scriptCallModel(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var prompt starlark.Value
+	var temperature starlark.Value = starlark.None
+	var call scriptModelCall
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"prompt", &prompt, "system?", &call.System, "model?", &call.Model, "temperature?", &temperature, "max_tokens?", &call.MaxTokens); err != nil {
+		return nil, err
+	}
+	if temperature != starlark.None {
+		value, ok := starlark.AsFloat(temperature)
+		if !ok {
+			return nil, fmt.Errorf("%s: temperature 应为数字，实际为 %s", b.Name(), temperature.Type())
+		}
+		call.Temperature = &value
+	}
+
+	messages, err := scriptChatMessages(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	call.Messages = messages
+
+	output, err := scriptSessionOf(thread).call(b.Name(), call)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(output), nil
+}
+
+// scriptChatMessages 将 call_model 的 prompt 转换为消息列表（系统提示词由宿主进程添加）
+func scriptChatMessages(prompt starlark.Value) ([]scriptChatMessage, error) {
+	if text, ok := starlark.AsString(prompt); ok {
+		return []scriptChatMessage{{Role: "user", Content: text}}, nil
+	}
+	list, ok := prompt.(*starlark.List)
+	if !ok {
+		return nil, fmt.Errorf("prompt 应为字符串或消息列表，实际为 %s", prompt.Type())
+	}
+	if list.Len() == 0 {
+		return nil, errors.New("消息列表为空")
+	}
+	messages := make([]scriptChatMessage, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		value, err := scriptToGo(list.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		msg, _ := value.(map[string]interface{})
+		role, _ := msg["role"].(string)
+		content, ok := msg["content"].(string)
+		if role != "system" && role != "user" && role != "assistant" {
+			return nil, fmt.Errorf("第 %d 条消息的 role 应为 system、user 或 assistant", i+1)
+		}
+		if !ok {
+			return nil, fmt.Errorf("第 %d 条消息的 content 应为字符串", i+1)
+		}
+		messages = append(messages, scriptChatMessage{Role: role, Content: content})
+	}
+	return messages, nil
+}
+
+// scriptCallTool call_tool(name, args=None)：执行智能体配置的工具，args 为字典或字符串，返回工具输出
+func scriptCallTool(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	session := scriptSessionOf(thread)
+
+	var call scriptToolCall
+	var arguments starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &call.Name, "args?", &arguments); err != nil {
+		return nil, err
+	}
+
+	found := false
+	names := make([]string, len(session.request.Tools))
+	for i, tool := range session.request.Tools {
+		names[i] = tool.Name
+		found = found || tool.Name == call.Name
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: 智能体没有配置工具 %s，可用的工具: %s", b.Name(), call.Name, strings.Join(names, ", "))
+	}
+
+	if text, ok := starlark.AsString(arguments); ok {
+		call.Text = &text
+	} else if arguments != starlark.None {
+		value, err := scriptToGo(arguments)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		call.Arguments = encoded
+	}
+
+	output, err := session.call(b.Name(), call)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(output), nil
+}
+
+// scriptListTools list_tools()：返回智能体可用的工具，每个为 {"name", "description", "parameters"}
+func scriptListTools(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	session := scriptSessionOf(thread)
+
+	values := make([]starlark.Value, 0, len(session.request.Tools))
+	for _, tool := range session.request.Tools {
+		raw, err := json.Marshal(tool)
+		if err != nil {
+			return nil, &scriptHostError{function: b.Name(), err: err}
+		}
+		value, err := scriptFromJSON(raw)
+		if err != nil {
+			return nil, &scriptHostError{function: b.Name(), err: err}
+		}
+		values = append(values, value)
+	}
+	return starlark.NewList(values), nil
+}
+
+// scriptKVGet kv.get(key, default=None)
+func scriptKVGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var defaultValue starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &defaultValue); err != nil {
+		return nil, err
+	}
+
+	raw, ok := scriptSessionOf(thread).state[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	value, err := scriptFromJSON(raw)
+	if err != nil {
+		return nil, &scriptHostError{function: b.Name(), err: err}
+	}
+	return value, nil
+}
+
+// scriptKVSet kv.set(key, value)：值必须可以编码为 JSON（None、布尔、数字、字符串及其列表和字符串键字典）
+func scriptKVSet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%s: 键不能为空", b.Name())
+	}
+
+	goValue, err := scriptToGo(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	raw, err := json.Marshal(goValue)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	session := scriptSessionOf(thread)
+	size := session.stateSize - session.entrySize(key) + len(key) + len(raw)
+	if size > maxScriptStateSize {
+		return nil, &scriptHostError{function: b.Name(), err: fmt.Errorf("KV 状态总大小超过上限 %d KB", maxScriptStateSize>>10)}
+	}
+	session.state[key] = raw
+	session.stateSize = size
+	session.stateDirty = true
+	return starlark.None, nil
+}
+
+// scriptKVDelete kv.delete(key)：返回键是否存在
+func scriptKVDelete(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+		return nil, err
+	}
+
+	session := scriptSessionOf(thread)
+	if _, ok := session.state[key]; !ok {
+		return starlark.False, nil
+	}
+	session.stateSize -= session.entrySize(key)
+	delete(session.state, key)
+	session.stateDirty = true
+	return starlark.True, nil
+}
+
+// scriptKVKeys kv.keys()：按字母顺序返回所有键
+func scriptKVKeys(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+
+	session := scriptSessionOf(thread)
+	keys := make([]string, 0, len(session.state))
+	for key := range session.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]starlark.Value, len(keys))
+	for i, key := range keys {
+		values[i] = starlark.String(key)
+	}
+	return starlark.NewList(values), nil
+}
+
+func (s *scriptSession) entrySize(key string) int {
+	raw, ok := s.state[key]
+	if !ok {
+		return 0
+	}
+	return len(key) + len(raw)
+}
+
+// scriptToGo 将脚本中的值转换为可以编码为 JSON 的 Go 值
+// 嵌套超过 maxScriptValueDepth 层或包含自身的列表、字典返回 runtime 类型的 ScriptError
+func scriptToGo(value starlark.Value) (interface{}, error) {
+	return scriptValueToGo(value, 0, make(map[starlark.Value]bool))
+}
+
+// scriptValueToGo 递归转换脚本值，path 记录当前路径上的列表和字典，用于发现循环引用
+func scriptValueToGo(value starlark.Value, depth int, path map[starlark.Value]bool) (interface{}, error) {
+	if depth > maxScriptValueDepth {
+		return nil, &ScriptError{Kind: ScriptErrorRuntime, Message: fmt.Sprintf("值的嵌套超过 %d 层", maxScriptValueDepth)}
+	}
+	switch value.(type) {
+	case *starlark.List, *starlark.Dict:
+		if path[value] {
+			return nil, &ScriptError{Kind: ScriptErrorRuntime, Message: fmt.Sprintf("%s 包含对自身的引用", value.Type())}
+		}
+		path[value] = true
+		defer delete(path, value)
+	}
+
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List, starlark.Tuple:
+		iterable := v.(starlark.Indexable)
+		values := make([]interface{}, iterable.Len())
+		for i := range values {
+			item, err := scriptValueToGo(iterable.Index(i), depth+1, path)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = item
+		}
+		return values, nil
+	case *starlark.Dict:
+		values := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("字典的键应为字符串，实际为 %s", item[0].Type())
+			}
+			converted, err := scriptValueToGo(item[1], depth+1, path)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = converted
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("不支持的值类型 %s", value.Type())
+	}
+}
+
+// scriptFromJSON 将 JSON 解码为脚本中的值，整数保持为 int
+func scriptFromJSON(raw []byte) (starlark.Value, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return scriptFromJSONValue(value)
+}
+
+// scriptFromJSONValue 将 JSON 解码（UseNumber）得到的值转换为脚本中的值
+func scriptFromJSONValue(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case json.Number:
+		if i, ok := new(big.Int).SetString(string(v), 10); ok {
+			return starlark.MakeBigInt(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		values := make([]starlark.Value, len(v))
+		for i, item := range v {
+			converted, err := scriptFromJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = converted
+		}
+		return starlark.NewList(values), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			converted, err := scriptFromJSONValue(v[key])
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(key), converted)
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("不支持的值类型 %T", value)
+	}
+}
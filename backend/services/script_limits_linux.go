@@ -0,0 +1,37 @@
+//go:build linux
+
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+)
+
+// scriptWorkerMemoryOverhead 脚本进程中 Go 运行时和服务程序本身占用的数据段大小，加在内存上限之上
+const scriptWorkerMemoryOverhead = 64 << 20
+
+// applyScriptResourceLimits 为脚本进程设置操作系统资源限制：
+// RLIMIT_DATA 限制堆等匿名内存（Linux 4.7 起），超出时 Go 运行时因内存不足退出；RLIMIT_CPU 限制 CPU 时间，超出时进程收到 SIGXCPU
+func applyScriptResourceLimits(limits ScriptLimits) error {
+	if limits.MaxMemory > 0 {
+		size := uint64(limits.MaxMemory + scriptWorkerMemoryOverhead)
+		if err := syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: size, Max: size}); err != nil {
+			return fmt.Errorf("RLIMIT_DATA: %w", err)
+		}
+	}
+	if limits.Timeout > 0 {
+		seconds := uint64(math.Ceil(limits.Timeout.Seconds())) + 1
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: seconds, Max: seconds + 1}); err != nil {
+			return fmt.Errorf("RLIMIT_CPU: %w", err)
+		}
+	}
+	return nil
+}
+
+// scriptWorkerCPUExceeded 脚本进程是否因超过 CPU 时间上限被终止
+func scriptWorkerCPUExceeded(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
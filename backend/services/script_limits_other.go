@@ -0,0 +1,14 @@
+//go:build !linux
+
+package services
+
+import "os"
+
+// applyScriptResourceLimits 非 Linux 平台不设置操作系统资源限制，内存只由脚本进程定期检查堆大小来限制
+func applyScriptResourceLimits(limits ScriptLimits) error {
+	return nil
+}
+
+func scriptWorkerCPUExceeded(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-chat-backend/config"
+	"ai-chat-backend/models"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// scriptFilename 脚本在错误位置和调用栈中显示的文件名
+	scriptFilename = "agent.star"
+	// scriptEntryPoint 脚本的入口函数，参数为对话消息列表，返回值为回复内容
+	scriptEntryPoint = "main"
+
+	// maxScriptLogs 执行轨迹中保留的 print 输出行数
+	maxScriptLogs = 200
+	// scriptLogLimit 单行 print 输出的最大长度（字符）
+	scriptLogLimit = 2000
+	// scriptMemoryCheckInterval 脚本进程检查自身堆内存的间隔
+	scriptMemoryCheckInterval = 20 * time.Millisecond
+	// maxScriptReplySize main 返回内容的最大长度（字节）
+	maxScriptReplySize = 1 << 20
+)
+
+// 脚本错误类型
+const (
+	ScriptErrorCompile     = "compile"      // 语法错误、未定义的名称或缺少入口函数
+	ScriptErrorRuntime     = "runtime"      // 脚本运行时错误
+	ScriptErrorHost        = "host"         // 调用模型、工具或读写 KV 状态失败
+	ScriptErrorTimeout     = "timeout"      // 超过执行时长上限
+	ScriptErrorStepLimit   = "step_limit"   // 超过解释器执行步数上限
+	ScriptErrorMemoryLimit = "memory_limit" // 超过脚本进程的内存上限
+)
+
+var scriptErrorLabels = map[string]string{
+	ScriptErrorCompile:     "脚本编译失败",
+	ScriptErrorRuntime:     "脚本运行出错",
+	ScriptErrorHost:        "脚本调用宿主接口失败",
+	ScriptErrorTimeout:     "脚本执行超时",
+	ScriptErrorStepLimit:   "脚本执行步数超过上限",
+	ScriptErrorMemoryLimit: "脚本内存占用超过上限",
+}
+
+// ScriptError 自定义代码执行失败，Line 为出错位置在脚本中的行号（未知时为 0）
+type ScriptError struct {
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Backtrace string `json:"backtrace,omitempty"`
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s（第 %d 行）: %s", scriptErrorLabels[e.Kind], e.Line, e.Message)
+	}
+	return scriptErrorLabels[e.Kind] + ": " + e.Message
+}
+
+// ScriptLimits 脚本执行的资源限制
+type ScriptLimits struct {
+	Timeout       time.Duration `json:"timeout"`
+	MaxSteps      uint64        `json:"max_steps"`
+	MaxMemory     int64         `json:"max_memory"`
+	MaxModelCalls int           `json:"max_model_calls"`
+}
+
+// scriptLimits 按配置读取脚本限制
+func scriptLimits() ScriptLimits {
+	limits := ScriptLimits{Timeout: 30 * time.Second, MaxSteps: 10000000, MaxMemory: 128 << 20, MaxModelCalls: 20}
+	if cfg := config.AppConfig; cfg != nil {
+		limits = ScriptLimits{
+			Timeout:       cfg.ScriptTimeout,
+			MaxSteps:      cfg.ScriptMaxSteps,
+			MaxMemory:     cfg.ScriptMaxMemory,
+			MaxModelCalls: cfg.ScriptMaxModelCalls,
+		}
+	}
+	return limits
+}
+
+// ScriptCall 脚本发起的一次模型或工具调用
+type ScriptCall struct {
+	Type         string `json:"type"` // model / tool
+	Name         string `json:"name"` // 模型或工具名称
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	Error        string `json:"error,omitempty"`
+	LatencyMs    int64  `json:"latency_ms"`
+}
+
+// ScriptTrace 脚本执行轨迹，保存在AI回复的 metadata.script 中
+type ScriptTrace struct {
+	Logs       []string     `json:"logs"`
+	Calls      []ScriptCall `json:"calls"`
+	Steps      uint64       `json:"steps"`
+	DurationMs int64        `json:"duration_ms"`
+}
+
+// scriptFileOptions 允许 while、set、递归和顶层控制语句；死循环和深递归由步数限制兜底
+var scriptFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+// ScriptRunner 在 Starlark 沙箱中执行自定义代码智能体的脚本
+// 每次执行启动一个独立的脚本进程（见 RunScriptWorker），脚本只能通过预置的宿主接口（call_model、call_tool、list_tools、kv）访问外部，
+// 不能读写文件、访问网络或加载模块；脚本进程的内存和 CPU 时间受操作系统资源限制约束，超出时只有脚本进程被终止
+type ScriptRunner struct {
+	aiService *AIService
+	limits    ScriptLimits
+
+	mu    sync.Mutex
+	trace *ScriptTrace
+}
+
+func NewScriptRunner(aiService *AIService) *ScriptRunner {
+	return &ScriptRunner{aiService: aiService, limits: scriptLimits()}
+}
+
+// Trace 返回最近一次执行的轨迹，未执行时为 nil
+func (r *ScriptRunner) Trace() *ScriptTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.trace == nil {
+		return nil
+	}
+	trace := *r.trace
+	trace.Logs = append([]string(nil), r.trace.Logs...)
+	trace.Calls = append([]ScriptCall(nil), r.trace.Calls...)
+	return &trace
+}
+
+func (r *ScriptRunner) addLog(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.trace.Logs) < maxScriptLogs {
+		r.trace.Logs = append(r.trace.Logs, truncateRunes(line, scriptLogLimit))
+	}
+}
+
+func (r *ScriptRunner) addCall(call ScriptCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trace.Calls = append(r.trace.Calls, call)
+}
+
+// ValidateScript 检查脚本能否编译并定义了入口函数，不执行脚本
+func ValidateScript(code string) error {
+	_, err := compileScript(code)
+	return err
+}
+
+// compileScript 编译脚本，错误统一转换为 compile 类型的 ScriptError
+func compileScript(code string) (*starlark.Program, error) {
+	file, program, err := starlark.SourceProgramOptions(scriptFileOptions, scriptFilename, code, scriptPredeclared.Has)
+	if err != nil {
+		scriptErr := &ScriptError{Kind: ScriptErrorCompile, Message: err.Error()}
+		var syntaxErr syntax.Error
+		var resolveErrs resolve.ErrorList
+		switch {
+		case errors.As(err, &syntaxErr):
+			scriptErr.Message, scriptErr.Line, scriptErr.Column = syntaxErr.Msg, int(syntaxErr.Pos.Line), int(syntaxErr.Pos.Col)
+		case errors.As(err, &resolveErrs):
+			first := resolveErrs[0]
+			scriptErr.Message, scriptErr.Line, scriptErr.Column = first.Msg, int(first.Pos.Line), int(first.Pos.Col)
+		}
+		return nil, scriptErr
+	}
+
+	for _, stmt := range file.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == scriptEntryPoint {
+			return program, nil
+		}
+	}
+	return nil, &ScriptError{Kind: ScriptErrorCompile, Message: "脚本需要定义入口函数 main(messages)"}
+}
+
+// Run 执行智能体的脚本：先运行顶层代码，再以消息列表调用 main，返回值作为回复
+// 返回的 Token 使用量为脚本中所有模型调用之和；执行成功后保存 KV 状态，失败时丢弃本次的修改
+func (r *ScriptRunner) Run(ctx context.Context, agent models.Agent, messages []models.Message) (string, int, int, error) {
+	r.mu.Lock()
+	r.trace = &ScriptTrace{Logs: []string{}, Calls: []ScriptCall{}}
+	r.mu.Unlock()
+
+	// 编译错误在启动脚本进程前直接返回
+	if _, err := compileScript(agent.CustomCode); err != nil {
+		return "", 0, 0, err
+	}
+
+	process, err := newScriptProcess(ctx, r, agent, messages)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	started := time.Now()
+	done, err := process.run()
+
+	r.mu.Lock()
+	if done != nil {
+		r.trace.Steps = done.Steps
+	}
+	r.trace.DurationMs = time.Since(started).Milliseconds()
+	r.mu.Unlock()
+
+	if err != nil {
+		return "", process.inputTokens, process.outputTokens, err
+	}
+	if done.Error != nil {
+		return "", process.inputTokens, process.outputTokens, done.Error
+	}
+	if done.StateDirty {
+		if err := process.saveState(done.State); err != nil {
+			return "", process.inputTokens, process.outputTokens, fmt.Errorf("保存脚本状态失败: %w", err)
+		}
+	}
+	return done.Reply, process.inputTokens, process.outputTokens, nil
+}
+
+// runScript 在脚本进程中执行请求的脚本，返回执行结果
+func runScript(request *scriptRequest, conn *scriptConn) *scriptFrame {
+	done := &scriptFrame{Type: scriptFrameDone}
+	program, err := compileScript(request.Code)
+	if err != nil {
+		done.Error = err.(*ScriptError)
+		return done
+	}
+
+	session := newScriptSession(request, conn)
+	thread := &starlark.Thread{
+		Name:  "agent",
+		Print: func(_ *starlark.Thread, msg string) { session.log(msg) },
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("不支持加载模块 %s", module)
+		},
+		OnMaxSteps: func(thread *starlark.Thread) { session.stop(thread, ScriptErrorStepLimit) },
+	}
+	thread.SetLocal(scriptSessionKey, session)
+	thread.SetMaxExecutionSteps(request.Limits.MaxSteps)
+
+	stopWatching := session.watch(thread)
+	reply, err := execScript(thread, program, scriptMessages(request.Messages))
+	stopWatching()
+
+	done.Steps = thread.ExecutionSteps()
+	switch {
+	case err != nil:
+		done.Error = session.classify(err)
+	case len(reply) > maxScriptReplySize:
+		done.Error = &ScriptError{Kind: ScriptErrorRuntime, Message: fmt.Sprintf("main 返回的内容超过 %d MB", maxScriptReplySize>>20)}
+	default:
+		done.Reply = reply
+		done.State, done.StateDirty = session.state, session.stateDirty
+	}
+	return done
+}
+
+// execScript 运行顶层代码并调用入口函数，入口函数必须返回字符串
+func execScript(thread *starlark.Thread, program *starlark.Program, messages starlark.Value) (string, error) {
+	globals, err := program.Init(thread, scriptPredeclared)
+	if err != nil {
+		return "", err
+	}
+	result, err := starlark.Call(thread, globals[scriptEntryPoint], starlark.Tuple{messages}, nil)
+	if err != nil {
+		return "", err
+	}
+	reply, ok := starlark.AsString(result)
+	if !ok {
+		return "", &ScriptError{Kind: ScriptErrorRuntime, Message: fmt.Sprintf("main 应返回字符串，实际返回 %s", result.Type())}
+	}
+	return reply, nil
+}
+
+// scriptSessionKey 线程本地变量中保存 scriptSession 的键
+const scriptSessionKey = "session"
+
+// scriptSession 脚本进程中一次执行的上下文：宿主接口使用的工具列表和 KV 状态、与宿主进程的连接、print 输出计数和中止原因
+type scriptSession struct {
+	request *scriptRequest
+	conn    *scriptConn
+
+	state      map[string]json.RawMessage
+	stateSize  int
+	stateDirty bool
+
+	logs int
+
+	mu         sync.Mutex
+	stopReason string
+}
+
+func newScriptSession(request *scriptRequest, conn *scriptConn) *scriptSession {
+	session := &scriptSession{request: request, conn: conn, state: make(map[string]json.RawMessage)}
+	for key, raw := range request.State {
+		session.state[key] = raw
+		session.stateSize += len(key) + len(raw)
+	}
+	return session
+}
+
+// log 将 print 输出发送给宿主进程，超过 maxScriptLogs 行后丢弃
+func (s *scriptSession) log(line string) {
+	if s.logs >= maxScriptLogs {
+		return
+	}
+	s.logs++
+	s.conn.send(&scriptFrame{Type: scriptFrameLog, Text: truncateRunes(line, scriptLogLimit)})
+}
+
+// stop 以指定原因中止脚本（只记录第一次的原因）
+func (s *scriptSession) stop(thread *starlark.Thread, reason string) {
+	s.mu.Lock()
+	if s.stopReason == "" {
+		s.stopReason = reason
+	}
+	s.mu.Unlock()
+	thread.Cancel(reason)
+}
+
+// watch 在后台监控执行时长和脚本进程的堆内存，超出限制时中止脚本并报告出错位置；返回停止监控的函数
+// 堆内存在两次检查之间的突增由脚本进程的操作系统内存限制兜底（见 applyScriptResourceLimits）
+func (s *scriptSession) watch(thread *starlark.Thread) func() {
+	limits := s.request.Limits
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		var tick <-chan time.Time
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		if limits.MaxMemory > 0 {
+			ticker := time.NewTicker(scriptMemoryCheckInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				s.stop(thread, ScriptErrorTimeout)
+				return
+			case <-tick:
+				metrics.Read(sample)
+				if int64(sample[0].Value.Uint64()) > limits.MaxMemory {
+					s.stop(thread, ScriptErrorMemoryLimit)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// classify 将执行错误转换为 ScriptError
+func (s *scriptSession) classify(err error) *ScriptError {
+	// 宿主接口返回的 ScriptError 会被包装在 EvalError 中，保留其类型并补上出错位置
+	var evalErr *starlark.EvalError
+	isEvalErr := errors.As(err, &evalErr)
+	kind := ScriptErrorRuntime
+	var inner *ScriptError
+	if errors.As(err, &inner) {
+		if !isEvalErr {
+			return inner
+		}
+		kind = inner.Kind
+	}
+
+	scriptErr := &ScriptError{Kind: kind, Message: err.Error()}
+	if isEvalErr {
+		scriptErr.Message = evalErr.Msg
+		if inner != nil {
+			scriptErr.Message = strings.Replace(evalErr.Msg, inner.Error(), inner.Message, 1)
+		}
+		scriptErr.Backtrace = evalErr.CallStack.String()
+		for i := 0; i < len(evalErr.CallStack); i++ {
+			if pos := evalErr.CallStack.At(i).Pos; pos.Filename() == scriptFilename {
+				scriptErr.Line, scriptErr.Column = int(pos.Line), int(pos.Col)
+				break
+			}
+		}
+	}
+
+	s.mu.Lock()
+	reason := s.stopReason
+	s.mu.Unlock()
+	limits := s.request.Limits
+	switch reason {
+	case ScriptErrorTimeout:
+		scriptErr.Kind, scriptErr.Message = ScriptErrorTimeout, fmt.Sprintf("执行时间超过 %s", limits.Timeout)
+	case ScriptErrorStepLimit:
+		scriptErr.Kind, scriptErr.Message = ScriptErrorStepLimit, fmt.Sprintf("执行步数超过 %d，请检查是否有死循环", limits.MaxSteps)
+	case ScriptErrorMemoryLimit:
+		scriptErr.Kind, scriptErr.Message = ScriptErrorMemoryLimit, fmt.Sprintf("脚本进程内存超过 %d MB", limits.MaxMemory>>20)
+	default:
+		var hostErr *scriptHostError
+		if errors.As(err, &hostErr) {
+			scriptErr.Kind = ScriptErrorHost
+		}
+	}
+	return scriptErr
+}
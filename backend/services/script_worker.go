@@ -0,0 +1,467 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// ScriptWorkerCommand 以脚本进程方式启动服务程序的命令行参数
+const ScriptWorkerCommand = "script-worker"
+
+const (
+	// maxScriptFrameSize 脚本进程发送的单条消息的最大长度（字节）
+	maxScriptFrameSize = 8 << 20
+	// maxScriptCallSize 宿主接口调用参数编码为 JSON 后的最大长度（字节）
+	maxScriptCallSize = 1 << 20
+	// maxScriptStderr 保留的脚本进程 stderr 输出长度（字节），用于判断进程异常退出的原因
+	maxScriptStderr = 4 << 10
+	// scriptWorkerGracePeriod 超过执行时长后等待脚本进程自行结束的时间，之后强制终止
+	scriptWorkerGracePeriod = 2 * time.Second
+)
+
+// 脚本进程发送的消息类型
+const (
+	scriptFrameLog  = "log"  // print 输出
+	scriptFrameCall = "call" // 调用宿主接口，宿主进程回复一条 scriptCallResult
+	scriptFrameDone = "done" // 执行结束
+)
+
+// scriptRequest 宿主进程发送给脚本进程的执行请求
+type scriptRequest struct {
+	Code     string                     `json:"code"`
+	Messages []scriptMessage            `json:"messages"`
+	Tools    []scriptToolInfo           `json:"tools"`
+	State    map[string]json.RawMessage `json:"state"`
+	Limits   ScriptLimits               `json:"limits"`
+}
+
+// scriptMessage 传给脚本 main 函数的一条对话消息
+type scriptMessage struct {
+	ID      uint   `json:"id"`
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// scriptToolInfo 智能体可用的工具，即 list_tools() 返回的内容
+type scriptToolInfo struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// scriptFrame 脚本进程发送给宿主进程的消息，每条编码为一行 JSON
+type scriptFrame struct {
+	Type string `json:"type"`
+
+	// log
+	Text string `json:"text,omitempty"`
+
+	// call
+	Function string          `json:"function,omitempty"`
+	Args     json.RawMessage `json:"args,omitempty"`
+
+	// done：Error 不为空时执行失败
+	Reply      string                     `json:"reply,omitempty"`
+	Error      *ScriptError               `json:"error,omitempty"`
+	State      map[string]json.RawMessage `json:"state,omitempty"`
+	StateDirty bool                       `json:"state_dirty,omitempty"`
+	Steps      uint64                     `json:"steps,omitempty"`
+}
+
+// scriptCallResult 宿主接口调用的结果，Error 不为空时调用失败
+type scriptCallResult struct {
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// scriptModelCall call_model 的参数，System 和 Model 为空时使用智能体的配置
+type scriptModelCall struct {
+	Messages    []scriptChatMessage `json:"messages"`
+	System      string              `json:"system,omitempty"`
+	Model       string              `json:"model,omitempty"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+}
+
+type scriptChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// scriptToolCall call_tool 的参数，字符串参数放在 Text 中，字典参数编码后放在 Arguments 中
+type scriptToolCall struct {
+	Name      string          `json:"name"`
+	Text      *string         `json:"text,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// RunScriptWorker 脚本进程的入口，main 在第一个参数为 ScriptWorkerCommand 时调用
+// 从 stdin 读取执行请求，设置资源限制后执行脚本；print 输出、宿主接口调用和执行结果通过 stdout 发送给宿主进程
+func RunScriptWorker() {
+	conn := &scriptConn{decoder: json.NewDecoder(os.Stdin), writer: os.Stdout}
+
+	var request scriptRequest
+	if err := conn.decoder.Decode(&request); err != nil {
+		log.Fatalf("读取脚本执行请求失败: %v", err)
+	}
+	if request.Limits.MaxMemory > 0 {
+		debug.SetMemoryLimit(request.Limits.MaxMemory)
+	}
+	if err := applyScriptResourceLimits(request.Limits); err != nil {
+		log.Fatalf("设置脚本进程资源限制失败: %v", err)
+	}
+
+	if err := conn.send(runScript(&request, conn)); err != nil {
+		log.Fatalf("发送脚本执行结果失败: %v", err)
+	}
+}
+
+// scriptConn 脚本进程与宿主进程的连接：从 stdin 读取请求和调用结果，向 stdout 发送消息
+type scriptConn struct {
+	decoder *json.Decoder
+	writer  io.Writer
+}
+
+func (c *scriptConn) send(frame *scriptFrame) error {
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if len(raw) > maxScriptFrameSize {
+		return fmt.Errorf("消息超过 %d MB", maxScriptFrameSize>>20)
+	}
+	_, err = c.writer.Write(append(raw, '\n'))
+	return err
+}
+
+// call 请求宿主进程执行 call_model 或 call_tool，等待并返回结果
+func (s *scriptSession) call(function string, args interface{}) (string, error) {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", function, err)
+	}
+	if len(raw) > maxScriptCallSize {
+		return "", fmt.Errorf("%s: 参数超过 %d MB", function, maxScriptCallSize>>20)
+	}
+
+	if err := s.conn.send(&scriptFrame{Type: scriptFrameCall, Function: function, Args: raw}); err != nil {
+		return "", &scriptHostError{function: function, err: err}
+	}
+	var result scriptCallResult
+	if err := s.conn.decoder.Decode(&result); err != nil {
+		return "", &scriptHostError{function: function, err: err}
+	}
+	if result.Error != "" {
+		return "", &scriptHostError{function: function, err: errors.New(result.Error)}
+	}
+	return result.Value, nil
+}
+
+// scriptProcess 宿主进程中的一次脚本执行：启动脚本进程，代为执行模型和工具调用，统计 Token 使用量，读写 KV 状态
+type scriptProcess struct {
+	ctx            context.Context
+	runner         *ScriptRunner
+	agent          models.Agent
+	tools          []Tool
+	conversationID uint
+	request        *scriptRequest
+
+	modelCalls   int
+	inputTokens  int
+	outputTokens int
+}
+
+func newScriptProcess(ctx context.Context, runner *ScriptRunner, agent models.Agent, messages []models.Message) (*scriptProcess, error) {
+	process := &scriptProcess{ctx: ctx, runner: runner, agent: agent}
+	request := &scriptRequest{
+		Code:     agent.CustomCode,
+		Messages: make([]scriptMessage, 0, len(messages)),
+		Tools:    []scriptToolInfo{},
+		Limits:   runner.limits,
+	}
+	for _, name := range agent.Tools {
+		if tool, ok := runner.aiService.tools.Get(name); ok {
+			process.tools = append(process.tools, tool)
+			request.Tools = append(request.Tools, scriptToolInfo{Name: tool.Name(), Description: tool.Description(), Parameters: tool.Parameters()})
+		}
+	}
+	for _, msg := range messages {
+		request.Messages = append(request.Messages, scriptMessage{ID: msg.ID, Role: string(msg.Role), Content: msg.Content})
+	}
+	if len(messages) > 0 {
+		process.conversationID = messages[len(messages)-1].ConversationID
+	}
+
+	state, err := process.loadState()
+	if err != nil {
+		return nil, fmt.Errorf("加载脚本状态失败: %w", err)
+	}
+	request.State = state
+	process.request = request
+	return process, nil
+}
+
+// run 启动脚本进程并处理它发送的消息，返回 done 消息；脚本进程没有正常结束时返回错误
+func (p *scriptProcess) run() (*scriptFrame, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("启动脚本进程失败: %w", err)
+	}
+
+	// 宿主接口调用在执行时长内有效；脚本进程超过执行时长和宽限期仍未结束时被终止
+	callCtx, cancelCalls := context.WithTimeout(p.ctx, p.runner.limits.Timeout)
+	defer cancelCalls()
+	killCtx, kill := context.WithTimeout(p.ctx, p.runner.limits.Timeout+scriptWorkerGracePeriod)
+	defer kill()
+
+	cmd := exec.CommandContext(killCtx, executable, ScriptWorkerCommand)
+	// 脚本进程不继承环境变量，避免数据库密码、密钥等进入脚本进程
+	cmd.Env = []string{}
+	stderr := &scriptStderr{}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("启动脚本进程失败: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("启动脚本进程失败: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动脚本进程失败: %w", err)
+	}
+
+	encoder := json.NewEncoder(stdin)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxScriptFrameSize+1)
+
+	var done *scriptFrame
+	if err := encoder.Encode(p.request); err == nil {
+	read:
+		for scanner.Scan() {
+			var frame scriptFrame
+			if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+				break
+			}
+			switch frame.Type {
+			case scriptFrameLog:
+				p.runner.addLog(frame.Text)
+			case scriptFrameCall:
+				if err := encoder.Encode(p.handle(callCtx, &frame)); err != nil {
+					break read
+				}
+			case scriptFrameDone:
+				done = &frame
+				break read
+			}
+		}
+	}
+	scanErr := scanner.Err()
+	if done == nil {
+		kill()
+	}
+	cmd.Wait()
+	if done != nil {
+		return done, nil
+	}
+	return nil, p.exitError(killCtx, cmd.ProcessState, stderr.String(), scanErr)
+}
+
+// exitError 脚本进程没有发送执行结果就退出时，根据退出状态和 stderr 判断原因
+func (p *scriptProcess) exitError(killCtx context.Context, state *os.ProcessState, stderr string, scanErr error) error {
+	limits := p.runner.limits
+	switch {
+	case p.ctx.Err() != nil:
+		return p.ctx.Err()
+	case errors.Is(scanErr, bufio.ErrTooLong):
+		return &ScriptError{Kind: ScriptErrorRuntime, Message: fmt.Sprintf("脚本进程发送的消息超过 %d MB", maxScriptFrameSize>>20)}
+	case strings.Contains(stderr, "out of memory") || strings.Contains(stderr, "cannot allocate memory") || strings.Contains(stderr, "stack exceeds"):
+		return &ScriptError{Kind: ScriptErrorMemoryLimit, Message: fmt.Sprintf("脚本进程内存超过 %d MB", limits.MaxMemory>>20)}
+	case errors.Is(killCtx.Err(), context.DeadlineExceeded) || (state != nil && scriptWorkerCPUExceeded(state)):
+		return &ScriptError{Kind: ScriptErrorTimeout, Message: fmt.Sprintf("执行时间超过 %s", limits.Timeout)}
+	}
+
+	message := "脚本进程异常退出"
+	if state != nil {
+		message += "（" + state.String() + "）"
+	}
+	if line, _, _ := strings.Cut(strings.TrimSpace(stderr), "\n"); line != "" {
+		message += ": " + line
+	}
+	return &ScriptError{Kind: ScriptErrorRuntime, Message: message}
+}
+
+// handle 执行脚本进程请求的宿主接口调用
+func (p *scriptProcess) handle(ctx context.Context, frame *scriptFrame) scriptCallResult {
+	var value string
+	var err error
+	switch frame.Function {
+	case "call_model":
+		value, err = p.callModel(ctx, frame.Args)
+	case "call_tool":
+		value, err = p.callTool(ctx, frame.Args)
+	default:
+		err = fmt.Errorf("未知的宿主接口 %s", frame.Function)
+	}
+	if err != nil {
+		return scriptCallResult{Error: err.Error()}
+	}
+	return scriptCallResult{Value: value}
+}
+
+// callModel 使用智能体的 API 配置请求一次模型，按 call_model 的参数覆盖系统提示词、模型和参数
+func (p *scriptProcess) callModel(ctx context.Context, args json.RawMessage) (string, error) {
+	var call scriptModelCall
+	if err := json.Unmarshal(args, &call); err != nil {
+		return "", err
+	}
+	limit := p.runner.limits.MaxModelCalls
+	if p.modelCalls >= limit {
+		return "", fmt.Errorf("调用模型次数超过上限 %d", limit)
+	}
+	p.modelCalls++
+
+	agent := p.agent
+	if call.System != "" {
+		agent.SystemPrompt = call.System
+	}
+	if call.Model != "" {
+		agent.ModelName = call.Model
+	}
+	if call.Temperature != nil {
+		agent.ModelParams.Temperature = *call.Temperature
+	}
+	if call.MaxTokens > 0 {
+		agent.ModelParams.MaxTokens = call.MaxTokens
+	}
+
+	var chatMessages []map[string]interface{}
+	if agent.SystemPrompt != "" {
+		chatMessages = append(chatMessages, map[string]interface{}{"role": "system", "content": agent.SystemPrompt})
+	}
+	for _, msg := range call.Messages {
+		chatMessages = append(chatMessages, map[string]interface{}{"role": msg.Role, "content": msg.Content})
+	}
+
+	started := time.Now()
+	result, inputTokens, outputTokens, err := p.runner.aiService.complete(ctx, agent, chatMessages)
+	record := ScriptCall{Type: "model", Name: agent.ModelName, LatencyMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		record.Error = err.Error()
+		p.runner.addCall(record)
+		return "", err
+	}
+	record.InputTokens, record.OutputTokens = inputTokens, outputTokens
+	p.runner.addCall(record)
+	p.inputTokens += inputTokens
+	p.outputTokens += outputTokens
+	return result.Content, nil
+}
+
+// callTool 执行智能体配置的工具
+func (p *scriptProcess) callTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var call scriptToolCall
+	if err := json.Unmarshal(args, &call); err != nil {
+		return "", err
+	}
+	var tool Tool
+	for _, candidate := range p.tools {
+		if candidate.Name() == call.Name {
+			tool = candidate
+		}
+	}
+	if tool == nil {
+		return "", fmt.Errorf("智能体没有配置工具 %s", call.Name)
+	}
+
+	input := "{}"
+	if call.Text != nil {
+		input = reactToolArguments(tool, *call.Text)
+	} else if len(call.Arguments) > 0 {
+		input = string(call.Arguments)
+	}
+
+	started := time.Now()
+	output, err := tool.Execute(ctx, input)
+	record := ScriptCall{Type: "tool", Name: call.Name, LatencyMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	p.runner.addCall(record)
+	return output, err
+}
+
+// loadState 读取智能体在当前对话中的 KV 状态（没有对话时状态只在本次执行中有效）
+func (p *scriptProcess) loadState() (map[string]json.RawMessage, error) {
+	if p.agent.ID == 0 || p.conversationID == 0 {
+		return nil, nil
+	}
+
+	var state models.ScriptState
+	err := database.DB.Where("agent_id = ? AND conversation_id = ?", p.agent.ID, p.conversationID).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state.Values, nil
+}
+
+// saveState 保存脚本修改后的 KV 状态
+func (p *scriptProcess) saveState(values map[string]json.RawMessage) error {
+	if p.agent.ID == 0 || p.conversationID == 0 {
+		return nil
+	}
+	if values == nil {
+		values = map[string]json.RawMessage{}
+	}
+
+	state := models.ScriptState{AgentID: p.agent.ID, ConversationID: p.conversationID}
+	return database.DB.Where("agent_id = ? AND conversation_id = ?", p.agent.ID, p.conversationID).
+		Assign(map[string]interface{}{"values": models.ScriptValues(values)}).
+		FirstOrCreate(&state).Error
+}
+
+// scriptStderr 保留脚本进程 stderr 输出的前 maxScriptStderr 字节（Go 运行时的致命错误信息）
+type scriptStderr struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *scriptStderr) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if room := maxScriptStderr - w.buf.Len(); room > 0 {
+		if len(p) > room {
+			w.buf.Write(p[:room])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *scriptStderr) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
@@ -0,0 +1,28 @@
+package services
+
+// StreamEventType 流式执行事件类型
+type StreamEventType string
+
+const (
+	StreamEventContent   StreamEventType = "content"    // 内容增量
+	StreamEventNodeStart StreamEventType = "node_start" // 工作流节点开始执行
+	StreamEventNodeEnd   StreamEventType = "node_end"   // 工作流节点执行结束
+	StreamEventReActStep StreamEventType = "react_step" // ReAct 完成一步推理（思考、工具调用和观察结果）
+	StreamEventError     StreamEventType = "error"      // 执行失败
+	StreamEventDone      StreamEventType = "done"       // 执行完成
+)
+
+// StreamEvent 流式执行事件
+type StreamEvent struct {
+	Type         StreamEventType `json:"type"`
+	Content      string          `json:"content,omitempty"`
+	NodeID       string          `json:"node_id,omitempty"`
+	NodeType     string          `json:"node_type,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Step         *ReActStep      `json:"step,omitempty"`
+	InputTokens  int             `json:"input_tokens,omitempty"`
+	OutputTokens int             `json:"output_tokens,omitempty"`
+}
+
+// streamRunner 执行一次流式任务，通过 emit 发送中间事件，返回完整内容和Token使用量
+type streamRunner func(emit func(StreamEvent)) (string, int, int, error)
@@ -106,6 +106,7 @@ func (s *TemplateService) initBuiltInTemplates() {
 			Temperature: 0.3, // 更低的温度以获得更精确的代码
 			MaxTokens:   4000,
 		},
+		RequiredTools: []string{"calculator", "current_time"},
 		ConfigurableParams: []models.TemplateParam{
 			{
 				Name:         "programming_languages",
@@ -245,6 +246,7 @@ func (s *TemplateService) initBuiltInTemplates() {
 			Temperature: 0.4,
 			MaxTokens:   3000,
 		},
+		RequiredTools: []string{"calculator"},
 		ConfigurableParams: []models.TemplateParam{
 			{
 				Name:         "analysis_focus",
@@ -366,6 +368,136 @@ func (s *TemplateService) initBuiltInTemplates() {
 		IsBuiltIn:  true,
 		UsageCount: 0,
 	}
+
+	// 6. 知识库问答
+	s.templates["knowledge_qa"] = models.AgentTemplate{
+		ID:          "knowledge_qa",
+		Name:        "知识库问答",
+		Description: "基于知识库文档回答问题，回答中标注引用来源",
+		Category:    "rag",
+		Icon:        "📚",
+		Tags:        []string{"知识库", "检索", "问答"},
+		DefaultSystemPrompt: `你是一个基于知识库回答问题的助手。请遵循以下原则：
+1. 只依据提供的参考资料回答，不要编造资料中没有的内容
+2. 引用资料时在句末用 [编号] 标注来源
+3. 参考资料不足以回答问题时，请明确告知用户`,
+		DefaultModelName: "anthropic/claude-3.5-sonnet",
+		DefaultModelParams: models.ModelParams{
+			Temperature: 0.2,
+			MaxTokens:   2000,
+		},
+		ConfigurableParams: []models.TemplateParam{
+			{
+				Name:        "knowledge_base_ids",
+				Label:       "知识库",
+				Type:        "multiselect",
+				Description: "选择用于检索的知识库",
+				Required:    true,
+			},
+			{
+				Name:         "top_k",
+				Label:        "检索分段数",
+				Type:         "number",
+				Description:  "每次回答注入的参考资料分段数",
+				DefaultValue: DefaultRetrievalTopK,
+				Required:     false,
+				Validation: &models.ParamValidation{
+					Min: floatPtr(1),
+					Max: floatPtr(MaxRetrievalTopK),
+				},
+			},
+		},
+		WorkflowDefinition: models.EinoWorkflowDefinition{
+			Nodes: []models.WorkflowNode{
+				{
+					ID:     "retriever",
+					Type:   "retriever",
+					Config: map[string]interface{}{},
+				},
+				{
+					ID:   "answer",
+					Type: "chatmodel",
+					Config: map[string]interface{}{
+						"type": "knowledge_qa",
+					},
+				},
+			},
+			Edges: []models.WorkflowEdge{
+				{Source: "retriever", Target: "answer"},
+			},
+		},
+		Author:     "System",
+		Version:    "1.0.0",
+		IsBuiltIn:  true,
+		UsageCount: 0,
+	}
+
+	// 7. 推理决策 Agent
+	s.templates["react_agent"] = models.AgentTemplate{
+		ID:          "react_agent",
+		Name:        "推理决策助手",
+		Description: "按“思考-行动-观察”循环逐步推理，可以多次调用工具解决复杂问题",
+		Category:    "react",
+		Icon:        "🧠",
+		Tags:        []string{"推理", "ReAct", "工具"},
+		DefaultSystemPrompt: `你是一个善于逐步推理的AI助手。面对复杂问题时，先拆解问题，
+需要精确计算或实时信息时调用工具，不要凭空猜测工具的结果。
+最终答案要清晰、完整，并简要说明推理依据。`,
+		DefaultModelName: "anthropic/claude-3.5-sonnet",
+		DefaultModelParams: models.ModelParams{
+			Temperature: 0.2,
+			MaxTokens:   2000,
+		},
+		RequiredTools: []string{"calculator", "current_time"},
+		ConfigurableParams: []models.TemplateParam{
+			{
+				Name:         "max_iterations",
+				Label:        "最大推理步数",
+				Type:         "number",
+				Description:  "达到上限后要求模型根据已有信息直接给出答案",
+				DefaultValue: DefaultReActMaxIterations,
+				Required:     false,
+				Validation: &models.ParamValidation{
+					Min: floatPtr(1),
+					Max: floatPtr(MaxReActIterations),
+				},
+			},
+			{
+				Name:         "token_budget",
+				Label:        "Token 预算",
+				Type:         "number",
+				Description:  "单次回答中推理过程可以消耗的Token总数",
+				DefaultValue: DefaultReActTokenBudget,
+				Required:     false,
+				Validation: &models.ParamValidation{
+					Min: floatPtr(1000),
+					Max: floatPtr(200000),
+				},
+			},
+		},
+		WorkflowDefinition: models.EinoWorkflowDefinition{
+			Nodes: []models.WorkflowNode{
+				{
+					ID:   "react",
+					Type: "chatmodel",
+					Config: map[string]interface{}{
+						"type":           "react",
+						"max_iterations": DefaultReActMaxIterations,
+						"token_budget":   DefaultReActTokenBudget,
+					},
+				},
+			},
+			Edges: []models.WorkflowEdge{},
+		},
+		Author:     "System",
+		Version:    "1.0.0",
+		IsBuiltIn:  true,
+		UsageCount: 0,
+	}
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
 }
 
 // GetAllTemplates 获取所有模板
@@ -465,6 +597,16 @@ func (s *TemplateService) CreateAgentFromTemplate(req models.AgentTemplateReques
 		WorkflowDefinition: template.WorkflowDefinition,
 		TemplateID:         template.ID,
 	}
+
+	// 知识检索类模板：从参数中读取知识库和检索分段数
+	if template.Category == "rag" {
+		agent.Retrieval = retrievalFromParams(req.Params)
+	}
+
+	// 推理决策类模板：步数上限和Token预算写入 react 节点的配置
+	if template.Category == "react" {
+		agent.WorkflowDefinition = reactDefinitionFromParams(template.WorkflowDefinition, req.Params)
+	}
 	
 	return agent, nil
 }
@@ -508,6 +650,44 @@ func (s *TemplateService) buildSystemPrompt(template *models.AgentTemplate, para
 	return systemPrompt
 }
 
+// reactDefinitionFromParams 复制工作流定义（节点配置与内置模板共享），并写入 ReAct 的限制参数
+func reactDefinitionFromParams(definition models.EinoWorkflowDefinition, params map[string]interface{}) models.EinoWorkflowDefinition {
+	nodes := make([]models.WorkflowNode, len(definition.Nodes))
+	for i, node := range definition.Nodes {
+		config := make(map[string]interface{}, len(node.Config))
+		for key, value := range node.Config {
+			config[key] = value
+		}
+		if config["type"] == "react" {
+			for _, name := range []string{"max_iterations", "token_budget"} {
+				if value, ok := params[name].(float64); ok && value > 0 {
+					config[name] = value
+				}
+			}
+		}
+		node.Config = config
+		nodes[i] = node
+	}
+	definition.Nodes = nodes
+	return definition
+}
+
+// retrievalFromParams 从模板参数中读取检索配置
+func retrievalFromParams(params map[string]interface{}) models.RetrievalConfig {
+	var retrieval models.RetrievalConfig
+	if ids, ok := params["knowledge_base_ids"].([]interface{}); ok {
+		for _, id := range ids {
+			if value, ok := id.(float64); ok && value > 0 {
+				retrieval.KnowledgeBaseIDs = append(retrieval.KnowledgeBaseIDs, uint(value))
+			}
+		}
+	}
+	if topK, ok := params["top_k"].(float64); ok {
+		retrieval.TopK = int(topK)
+	}
+	return retrieval
+}
+
 // applyPersonality 应用性格特点
 func (s *TemplateService) applyPersonality(prompt string, personality string) string {
 	switch personality {
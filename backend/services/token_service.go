@@ -7,25 +7,32 @@ import (
 	"ai-chat-backend/models"
 )
 
-// UpdateTokenUsage 更新Token使用统计
-func UpdateTokenUsage(userID uint, agentID uint, conversationID uint, inputTokens int, outputTokens int) error {
+// UpdateTokenUsage 更新Token使用统计，estimatedCost 为按模型定价计算的成本（见 PricingService.CalculateCost）
+func UpdateTokenUsage(userID uint, agentID uint, conversationID uint, inputTokens int, outputTokens int, estimatedCost float64) error {
+	return addTokenUsage(userID, &agentID, &conversationID, inputTokens, outputTokens, estimatedCost)
+}
+
+// addTokenUsage 累加当天的使用记录，agentID 为 nil 时记入不属于任何智能体的使用量（例如知识库索引的 embeddings 请求）
+func addTokenUsage(userID uint, agentID *uint, conversationID *uint, inputTokens int, outputTokens int, estimatedCost float64) error {
 	today := time.Now().Format("2006-01-02")
 	date, _ := time.Parse("2006-01-02", today)
 
-	// 估算成本（这里使用简单的估算，实际应该根据不同模型的定价）
-	// OpenRouter的平均价格：输入$0.0001/1K tokens，输出$0.0002/1K tokens
-	estimatedCost := (float64(inputTokens) * 0.0001 / 1000) + (float64(outputTokens) * 0.0002 / 1000)
-
 	// 查找或创建当天的使用记录
+	query := database.DB.Where("user_id = ? AND date = ?", userID, date)
+	if agentID != nil {
+		query = query.Where("agent_id = ?", *agentID)
+	} else {
+		query = query.Where("agent_id IS NULL")
+	}
 	var usage models.TokenUsage
-	result := database.DB.Where("user_id = ? AND agent_id = ? AND date = ?", userID, agentID, date).First(&usage)
+	result := query.First(&usage)
 
 	if result.Error != nil {
 		// 创建新记录
 		usage = models.TokenUsage{
 			UserID:         userID,
-			AgentID:        &agentID,
-			ConversationID: &conversationID,
+			AgentID:        agentID,
+			ConversationID: conversationID,
 			Date:           date,
 			InputTokens:    inputTokens,
 			OutputTokens:   outputTokens,
@@ -40,4 +47,3 @@ func UpdateTokenUsage(userID uint, agentID uint, conversationID uint, inputToken
 		return database.DB.Save(&usage).Error
 	}
 }
-
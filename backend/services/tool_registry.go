@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Tool 可供模型调用的工具
+type Tool interface {
+	// Name 工具名称（与 Agent.Tools 中的名称对应）
+	Name() string
+	// Description 工具描述，供模型判断何时调用
+	Description() string
+	// Parameters 参数的 JSON Schema
+	Parameters() map[string]interface{}
+	// Execute 执行工具，arguments 为模型生成的 JSON 参数
+	Execute(ctx context.Context, arguments string) (string, error)
+}
+
+// ToolRegistry 工具注册表
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// DefaultToolRegistry 默认工具注册表（包含内置工具）
+var DefaultToolRegistry = newDefaultToolRegistry()
+
+// NewToolRegistry 创建空的工具注册表
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]Tool),
+	}
+}
+
+func newDefaultToolRegistry() *ToolRegistry {
+	registry := NewToolRegistry()
+	registry.Register(&calculatorTool{})
+	registry.Register(&currentTimeTool{})
+	return registry
+}
+
+// Register 注册工具，同名工具会被覆盖
+func (r *ToolRegistry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[tool.Name()] = tool
+}
+
+// Get 根据名称获取工具
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Names 获取所有已注册的工具名称
+func (r *ToolRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Definitions 生成 OpenAI 格式的 tools 定义，未注册的工具会被忽略
+func (r *ToolRegistry) Definitions(names []string) []map[string]interface{} {
+	definitions := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		tool, ok := r.Get(name)
+		if !ok {
+			continue
+		}
+		definitions = append(definitions, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name(),
+				"description": tool.Description(),
+				"parameters":  tool.Parameters(),
+			},
+		})
+	}
+	return definitions
+}
+
+// Execute 执行指定工具
+func (r *ToolRegistry) Execute(ctx context.Context, name string, arguments string) (string, error) {
+	tool, ok := r.Get(name)
+	if !ok {
+		return "", fmt.Errorf("工具不存在: %s", name)
+	}
+	return tool.Execute(ctx, arguments)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// VectorMatch 检索命中的分段及其余弦相似度
+type VectorMatch struct {
+	ChunkID uint
+	Score   float64
+}
+
+// VectorIndex 向量索引：分段（内容和向量）始终保存在 knowledge_chunks 表中，
+// 索引负责相似度检索；接入 ANN 索引或外部向量数据库时实现该接口并替换 DefaultVectorIndex
+type VectorIndex interface {
+	// Index 为新写入的分段建立索引
+	Index(ctx context.Context, chunks []models.KnowledgeChunk) error
+	// Remove 从索引中移除分段
+	Remove(ctx context.Context, chunkIDs []uint) error
+	// Search 在知识库中检索与 query 最相似的 topK 个分段，按相似度从高到低排列
+	Search(ctx context.Context, knowledgeBaseIDs []uint, query models.Vector, topK int) ([]VectorMatch, error)
+}
+
+// DefaultVectorIndex 默认的向量索引
+var DefaultVectorIndex VectorIndex = &MySQLVectorIndex{batchSize: 1000}
+
+// MySQLVectorIndex 直接读取 knowledge_chunks 表中的向量逐个计算余弦相似度（暴力检索），
+// 适合分段数在十万以内的知识库，不需要额外维护索引
+type MySQLVectorIndex struct {
+	batchSize int
+}
+
+func (idx *MySQLVectorIndex) Index(ctx context.Context, chunks []models.KnowledgeChunk) error {
+	return nil
+}
+
+func (idx *MySQLVectorIndex) Remove(ctx context.Context, chunkIDs []uint) error {
+	return nil
+}
+
+func (idx *MySQLVectorIndex) Search(ctx context.Context, knowledgeBaseIDs []uint, query models.Vector, topK int) ([]VectorMatch, error) {
+	if len(knowledgeBaseIDs) == 0 || topK <= 0 {
+		return nil, nil
+	}
+	queryNorm := vectorNorm(query)
+	if queryNorm == 0 {
+		return nil, nil
+	}
+
+	top := newTopMatches(topK)
+	var batch []models.KnowledgeChunk
+	err := database.DB.WithContext(ctx).Model(&models.KnowledgeChunk{}).
+		Select("id", "embedding").
+		Where("knowledge_base_id IN ?", knowledgeBaseIDs).
+		FindInBatches(&batch, idx.batchSize, func(tx *gorm.DB, _ int) error {
+			for _, chunk := range batch {
+				if score, ok := cosineSimilarity(query, queryNorm, chunk.Embedding); ok {
+					top.add(VectorMatch{ChunkID: chunk.ID, Score: score})
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return top.sorted(), nil
+}
+
+// cosineSimilarity 计算余弦相似度，维度不一致时返回 false
+func cosineSimilarity(query models.Vector, queryNorm float64, vector models.Vector) (float64, bool) {
+	if len(vector) != len(query) {
+		return 0, false
+	}
+	var dot float64
+	for i := range query {
+		dot += float64(query[i]) * float64(vector[i])
+	}
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return 0, false
+	}
+	return dot / (queryNorm * norm), true
+}
+
+func vectorNorm(vector models.Vector) float64 {
+	var sum float64
+	for _, value := range vector {
+		sum += float64(value) * float64(value)
+	}
+	return math.Sqrt(sum)
+}
+
+// topMatches 保留相似度最高的 k 个结果
+type topMatches struct {
+	k       int
+	matches []VectorMatch
+}
+
+func newTopMatches(k int) *topMatches {
+	return &topMatches{k: k, matches: make([]VectorMatch, 0, k+1)}
+}
+
+func (t *topMatches) add(match VectorMatch) {
+	if len(t.matches) == t.k && match.Score <= t.matches[len(t.matches)-1].Score {
+		return
+	}
+	pos := sort.Search(len(t.matches), func(i int) bool {
+		return t.matches[i].Score < match.Score
+	})
+	t.matches = append(t.matches, VectorMatch{})
+	copy(t.matches[pos+1:], t.matches[pos:])
+	t.matches[pos] = match
+	if len(t.matches) > t.k {
+		t.matches = t.matches[:t.k]
+	}
+}
+
+func (t *topMatches) sorted() []VectorMatch {
+	return t.matches
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ai-chat-backend/database"
+	"ai-chat-backend/models"
+
+	"github.com/cloudwego/eino/compose"
+)
+
+// WorkflowState 工作流节点之间传递的状态
+type WorkflowState struct {
+	Messages    []models.Message  // 对话消息（工作流输入）
+	Content     string            // 上一个节点的输出内容
+	NodeOutputs map[string]string // 各节点的输出，按节点ID索引
+}
+
+func init() {
+	// 多个前驱节点汇聚时合并状态
+	compose.RegisterValuesMergeFunc(mergeWorkflowStates)
+}
+
+// mergeWorkflowStates 合并多个分支的工作流状态
+func mergeWorkflowStates(states []*WorkflowState) (*WorkflowState, error) {
+	merged := &WorkflowState{NodeOutputs: make(map[string]string)}
+	var contents []string
+
+	for _, state := range states {
+		if state == nil {
+			continue
+		}
+		if merged.Messages == nil {
+			merged.Messages = state.Messages
+		}
+		if state.Content != "" {
+			contents = append(contents, state.Content)
+		}
+		for id, output := range state.NodeOutputs {
+			merged.NodeOutputs[id] = output
+		}
+	}
+
+	merged.Content = strings.Join(contents, "\n\n")
+	return merged, nil
+}
+
+// workflowRun 单次工作流执行的上下文（汇总 Token 使用量）
+type workflowRun struct {
+	agent        models.Agent
+	mu           sync.Mutex
+	inputTokens  int
+	outputTokens int
+
+	// 流式执行时使用
+	emit         func(StreamEvent)
+	streamNodeID string // 直接流式输出内容的节点
+	streamed     bool
+}
+
+func (r *workflowRun) addTokens(inputTokens, outputTokens int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inputTokens += inputTokens
+	r.outputTokens += outputTokens
+}
+
+// WorkflowExecutor 可视化工作流执行器，将工作流定义编译为 Eino 图并执行
+type WorkflowExecutor struct {
+	aiService *AIService
+	retriever *Retriever
+}
+
+// NewWorkflowExecutor 创建工作流执行器
+func NewWorkflowExecutor(aiService *AIService, retriever *Retriever) *WorkflowExecutor {
+	return &WorkflowExecutor{
+		aiService: aiService,
+		retriever: retriever,
+	}
+}
+
+// Execute 执行工作流，返回最终内容和汇总的 Token 使用量
+func (e *WorkflowExecutor) Execute(ctx context.Context, agent models.Agent, definition models.EinoWorkflowDefinition, messages []models.Message) (string, int, int, error) {
+	return e.run(ctx, &workflowRun{agent: agent}, definition, messages)
+}
+
+// ExecuteStream 流式执行工作流，通过 emit 发送节点进度和内容增量
+// 工作流只有一个产出最终内容的 ChatModel 节点时逐块输出，否则在结束时一次性输出
+func (e *WorkflowExecutor) ExecuteStream(ctx context.Context, agent models.Agent, definition models.EinoWorkflowDefinition, messages []models.Message, emit func(StreamEvent)) (string, int, int, error) {
+	run := &workflowRun{
+		agent:        agent,
+		emit:         emit,
+		streamNodeID: findStreamNode(definition),
+	}
+
+	content, inputTokens, outputTokens, err := e.run(ctx, run, definition, messages)
+	if err == nil && !run.streamed {
+		emit(StreamEvent{Type: StreamEventContent, Content: content})
+	}
+
+	return content, inputTokens, outputTokens, err
+}
+
+// run 编译并执行工作流
+func (e *WorkflowExecutor) run(ctx context.Context, run *workflowRun, definition models.EinoWorkflowDefinition, messages []models.Message) (string, int, int, error) {
+	runnable, err := e.compile(ctx, run, definition)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	input := &WorkflowState{
+		Messages:    messages,
+		NodeOutputs: make(map[string]string),
+	}
+
+	output, err := runnable.Invoke(ctx, input)
+	if err != nil {
+		return "", run.inputTokens, run.outputTokens, fmt.Errorf("工作流执行失败: %w", err)
+	}
+
+	if output == nil || output.Content == "" {
+		return "", run.inputTokens, run.outputTokens, errors.New("工作流未产生任何输出")
+	}
+
+	return output.Content, run.inputTokens, run.outputTokens, nil
+}
+
+// findStreamNode 查找唯一产出最终内容的 ChatModel 节点，不存在时返回空字符串
+func findStreamNode(definition models.EinoWorkflowDefinition) string {
+	nodeTypes := make(map[string]string, len(definition.Nodes))
+	for _, node := range definition.Nodes {
+		nodeTypes[node.ID] = node.Type
+	}
+
+	successors := make(map[string][]string)
+	predecessors := make(map[string][]string)
+	for _, edge := range definition.Edges {
+		successors[edge.Source] = append(successors[edge.Source], edge.Target)
+		predecessors[edge.Target] = append(predecessors[edge.Target], edge.Source)
+	}
+
+	// end 节点的内容来自其前驱
+	producers := make(map[string]bool)
+	for _, node := range definition.Nodes {
+		if node.Type == "end" {
+			for _, id := range predecessors[node.ID] {
+				producers[id] = true
+			}
+		}
+	}
+
+	if len(producers) != 1 {
+		return ""
+	}
+	for id := range producers {
+		if nodeTypes[id] != "chatmodel" {
+			return ""
+		}
+		// 该节点的所有后继都必须是 end 节点，否则其输出不是最终内容
+		for _, next := range successors[id] {
+			if nodeTypes[next] != "end" {
+				return ""
+			}
+		}
+		return id
+	}
+	return ""
+}
+
+// compile 将工作流定义编译为 Eino 图
+func (e *WorkflowExecutor) compile(ctx context.Context, run *workflowRun, definition models.EinoWorkflowDefinition) (compose.Runnable[*WorkflowState, *WorkflowState], error) {
+	graph := compose.NewGraph[*WorkflowState, *WorkflowState]()
+
+	for _, node := range definition.Nodes {
+		node := node
+		lambda := compose.InvokableLambda(func(ctx context.Context, state *WorkflowState) (*WorkflowState, error) {
+			return e.executeNode(ctx, run, node, state)
+		})
+		if err := graph.AddLambdaNode(graphNodeKey(node.ID), lambda, compose.WithNodeName(node.ID)); err != nil {
+			return nil, fmt.Errorf("添加节点 %s 失败: %w", node.ID, err)
+		}
+	}
+
+	for _, edge := range definition.Edges {
+		if err := graph.AddEdge(graphNodeKey(edge.Source), graphNodeKey(edge.Target)); err != nil {
+			return nil, fmt.Errorf("添加连接 %s -> %s 失败: %w", edge.Source, edge.Target, err)
+		}
+	}
+
+	// 只有 start 节点连接图的入口、end 节点连接图的出口（ValidateWorkflowDefinition 保证其余节点都在两者之间）
+	for _, node := range definition.Nodes {
+		switch node.Type {
+		case "start":
+			if err := graph.AddEdge(compose.START, graphNodeKey(node.ID)); err != nil {
+				return nil, fmt.Errorf("连接开始节点 %s 失败: %w", node.ID, err)
+			}
+		case "end":
+			if err := graph.AddEdge(graphNodeKey(node.ID), compose.END); err != nil {
+				return nil, fmt.Errorf("连接结束节点 %s 失败: %w", node.ID, err)
+			}
+		}
+	}
+
+	runnable, err := graph.Compile(ctx, compose.WithNodeTriggerMode(compose.AllPredecessor))
+	if err != nil {
+		return nil, fmt.Errorf("工作流编译失败: %w", err)
+	}
+
+	return runnable, nil
+}
+
+// executeNode 根据节点类型执行单个节点
+func (e *WorkflowExecutor) executeNode(ctx context.Context, run *workflowRun, node models.WorkflowNode, state *WorkflowState) (*WorkflowState, error) {
+	if node.Type == "start" || node.Type == "end" {
+		return state, nil
+	}
+
+	if run.emit != nil {
+		run.emit(StreamEvent{Type: StreamEventNodeStart, NodeID: node.ID, NodeType: node.Type})
+	}
+
+	output, err := e.executeNodeOutput(ctx, run, node, state)
+
+	if run.emit != nil {
+		event := StreamEvent{Type: StreamEventNodeEnd, NodeID: node.ID, NodeType: node.Type}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		run.emit(event)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("节点 %s 执行失败: %w", node.ID, err)
+	}
+
+	next := &WorkflowState{
+		Messages:    state.Messages,
+		Content:     output,
+		NodeOutputs: make(map[string]string, len(state.NodeOutputs)+1),
+	}
+	for id, value := range state.NodeOutputs {
+		next.NodeOutputs[id] = value
+	}
+	next.NodeOutputs[node.ID] = output
+
+	return next, nil
+}
+
+// executeNodeOutput 执行节点并返回其输出内容
+func (e *WorkflowExecutor) executeNodeOutput(ctx context.Context, run *workflowRun, node models.WorkflowNode, state *WorkflowState) (string, error) {
+	var output string
+	var err error
+
+	switch node.Type {
+	case "chatmodel":
+		output, err = e.executeChatModelNode(ctx, run, node, state)
+	case "tool":
+		output, err = e.executeToolNode(ctx, node, state)
+	case "lambda":
+		if configString(node.Config, "code") != "" {
+			err = errors.New("Lambda 节点暂不支持执行自定义代码")
+		} else {
+			output = state.Content
+		}
+	case "retriever":
+		output, err = e.executeRetrieverNode(ctx, run, node, state)
+	default:
+		err = fmt.Errorf("不支持的节点类型: %s", node.Type)
+	}
+
+	return output, err
+}
+
+// executeChatModelNode 执行 ChatModel 节点
+func (e *WorkflowExecutor) executeChatModelNode(ctx context.Context, run *workflowRun, node models.WorkflowNode, state *WorkflowState) (string, error) {
+	agent, err := e.nodeAgent(run.agent, node)
+	if err != nil {
+		return "", err
+	}
+
+	messages := make([]models.Message, 0, len(state.Messages)+1)
+	messages = append(messages, state.Messages...)
+
+	// prompt 配置支持 {{input}} 占位符，否则将上一个节点的输出作为新的用户输入
+	if prompt := configString(node.Config, "prompt"); prompt != "" {
+		input := state.Content
+		if input == "" {
+			input = lastUserContent(state.Messages)
+		}
+		messages = append(messages, models.Message{
+			Role:    models.RoleUser,
+			Content: strings.ReplaceAll(prompt, "{{input}}", input),
+		})
+	} else if state.Content != "" {
+		messages = append(messages, models.Message{
+			Role:    models.RoleUser,
+			Content: state.Content,
+		})
+	}
+
+	var content string
+	var inputTokens, outputTokens int
+	if run.emit != nil && node.ID == run.streamNodeID && len(e.aiService.tools.Definitions(agent.Tools)) == 0 {
+		run.streamed = true
+		content, inputTokens, outputTokens, err = e.aiService.StreamChat(ctx, agent, messages, func(chunk string) {
+			run.emit(StreamEvent{Type: StreamEventContent, Content: chunk})
+		})
+	} else {
+		content, inputTokens, outputTokens, err = e.aiService.Chat(ctx, agent, messages)
+	}
+	run.addTokens(inputTokens, outputTokens)
+	if err != nil {
+		return "", err
+	}
+
+	return content, nil
+}
+
+// executeRetrieverNode 执行检索节点：以上一个节点的输出（或最后一条用户消息）检索知识库，
+// 输出参考资料和原始问题；knowledge_base_ids、top_k、min_score 未配置时沿用智能体的检索配置
+func (e *WorkflowExecutor) executeRetrieverNode(ctx context.Context, run *workflowRun, node models.WorkflowNode, state *WorkflowState) (string, error) {
+	config := run.agent.Retrieval
+	if ids := configUintList(node.Config, "knowledge_base_ids"); len(ids) > 0 {
+		config.KnowledgeBaseIDs = ids
+	}
+	if topK, ok := configFloat(node.Config, "top_k"); ok {
+		config.TopK = int(topK)
+	}
+	if minScore, ok := configFloat(node.Config, "min_score"); ok {
+		config.MinScore = minScore
+	}
+	if len(config.KnowledgeBaseIDs) == 0 {
+		return "", errors.New("检索节点未配置知识库")
+	}
+
+	query := state.Content
+	if query == "" {
+		query = lastUserContent(state.Messages)
+	}
+	references, err := e.retriever.Retrieve(withUsageAgent(ctx, run.agent.ID), run.agent.UserID, config, query)
+	if err != nil || references == "" {
+		return query, err
+	}
+	return references + "\n问题：" + query, nil
+}
+
+// executeToolNode 执行工具节点，parameters 配置中的 {{input}} 会替换为上一个节点的输出
+func (e *WorkflowExecutor) executeToolNode(ctx context.Context, node models.WorkflowNode, state *WorkflowState) (string, error) {
+	toolName := configString(node.Config, "tool_name")
+	if toolName == "" {
+		return "", errors.New("未配置工具名称")
+	}
+	tool, ok := e.aiService.tools.Get(toolName)
+	if !ok {
+		return "", fmt.Errorf("工具不存在: %s", toolName)
+	}
+
+	input := state.Content
+	if input == "" {
+		input = lastUserContent(state.Messages)
+	}
+
+	// 未配置参数时，将输入作为工具的第一个必填参数
+	arguments := configString(node.Config, "parameters")
+	if arguments == "" {
+		paramName := "input"
+		if required, ok := tool.Parameters()["required"].([]string); ok && len(required) > 0 {
+			paramName = required[0]
+		}
+		arguments = fmt.Sprintf(`{%q: "{{input}}"}`, paramName)
+	}
+	encodedInput, _ := json.Marshal(input)
+	arguments = strings.ReplaceAll(arguments, "{{input}}", strings.Trim(string(encodedInput), `"`))
+	if !json.Valid([]byte(arguments)) {
+		return "", fmt.Errorf("工具参数不是有效的JSON: %s", arguments)
+	}
+
+	return tool.Execute(ctx, arguments)
+}
+
+// nodeAgent 根据节点配置覆盖 Agent 的模型参数
+func (e *WorkflowExecutor) nodeAgent(base models.Agent, node models.WorkflowNode) (models.Agent, error) {
+	agent := base
+
+	if modelName := configString(node.Config, "model_name"); modelName != "" {
+		agent.ModelName = modelName
+	}
+	if systemPrompt := configString(node.Config, "system_prompt"); systemPrompt != "" {
+		agent.SystemPrompt = systemPrompt
+	}
+	if temperature, ok := configFloat(node.Config, "temperature"); ok {
+		agent.ModelParams.Temperature = temperature
+	}
+	if maxTokens, ok := configFloat(node.Config, "max_tokens"); ok {
+		agent.ModelParams.MaxTokens = int(maxTokens)
+	}
+
+	// 节点指定了 API 配置时，必须属于 Agent 的创建者
+	if configID, ok := configFloat(node.Config, "api_config_id"); ok && configID > 0 {
+		var apiConfig models.APIConfig
+		if err := database.DB.Where("id = ? AND user_id = ?", uint(configID), base.UserID).First(&apiConfig).Error; err != nil {
+			return agent, fmt.Errorf("节点 %s 的API配置不存在或无权访问", node.ID)
+		}
+		agent.APIConfigID = &apiConfig.ID
+		agent.APIConfig = &apiConfig
+	}
+
+	return agent, nil
+}
+
+// graphNodeKey 生成图中的节点键，避免与 Eino 保留的 start/end 节点冲突
+func graphNodeKey(id string) string {
+	return "node:" + id
+}
+
+// lastUserContent 获取最后一条用户消息的内容
+func lastUserContent(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == models.RoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// configString 读取节点配置中的字符串
+func configString(config map[string]interface{}, key string) string {
+	switch v := config[key].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// configUintList 读取节点配置中的 ID 列表
+func configUintList(config map[string]interface{}, key string) []uint {
+	values, _ := config[key].([]interface{})
+	ids := make([]uint, 0, len(values))
+	for _, value := range values {
+		if id, ok := configFloat(map[string]interface{}{"id": value}, "id"); ok && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// configFloat 读取节点配置中的数值（兼容前端以字符串形式提交的数字）
+func configFloat(config map[string]interface{}, key string) (float64, bool) {
+	switch v := config[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	default:
+		return 0, false
+	}
+}
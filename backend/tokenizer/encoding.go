@@ -0,0 +1,154 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// Encoding BPE 编码
+type Encoding struct {
+	name     string
+	ranks    map[string]int
+	split    func(text string) []string
+	fallback bool // 词表不可用时使用估算
+}
+
+// Name 编码名称
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Exact 是否加载了词表（否则 Count 为估算值）
+func (e *Encoding) Exact() bool {
+	return !e.fallback
+}
+
+// Encode 将文本编码为 Token ID 列表，词表不可用时返回 nil
+func (e *Encoding) Encode(text string) []int {
+	if e.fallback {
+		return nil
+	}
+
+	tokens := make([]int, 0, len(text)/3+1)
+	for _, piece := range e.split(text) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, e.bytePairEncode([]byte(piece))...)
+	}
+	return tokens
+}
+
+// Count 计算文本的 Token 数量
+func (e *Encoding) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	if e.fallback {
+		return e.estimate(text)
+	}
+	return len(e.Encode(text))
+}
+
+// bytePairEncode 对单个片段做 BPE 合并：反复合并相邻字节对中 rank 最小的一对
+func (e *Encoding) bytePairEncode(piece []byte) []int {
+	if len(piece) == 1 {
+		return []int{e.ranks[string(piece)]}
+	}
+
+	// parts[i] 为第 i 个子串的起始位置，最后一个元素为片段长度
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+
+	pairRank := func(i int) int {
+		if i+2 >= len(parts) {
+			return math.MaxInt
+		}
+		if rank, ok := e.ranks[string(piece[parts[i]:parts[i+2]])]; ok {
+			return rank
+		}
+		return math.MaxInt
+	}
+
+	ranks := make([]int, len(parts)-1)
+	for i := range ranks {
+		ranks[i] = pairRank(i)
+	}
+
+	for len(parts) > 2 {
+		minRank, minIndex := math.MaxInt, -1
+		for i := 0; i < len(ranks)-1; i++ {
+			if ranks[i] < minRank {
+				minRank, minIndex = ranks[i], i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+
+		// 合并 minIndex 与 minIndex+1 两个子串
+		parts = append(parts[:minIndex+1], parts[minIndex+2:]...)
+		ranks = append(ranks[:minIndex+1], ranks[minIndex+2:]...)
+		ranks[minIndex] = pairRank(minIndex)
+		if minIndex > 0 {
+			ranks[minIndex-1] = pairRank(minIndex - 1)
+		}
+	}
+
+	tokens := make([]int, 0, len(parts)-1)
+	for i := 0; i < len(parts)-1; i++ {
+		tokens = append(tokens, e.ranks[string(piece[parts[i]:parts[i+1]])])
+	}
+	return tokens
+}
+
+// estimate 词表不可用时的估算：按预分词片段计算，CJK 字符约 1 Token/字，其余约 4 字节/Token
+func (e *Encoding) estimate(text string) int {
+	count := 0
+	for _, piece := range e.split(text) {
+		otherBytes := 0
+		for _, c := range piece {
+			if unicode.Is(unicode.Han, c) || unicode.Is(unicode.Hiragana, c) || unicode.Is(unicode.Katakana, c) || unicode.Is(unicode.Hangul, c) {
+				count++
+			} else {
+				otherBytes += len(string(c))
+			}
+		}
+		count += (otherBytes + 3) / 4
+	}
+	return count
+}
+
+// parseRanks 解析 tiktoken 词表文件（每行：base64 编码的 Token 和 rank）
+func parseRanks(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int, 200000)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		fields := bytes.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("词表第 %d 行格式错误", lineNo)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("词表第 %d 行解码失败: %w", lineNo, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("词表第 %d 行 rank 无效: %w", lineNo, err)
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, scanner.Err()
+}
@@ -0,0 +1,227 @@
+package tokenizer
+
+import "unicode"
+
+// 预分词：按照 tiktoken 的正则规则将文本切分为片段，再对每个片段做 BPE 合并
+// Go 的 regexp 不支持 (?!\S) 这类前瞻断言，因此这里手工实现与正则等价的匹配逻辑
+
+// splitCL100K cl100k_base 的预分词规则：
+// (?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitCL100K(text string) []string {
+	return splitWith([]rune(text), func(r []rune, i int) int {
+		if end := matchContraction(r, i); end > i {
+			return end
+		}
+		if end := matchLetters(r, i); end > i {
+			return end
+		}
+		if end := matchNumbers(r, i); end > i {
+			return end
+		}
+		if end := matchPunctuation(r, i, false); end > i {
+			return end
+		}
+		return matchWhitespace(r, i)
+	})
+}
+
+// splitO200K o200k_base 的预分词规则：
+// [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+// [^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+// \p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitO200K(text string) []string {
+	return splitWith([]rune(text), func(r []rune, i int) int {
+		if end := matchCasedWord(r, i, true); end > i {
+			return end
+		}
+		if end := matchCasedWord(r, i, false); end > i {
+			return end
+		}
+		if end := matchNumbers(r, i); end > i {
+			return end
+		}
+		if end := matchPunctuation(r, i, true); end > i {
+			return end
+		}
+		return matchWhitespace(r, i)
+	})
+}
+
+// splitWith 依次匹配片段，match 返回从 i 开始的片段结束位置
+func splitWith(r []rune, match func(r []rune, i int) int) []string {
+	pieces := make([]string, 0, len(r)/3+1)
+	for i := 0; i < len(r); {
+		end := match(r, i)
+		if end <= i {
+			end = i + 1
+		}
+		pieces = append(pieces, string(r[i:end]))
+		i = end
+	}
+	return pieces
+}
+
+func isLetter(c rune) bool {
+	return unicode.IsLetter(c)
+}
+
+func isNumber(c rune) bool {
+	return unicode.IsNumber(c)
+}
+
+func isSpace(c rune) bool {
+	return unicode.IsSpace(c)
+}
+
+func isNewline(c rune) bool {
+	return c == '\r' || c == '\n'
+}
+
+// isPrefix [^\r\n\p{L}\p{N}]
+func isPrefix(c rune) bool {
+	return !isNewline(c) && !isLetter(c) && !isNumber(c)
+}
+
+// isUpperLike [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]
+func isUpperLike(c rune) bool {
+	return unicode.In(c, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// isLowerLike [\p{Ll}\p{Lm}\p{Lo}\p{M}]
+func isLowerLike(c rune) bool {
+	return unicode.In(c, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// runEnd 返回从 i 开始满足 pred 的连续字符的结束位置
+func runEnd(r []rune, i int, pred func(rune) bool) int {
+	for i < len(r) && pred(r[i]) {
+		i++
+	}
+	return i
+}
+
+// matchContraction (?i:'s|'t|'re|'ve|'m|'ll|'d)
+func matchContraction(r []rune, i int) int {
+	if i+1 >= len(r) || r[i] != '\'' {
+		return i
+	}
+	switch unicode.ToLower(r[i+1]) {
+	case 's', 't', 'm', 'd':
+		return i + 2
+	}
+	if i+2 < len(r) {
+		pair := string([]rune{unicode.ToLower(r[i+1]), unicode.ToLower(r[i+2])})
+		switch pair {
+		case "re", "ve", "ll":
+			return i + 3
+		}
+	}
+	return i
+}
+
+// matchLetters [^\r\n\p{L}\p{N}]?\p{L}+
+func matchLetters(r []rune, i int) int {
+	if isPrefix(r[i]) && i+1 < len(r) && isLetter(r[i+1]) {
+		return runEnd(r, i+1, isLetter)
+	}
+	return runEnd(r, i, isLetter)
+}
+
+// matchCasedWord o200k 的单词规则，lowerTail 为 true 时对应第一条（大写* 小写+），否则对应第二条（大写+ 小写*）
+func matchCasedWord(r []rune, i int, lowerTail bool) int {
+	match := func(start int) int {
+		var end int
+		if lowerTail {
+			end = matchUpperLower(r, start)
+		} else {
+			upperEnd := runEnd(r, start, isUpperLike)
+			if upperEnd == start {
+				return -1
+			}
+			end = runEnd(r, upperEnd, isLowerLike)
+		}
+		if end < 0 {
+			return -1
+		}
+		if contractionEnd := matchContraction(r, end); contractionEnd > end {
+			end = contractionEnd
+		}
+		return end
+	}
+
+	// 可选前缀优先尝试匹配，失败后回溯为不带前缀
+	if isPrefix(r[i]) && i+1 < len(r) {
+		if end := match(i + 1); end > i+1 {
+			return end
+		}
+	}
+	if end := match(i); end > i {
+		return end
+	}
+	return i
+}
+
+// matchUpperLower [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+，模拟正则的贪婪回溯
+func matchUpperLower(r []rune, start int) int {
+	upperEnd := runEnd(r, start, isUpperLike)
+	if upperEnd < len(r) && isLowerLike(r[upperEnd]) {
+		return runEnd(r, upperEnd, isLowerLike)
+	}
+	// 回溯：大写部分让出最后一个同时属于小写类的字符
+	for k := upperEnd - 1; k >= start; k-- {
+		if isLowerLike(r[k]) {
+			return k + 1
+		}
+	}
+	return -1
+}
+
+// matchNumbers \p{N}{1,3}
+func matchNumbers(r []rune, i int) int {
+	end := i
+	for end < len(r) && end-i < 3 && isNumber(r[end]) {
+		end++
+	}
+	return end
+}
+
+// matchPunctuation  ?[^\s\p{L}\p{N}]+[\r\n]*（o200k 的结尾字符集额外包含 /）
+func matchPunctuation(r []rune, i int, withSlash bool) int {
+	isPunct := func(c rune) bool {
+		return !isSpace(c) && !isLetter(c) && !isNumber(c)
+	}
+
+	start := i
+	if r[i] == ' ' && i+1 < len(r) && isPunct(r[i+1]) {
+		start = i + 1
+	}
+	end := runEnd(r, start, isPunct)
+	if end == start {
+		return i
+	}
+	return runEnd(r, end, func(c rune) bool {
+		return isNewline(c) || (withSlash && c == '/')
+	})
+}
+
+// matchWhitespace \s*[\r\n]+|\s+(?!\S)|\s+
+func matchWhitespace(r []rune, i int) int {
+	end := runEnd(r, i, isSpace)
+	if end == i {
+		return i
+	}
+
+	// \s*[\r\n]+：匹配到空白段中最后一个换行符为止
+	for k := end - 1; k >= i; k-- {
+		if isNewline(r[k]) {
+			return k + 1
+		}
+	}
+
+	// \s+(?!\S)：后面紧跟非空白字符时，留下最后一个空白给下一个片段
+	if end < len(r) && end-i > 1 {
+		return end - 1
+	}
+
+	return end
+}
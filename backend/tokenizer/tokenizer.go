@@ -0,0 +1,131 @@
+// Package tokenizer 实现 tiktoken 兼容的 BPE 分词（cl100k_base / o200k_base），用于计算 Token 数量
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+const (
+	CL100KBase = "cl100k_base"
+	O200KBase  = "o200k_base"
+)
+
+// 词表文件不随仓库提交，通过 go generate 下载并校验 ranks/SHA256SUMS 中固定的校验和
+//go:generate go run ../cmd/tokenizer-ranks ranks
+
+//go:embed ranks
+var rankFiles embed.FS
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*Encoding)
+)
+
+// splitters 各编码的预分词规则
+var splitters = map[string]func(string) []string{
+	CL100KBase: splitCL100K,
+	O200KBase:  splitO200K,
+}
+
+// GetEncoding 获取指定名称的编码（词表首次使用时加载）
+func GetEncoding(name string) (*Encoding, error) {
+	split, ok := splitters[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的编码: %s", name)
+	}
+
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if encoding, ok := encodings[name]; ok {
+		return encoding, nil
+	}
+
+	encoding := &Encoding{name: name, split: split}
+	data, err := readRankFile(name + ".tiktoken")
+	if err == nil {
+		encoding.ranks, err = parseRanks(data)
+	}
+	if err != nil {
+		log.Printf("Warning: tokenizer ranks for %s unavailable, falling back to estimation: %v", name, err)
+		encoding.ranks = nil
+		encoding.fallback = true
+	}
+
+	encodings[name] = encoding
+	return encoding, nil
+}
+
+// readRankFile 读取嵌入的词表文件，并与 SHA256SUMS 中的校验和比对
+func readRankFile(fileName string) ([]byte, error) {
+	data, err := rankFiles.ReadFile("ranks/" + fileName)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := rankFiles.ReadFile("ranks/SHA256SUMS")
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	for _, line := range strings.Split(string(sums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == fileName {
+			if !strings.EqualFold(fields[0], actual) {
+				return nil, fmt.Errorf("%s 校验和不匹配: %s", fileName, actual)
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("SHA256SUMS 中没有 %s 的校验和", fileName)
+}
+
+// EncodingNameForModel 根据模型名称选择编码，未知模型使用 cl100k_base 近似
+func EncodingNameForModel(modelName string) string {
+	name := strings.ToLower(modelName)
+	// 去掉 OpenRouter 等平台的服务商前缀，例如 openai/gpt-4o
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	o200kPrefixes := []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4", "chatgpt-4o"}
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return O200KBase
+		}
+	}
+
+	return CL100KBase
+}
+
+// ForModel 获取模型对应的编码
+func ForModel(modelName string) *Encoding {
+	// 编码名称来自内置列表，不会出错
+	encoding, _ := GetEncoding(EncodingNameForModel(modelName))
+	return encoding
+}
+
+// CountTokens 按模型对应的编码计算文本的 Token 数量
+func CountTokens(modelName string, text string) int {
+	return ForModel(modelName).Count(text)
+}
+
+// CountMessageTokens 估算一组对话消息的 Token 数量（包含每条消息的格式开销）
+func CountMessageTokens(modelName string, contents []string) int {
+	const tokensPerMessage = 3 // <|start|>{role}\n{content}<|end|>\n
+	const replyPriming = 3     // 回复以 <|start|>assistant<|message|> 开头
+
+	encoding := ForModel(modelName)
+	total := replyPriming
+	for _, content := range contents {
+		total += tokensPerMessage + encoding.Count(content)
+	}
+	return total
+}
@@ -0,0 +1,95 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 期望的 Token ID 取自 tiktoken 对同一文本的编码结果；词表必须已下载（make test 会先下载）
+func TestEncodeGolden(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []int
+	}{
+		{CL100KBase, "hello world", []int{15339, 1917}},
+		{CL100KBase, "tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{CL100KBase, "2 + 2 = 4", []int{17, 489, 220, 17, 284, 220, 19}},
+		{CL100KBase, "antidisestablishmentarianism", []int{519, 85342, 34500, 479, 8997, 2191}},
+		{CL100KBase, "お誕生日おめでとう", []int{33334, 45918, 243, 21990, 9080, 33334, 62004, 16556, 78699}},
+		{CL100KBase, "👍", []int{9468, 239, 235}},
+		{CL100KBase, "rer", []int{38149}},
+		{CL100KBase, "'rer", []int{2351, 81}},
+		{CL100KBase, "today\n ", []int{31213, 198, 220}},
+		{CL100KBase, "today\n \n", []int{31213, 27907}},
+		{CL100KBase, "today\n  \n", []int{31213, 14211}},
+		{O200KBase, "hello world", []int{24912, 2375}},
+		{O200KBase, "2 + 2 = 4", []int{17, 659, 220, 17, 314, 220, 19}},
+		{O200KBase, "antidisestablishmentarianism", []int{493, 129901, 376, 160388, 21203}},
+		{O200KBase, "お誕生日おめでとう", []int{8930, 9697, 243, 128225, 8930, 17693, 4344, 48669}},
+	}
+
+	for _, tt := range tests {
+		encoding, err := GetEncoding(tt.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !encoding.Exact() {
+			t.Fatalf("%s 词表未嵌入或校验失败，先运行 make tokenizer-ranks（go generate ./tokenizer）", tt.encoding)
+		}
+
+		got := encoding.Encode(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s Encode(%q) = %v, want %v", tt.encoding, tt.text, got, tt.want)
+		}
+		if count := encoding.Count(tt.text); count != len(tt.want) {
+			t.Errorf("%s Count(%q) = %d, want %d", tt.encoding, tt.text, count, len(tt.want))
+		}
+	}
+}
+
+// 期望的片段按 tiktoken 的预分词正则推导
+func TestSplitCL100K(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"I'm don't", []string{"I", "'m", " don", "'t"}},
+		{"I'M", []string{"I", "'M"}},
+		{"'rer", []string{"'re", "r"}},
+		{"12345", []string{"123", "45"}},
+		{"hello   world", []string{"hello", "  ", " world"}},
+		{"today\n ", []string{"today", "\n", " "}},
+		{"today\n  \n", []string{"today", "\n  \n"}},
+		{"你好，世界！", []string{"你好", "，世界", "！"}},
+		{"👍👍 ok", []string{"👍👍", " ok"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCL100K(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCL100K(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitO200K(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"HelloWorld", []string{"Hello", "World"}},
+		{"I'm don't", []string{"I'm", " don't"}},
+		{"1234567", []string{"123", "456", "7"}},
+		{"hello   world", []string{"hello", "  ", " world"}},
+		{"你好，世界", []string{"你好", "，世界"}},
+		{"👍👍 ok", []string{"👍👍", " ok"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitO200K(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitO200K(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
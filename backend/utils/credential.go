@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"ai-chat-backend/config"
+)
+
+// 凭证采用信封加密：每条记录生成随机数据密钥（DEK）用 AES-GCM 加密凭证，
+// DEK 再用配置中的主密钥（KEK）加密，和主密钥 ID 一起保存在密文中：
+// enc:v1:<主密钥ID>:<base64(加密后的DEK)>:<base64(加密后的凭证)>
+const credentialPrefix = "enc:v1:"
+
+var (
+	credentialKeysOnce sync.Once
+	credentialKeys     map[string][]byte
+	activeKeyID        string
+	credentialKeysErr  error
+)
+
+// InitCredentialKeys 加载凭证加密主密钥，启动时调用以便尽早发现配置错误
+func InitCredentialKeys() error {
+	credentialKeysOnce.Do(func() {
+		credentialKeys, activeKeyID, credentialKeysErr = loadCredentialKeys(config.AppConfig)
+	})
+	return credentialKeysErr
+}
+
+// loadCredentialKeys 解析 CREDENTIAL_MASTER_KEYS（格式：id1:base64密钥,id2:base64密钥）
+func loadCredentialKeys(cfg *config.Config) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	var firstID string
+
+	for _, entry := range strings.Split(cfg.CredentialMasterKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, "", fmt.Errorf("主密钥配置格式错误，应为 id:base64密钥")
+		}
+		if strings.Contains(parts[0], ":") {
+			return nil, "", fmt.Errorf("主密钥 ID 不能包含冒号: %s", parts[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, "", fmt.Errorf("主密钥 %s 不是有效的 base64: %w", parts[0], err)
+		}
+		if len(key) != 32 {
+			return nil, "", fmt.Errorf("主密钥 %s 长度必须为 32 字节（AES-256）", parts[0])
+		}
+		keys[parts[0]] = key
+		if firstID == "" {
+			firstID = parts[0]
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, "", errors.New("未配置 CREDENTIAL_MASTER_KEYS")
+	}
+
+	activeID := cfg.CredentialActiveKeyID
+	if activeID == "" {
+		activeID = firstID
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, "", fmt.Errorf("当前主密钥 %s 不在 CREDENTIAL_MASTER_KEYS 中", activeID)
+	}
+
+	return keys, activeID, nil
+}
+
+// ActiveCredentialKeyID 当前用于加密的主密钥 ID
+func ActiveCredentialKeyID() (string, error) {
+	if err := InitCredentialKeys(); err != nil {
+		return "", err
+	}
+	return activeKeyID, nil
+}
+
+// IsEncryptedCredential 判断凭证是否已加密
+func IsEncryptedCredential(value string) bool {
+	return strings.HasPrefix(value, credentialPrefix)
+}
+
+// CredentialKeyID 返回密文使用的主密钥 ID，未加密时返回空字符串
+func CredentialKeyID(value string) string {
+	if !IsEncryptedCredential(value) {
+		return ""
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(value, credentialPrefix), ":")
+	return keyID
+}
+
+// EncryptCredential 使用当前主密钥加密凭证
+func EncryptCredential(plaintext string) (string, error) {
+	if err := InitCredentialKeys(); err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := sealAESGCM(credentialKeys[activeKeyID], dataKey, []byte(activeKeyID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return credentialPrefix + activeKeyID + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptCredential 解密凭证；未加密的旧数据原样返回（迁移完成前兼容）
+func DecryptCredential(value string) (string, error) {
+	if !IsEncryptedCredential(value) {
+		return value, nil
+	}
+	if err := InitCredentialKeys(); err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, credentialPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("凭证密文格式错误")
+	}
+	keyID := parts[0]
+	masterKey, ok := credentialKeys[keyID]
+	if !ok {
+		return "", fmt.Errorf("凭证使用的主密钥 %s 未配置", keyID)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("凭证密文格式错误")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("凭证密文格式错误")
+	}
+
+	dataKey, err := openAESGCM(masterKey, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", errors.New("凭证解密失败：主密钥不匹配或数据已损坏")
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("凭证解密失败：数据已损坏")
+	}
+
+	return string(plaintext), nil
+}
+
+// sealAESGCM AES-GCM 加密，输出为 nonce + 密文
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM AES-GCM 解密，输入为 nonce + 密文
+func openAESGCM(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度不足")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
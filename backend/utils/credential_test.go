@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"ai-chat-backend/config"
+)
+
+var (
+	testKey1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testKey2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+// useCredentialKeys 按测试配置加载主密钥，替换 InitCredentialKeys 的结果
+func useCredentialKeys(t *testing.T, masterKeys, activeID string) {
+	t.Helper()
+	keys, loadedActiveID, err := loadCredentialKeys(&config.Config{
+		CredentialMasterKeys:  masterKeys,
+		CredentialActiveKeyID: activeID,
+	})
+	if err != nil {
+		t.Fatalf("loadCredentialKeys: %v", err)
+	}
+	credentialKeysOnce.Do(func() {})
+	credentialKeys, activeKeyID, credentialKeysErr = keys, loadedActiveID, nil
+}
+
+func TestCredentialRoundTrip(t *testing.T) {
+	useCredentialKeys(t, "k1:"+testKey1, "")
+
+	encrypted, err := EncryptCredential("sk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedCredential(encrypted) || strings.Contains(encrypted, "sk-secret") {
+		t.Fatalf("凭证未加密: %s", encrypted)
+	}
+	if keyID := CredentialKeyID(encrypted); keyID != "k1" {
+		t.Fatalf("CredentialKeyID = %q, want k1", keyID)
+	}
+
+	decrypted, err := DecryptCredential(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "sk-secret" {
+		t.Fatalf("DecryptCredential = %q, want sk-secret", decrypted)
+	}
+}
+
+func TestCredentialRotatedActiveKey(t *testing.T) {
+	useCredentialKeys(t, "k1:"+testKey1, "")
+	encrypted, err := EncryptCredential("sk-old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 轮换：加入新密钥并设为当前密钥，旧密文仍可用旧密钥解密
+	useCredentialKeys(t, "k1:"+testKey1+",k2:"+testKey2, "k2")
+	decrypted, err := DecryptCredential(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "sk-old" {
+		t.Fatalf("DecryptCredential = %q, want sk-old", decrypted)
+	}
+
+	reencrypted, err := EncryptCredential(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID := CredentialKeyID(reencrypted); keyID != "k2" {
+		t.Fatalf("CredentialKeyID = %q, want k2", keyID)
+	}
+}
+
+func TestCredentialUnknownKeyID(t *testing.T) {
+	useCredentialKeys(t, "k1:"+testKey1, "")
+	encrypted, err := EncryptCredential("sk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useCredentialKeys(t, "k2:"+testKey2, "")
+	if _, err := DecryptCredential(encrypted); err == nil {
+		t.Fatal("主密钥未配置时应解密失败")
+	}
+}
+
+func TestCredentialTampered(t *testing.T) {
+	useCredentialKeys(t, "k1:"+testKey1, "")
+	encrypted, err := EncryptCredential("sk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(encrypted, credentialPrefix), ":")
+	for i := 1; i < len(parts); i++ {
+		data, err := base64.StdEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)-1] ^= 0x01
+
+		tampered := append([]string(nil), parts...)
+		tampered[i] = base64.StdEncoding.EncodeToString(data)
+		if _, err := DecryptCredential(credentialPrefix + strings.Join(tampered, ":")); err == nil {
+			t.Fatalf("篡改第 %d 段后应解密失败", i)
+		}
+	}
+
+	// 主密钥 ID 参与数据密钥的认证，改写 ID 也无法解密
+	useCredentialKeys(t, "k1:"+testKey1+",k2:"+testKey1, "")
+	if _, err := DecryptCredential(credentialPrefix + "k2:" + parts[1] + ":" + parts[2]); err == nil {
+		t.Fatal("改写主密钥 ID 后应解密失败")
+	}
+}
+
+func TestCredentialLegacyPlaintext(t *testing.T) {
+	useCredentialKeys(t, "k1:"+testKey1, "")
+
+	decrypted, err := DecryptCredential("sk-legacy-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "sk-legacy-plaintext" {
+		t.Fatalf("DecryptCredential = %q, want sk-legacy-plaintext", decrypted)
+	}
+	if IsEncryptedCredential("sk-legacy-plaintext") || CredentialKeyID("sk-legacy-plaintext") != "" {
+		t.Fatal("明文凭证不应被识别为密文")
+	}
+}
+
+func TestLoadCredentialKeysRequiresKey(t *testing.T) {
+	if _, _, err := loadCredentialKeys(&config.Config{}); err == nil {
+		t.Fatal("未配置主密钥时应返回错误")
+	}
+	if _, _, err := loadCredentialKeys(&config.Config{CredentialMasterKeys: "k1:" + testKey1, CredentialActiveKeyID: "k2"}); err == nil {
+		t.Fatal("当前主密钥不存在时应返回错误")
+	}
+}
@@ -1,7 +1,7 @@
 import React, { useState, useEffect } from 'react';
-import { Plus, Edit, Trash2, Key, Check, X } from 'lucide-react';
+import { Plus, Edit, Trash2, Key, Check, X, Zap } from 'lucide-react';
 import { apiConfigService } from '../services/apiConfigService';
-import { APIConfig } from '../types';
+import { APIConfig, AuthSpec } from '../types';
 
 const APIConfigs: React.FC = () => {
   const [configs, setConfigs] = useState<APIConfig[]>([]);
@@ -11,8 +11,9 @@ const APIConfigs: React.FC = () => {
     name: '',
     api_type: 'openrouter',
     endpoint_url: 'https://openrouter.ai/api/v1/chat/completions',
-    auth_type: 'bearer' as 'bearer' | 'api_key' | 'custom',
+    auth_type: 'bearer' as 'bearer' | 'api_key' | 'custom' | 'none',
     credentials: '',
+    auth_spec: '',
     is_active: true,
   });
 
@@ -33,11 +34,22 @@ const APIConfigs: React.FC = () => {
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
 
+    let authSpec: AuthSpec = {};
+    if (formData.auth_type === 'custom' && formData.auth_spec.trim()) {
+      try {
+        authSpec = JSON.parse(formData.auth_spec);
+      } catch {
+        alert('自定义认证配置不是有效的 JSON');
+        return;
+      }
+    }
+    const data = { ...formData, auth_spec: authSpec };
+
     try {
       if (editingConfig) {
-        await apiConfigService.updateConfig(editingConfig.id, formData);
+        await apiConfigService.updateConfig(editingConfig.id, data);
       } else {
-        await apiConfigService.createConfig(formData);
+        await apiConfigService.createConfig(data);
       }
       setShowModal(false);
       resetForm();
@@ -55,6 +67,7 @@ const APIConfigs: React.FC = () => {
       endpoint_url: config.endpoint_url,
       auth_type: config.auth_type,
       credentials: '', // 不显示已保存的密钥
+      auth_spec: config.auth_spec && Object.keys(config.auth_spec).length > 0 ? JSON.stringify(config.auth_spec, null, 2) : '',
       is_active: config.is_active,
     });
     setShowModal(true);
@@ -73,6 +86,24 @@ const APIConfigs: React.FC = () => {
     }
   };
 
+  const handleTest = async (config: APIConfig) => {
+    const modelName = window.prompt('请输入用于测试的模型名称', config.api_type === 'anthropic' ? 'claude-3-5-haiku-latest' : 'gpt-4o-mini');
+    if (!modelName) {
+      return;
+    }
+
+    try {
+      const { data } = await apiConfigService.testConfig(config.id, modelName);
+      if (data.success) {
+        alert(`测试成功（${data.latency_ms} ms）\n回复: ${data.content}\nTokens: ${data.input_tokens} / ${data.output_tokens}`);
+      } else {
+        alert(`测试失败（步骤: ${data.stage}${data.status_code ? `，状态码: ${data.status_code}` : ''}）\n${data.error}${data.raw_response ? `\n\n${data.raw_response}` : ''}`);
+      }
+    } catch (error: any) {
+      alert('测试失败: ' + (error.message || '未知错误'));
+    }
+  };
+
   const resetForm = () => {
     setEditingConfig(null);
     setFormData({
@@ -81,6 +112,7 @@ const APIConfigs: React.FC = () => {
       endpoint_url: 'https://openrouter.ai/api/v1/chat/completions',
       auth_type: 'bearer',
       credentials: '',
+      auth_spec: '',
       is_active: true,
     });
   };
@@ -89,7 +121,8 @@ const APIConfigs: React.FC = () => {
     { value: 'openrouter', label: 'OpenRouter', url: 'https://openrouter.ai/api/v1/chat/completions' },
     { value: 'openai', label: 'OpenAI', url: 'https://api.openai.com/v1/chat/completions' },
     { value: 'anthropic', label: 'Anthropic Claude', url: 'https://api.anthropic.com/v1/messages' },
-    { value: 'google', label: 'Google Gemini', url: 'https://generativelanguage.googleapis.com/v1/models' },
+    { value: 'google', label: 'Google Gemini', url: 'https://generativelanguage.googleapis.com/v1beta/models' },
+    { value: 'ollama', label: 'Ollama (本地)', url: 'http://localhost:11434/api/chat' },
     { value: 'custom', label: '自定义', url: '' },
   ];
 
@@ -141,6 +174,13 @@ const APIConfigs: React.FC = () => {
                   </div>
                 </div>
                 <div className="flex space-x-2">
+                  <button
+                    onClick={() => handleTest(config)}
+                    className="text-gray-400 hover:text-green-600 transition-colors"
+                    title="测试配置"
+                  >
+                    <Zap size={18} />
+                  </button>
                   <button
                     onClick={() => handleEdit(config)}
                     className="text-gray-400 hover:text-blue-600 transition-colors"
@@ -274,16 +314,36 @@ const APIConfigs: React.FC = () => {
                   <option value="bearer">Bearer Token</option>
                   <option value="api_key">API Key</option>
                   <option value="custom">自定义</option>
+                  <option value="none">无需认证</option>
                 </select>
               </div>
 
+              {formData.auth_type === 'custom' && (
+                <div>
+                  <label className="block text-sm font-medium text-gray-700 mb-2">
+                    自定义认证配置 (JSON) *
+                  </label>
+                  <textarea
+                    required
+                    rows={6}
+                    value={formData.auth_spec}
+                    onChange={(e) => setFormData({ ...formData, auth_spec: e.target.value })}
+                    className="w-full px-4 py-2 border border-gray-300 rounded-lg font-mono text-sm focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                    placeholder={'{\n  "headers": {\n    "X-Access-Key": "{{credential.key}}",\n    "X-Signature": "{{signature}}"\n  },\n  "signing": { "algorithm": "hmac-sha256", "secret_field": "secret" }\n}'}
+                  />
+                  <p className="mt-1 text-xs text-gray-500">
+                    支持 {'{{credential}}'}、{'{{credential.字段}}'}、{'{{timestamp}}'}、{'{{signature}}'} 等变量；多字段凭证请在 API密钥 中填写 JSON 对象
+                  </p>
+                </div>
+              )}
+
               <div>
                 <label className="block text-sm font-medium text-gray-700 mb-2">
                   API密钥 {editingConfig ? '(留空则不修改)' : '*'}
                 </label>
                 <input
                   type="password"
-                  required={!editingConfig}
+                  required={!editingConfig && formData.auth_type !== 'none'}
                   value={formData.credentials}
                   onChange={(e) => setFormData({ ...formData, credentials: e.target.value })}
                   className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
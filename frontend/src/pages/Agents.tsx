@@ -35,12 +35,12 @@ const Agents: React.FC = () => {
     loadModels(selectedProvider);
   }, []);
 
-  // 当平台切换时重新加载模型
+  // 当平台切换时重新加载模型（Ollama 的模型列表取决于所选的 API 配置）
   useEffect(() => {
     if (showModal) {
       loadModels(selectedProvider);
     }
-  }, [selectedProvider, showModal]);
+  }, [selectedProvider, showModal, selectedProvider === 'ollama' ? formData.api_config_id : '']);
 
   // 点击外部关闭模型下拉框
   useEffect(() => {
@@ -79,7 +79,11 @@ const Agents: React.FC = () => {
   const loadModels = async (provider: string = 'openrouter') => {
     setLoadingModels(true);
     try {
-      const response = await modelService.getModels(provider);
+      if (provider === 'ollama' && !formData.api_config_id) {
+        setModels([]);
+        return;
+      }
+      const response = await modelService.getModels(provider, provider === 'ollama' ? formData.api_config_id : undefined);
       const modelList = response.data || [];
       setModels(modelList);
       
@@ -110,6 +114,9 @@ const Agents: React.FC = () => {
         max_tokens: formData.max_tokens,
       },
       is_public: formData.is_public,
+      // 表单暂不编辑备用配置和上下文策略，更新时保留原有设置
+      fallbacks: editingAgent?.fallbacks,
+      context_config: editingAgent?.context_config,
     };
 
     try {
@@ -384,6 +391,7 @@ const Agents: React.FC = () => {
                   <option value="anthropic">Anthropic (Claude系列)</option>
                   <option value="google">Google (Gemini系列)</option>
                   <option value="meta">Meta (Llama系列)</option>
+                  <option value="ollama">Ollama (本地模型，需先选择 API 配置)</option>
                 </select>
                 <p className="mt-1 text-xs text-gray-500">
                   选择不同的平台将显示该平台支持的模型
@@ -1,9 +1,25 @@
 import React, { useState, useEffect, useRef } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
 import ReactMarkdown from 'react-markdown';
-import { Send, Plus, Trash2, Bot, User as UserIcon } from 'lucide-react';
+import {
+  Send,
+  Plus,
+  Trash2,
+  Bot,
+  User as UserIcon,
+  RefreshCw,
+  Pencil,
+  ChevronLeft,
+  ChevronRight,
+  Square,
+  Image as ImageIcon,
+  Paperclip,
+  FileText,
+  X,
+} from 'lucide-react';
 import { conversationService } from '../services/conversationService';
 import { agentService } from '../services/agentService';
+import { fileService } from '../services/fileService';
 import { Conversation, Message, Agent } from '../types';
 
 const Chat: React.FC = () => {
@@ -13,6 +29,9 @@ const Chat: React.FC = () => {
   const [currentConversation, setCurrentConversation] = useState<Conversation | null>(null);
   const [messages, setMessages] = useState<Message[]>([]);
   const [input, setInput] = useState('');
+  const [images, setImages] = useState<{ data: string; mime_type: string }[]>([]);
+  const [documents, setDocuments] = useState<{ file_id: number; name: string }[]>([]);
+  const [uploading, setUploading] = useState(false);
   const [loading, setLoading] = useState(false);
   const [agents, setAgents] = useState<Agent[]>([]);
   const [showNewChatModal, setShowNewChatModal] = useState(false);
@@ -124,14 +143,50 @@ const Chat: React.FC = () => {
     }
   };
 
+  // 选择图片附件（以 base64 发送）
+  const handleSelectImages = (e: React.ChangeEvent<HTMLInputElement>) => {
+    Array.from(e.target.files || []).forEach((file) => {
+      const reader = new FileReader();
+      reader.onload = () => {
+        const data = (reader.result as string).split(',')[1];
+        setImages((prev) => [...prev, { data, mime_type: file.type }]);
+      };
+      reader.readAsDataURL(file);
+    });
+    e.target.value = '';
+  };
+
+  // 上传文档附件（服务端提取文本后注入到消息中）
+  const handleSelectDocuments = async (e: React.ChangeEvent<HTMLInputElement>) => {
+    const files = Array.from(e.target.files || []);
+    e.target.value = '';
+    setUploading(true);
+    try {
+      for (const file of files) {
+        const response = await fileService.upload(file);
+        setDocuments((prev) => [...prev, { file_id: response.data.id, name: response.data.name }]);
+      }
+    } catch (error: any) {
+      alert(error?.message || '上传文件失败');
+    } finally {
+      setUploading(false);
+    }
+  };
+
   const handleSendMessage = async (e: React.FormEvent) => {
     e.preventDefault();
-    if (!input.trim() || !conversationId || loading) {
+    if (!input.trim() || !conversationId || loading || uploading) {
       return;
     }
 
     const userMessage = input.trim();
+    const attachments = [
+      ...images.map((image) => ({ type: 'image', ...image })),
+      ...documents.map((doc) => ({ type: 'file', ...doc })),
+    ];
     setInput('');
+    setImages([]);
+    setDocuments([]);
     setLoading(true);
 
     // 立即显示用户消息
@@ -140,7 +195,7 @@ const Chat: React.FC = () => {
       conversation_id: parseInt(conversationId),
       role: 'user',
       content: userMessage,
-      attachments: [],
+      attachments,
       input_tokens: 0,
       output_tokens: 0,
       created_at: new Date().toISOString(),
@@ -148,7 +203,7 @@ const Chat: React.FC = () => {
     setMessages((prev) => [...prev, tempUserMessage]);
 
     try {
-      const response = await conversationService.sendMessage(parseInt(conversationId), userMessage);
+      const response = await conversationService.sendMessage(parseInt(conversationId), userMessage, attachments);
       
       // 更新消息列表
       setMessages((prev) => {
@@ -167,6 +222,11 @@ const Chat: React.FC = () => {
         loadConversations();
       }
     } catch (error: any) {
+      // 生成被取消（499）时用户消息已保存，重新加载消息列表
+      if (error?.code === 499) {
+        loadMessages(parseInt(conversationId));
+        return;
+      }
       console.error('发送消息失败:', error);
       alert('发送消息失败: ' + (error.message || '未知错误'));
       // 移除临时消息
@@ -176,6 +236,78 @@ const Chat: React.FC = () => {
     }
   };
 
+  // 停止生成
+  const handleCancel = async () => {
+    if (!conversationId) {
+      return;
+    }
+
+    try {
+      await conversationService.cancelGeneration(parseInt(conversationId));
+    } catch (error) {
+      console.error('停止生成失败:', error);
+    }
+  };
+
+  // 重新生成AI回复（作为新分支）
+  const handleRegenerate = async (message: Message) => {
+    if (!conversationId || loading) {
+      return;
+    }
+
+    setLoading(true);
+    try {
+      await conversationService.regenerateMessage(message.id);
+      loadMessages(parseInt(conversationId));
+      loadConversation(parseInt(conversationId));
+    } catch (error: any) {
+      console.error('重新生成失败:', error);
+      alert('重新生成失败: ' + (error.message || '未知错误'));
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // 编辑用户消息（作为新分支保存并重新生成回复）
+  const handleEditMessage = async (message: Message) => {
+    if (!conversationId || loading) {
+      return;
+    }
+
+    const content = window.prompt('编辑消息', message.content);
+    if (content === null || !content.trim() || content.trim() === message.content) {
+      return;
+    }
+
+    setLoading(true);
+    try {
+      await conversationService.editMessage(message.id, content.trim());
+      loadMessages(parseInt(conversationId));
+      loadConversation(parseInt(conversationId));
+    } catch (error: any) {
+      console.error('编辑消息失败:', error);
+      alert('编辑消息失败: ' + (error.message || '未知错误'));
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // 切换到相邻的兄弟分支
+  const handleSwitchBranch = async (message: Message, offset: number) => {
+    const siblingIds = message.sibling_ids || [];
+    const target = siblingIds[(message.sibling_index || 0) + offset];
+    if (!target || loading) {
+      return;
+    }
+
+    try {
+      const response = await conversationService.selectBranch(target);
+      setMessages(response.data || []);
+    } catch (error) {
+      console.error('切换分支失败:', error);
+    }
+  };
+
   return (
     <div className="flex h-full">
       {/* 对话列表侧边栏 */}
@@ -265,11 +397,80 @@ const Chat: React.FC = () => {
                       <div className="markdown-body">
                         <ReactMarkdown>{message.content}</ReactMarkdown>
                       </div>
-                      {message.role === 'assistant' && (
-                        <div className="mt-2 text-xs text-gray-400">
-                          {message.input_tokens + message.output_tokens} tokens
+                      {message.attachments?.some((a) => a.type === 'file') && (
+                        <div className="mt-2 flex flex-wrap gap-2">
+                          {message.attachments
+                            .filter((a) => a.type === 'file')
+                            .map((a, i) => (
+                              <span key={i} className="flex items-center text-xs opacity-80">
+                                <FileText size={14} className="mr-1" />
+                                {a.name}
+                              </span>
+                            ))}
                         </div>
                       )}
+                      {message.attachments?.some((a) => a.type === 'image' && (a.url || a.data)) && (
+                        <div className="mt-2 flex flex-wrap gap-2">
+                          {message.attachments
+                            .filter((a) => a.type === 'image' && (a.url || a.data))
+                            .map((a, i) => (
+                              <img
+                                key={i}
+                                src={a.url || `data:${a.mime_type};base64,${a.data}`}
+                                alt=""
+                                className="max-h-40 rounded"
+                              />
+                            ))}
+                        </div>
+                      )}
+                      <div
+                        className={`mt-2 flex items-center space-x-2 text-xs ${
+                          message.role === 'user' ? 'text-blue-100' : 'text-gray-400'
+                        }`}
+                      >
+                        {(message.sibling_count || 0) > 1 && (
+                          <span className="flex items-center">
+                            <button
+                              onClick={() => handleSwitchBranch(message, -1)}
+                              disabled={loading || (message.sibling_index || 0) === 0}
+                              className="disabled:opacity-40"
+                            >
+                              <ChevronLeft size={14} />
+                            </button>
+                            {(message.sibling_index || 0) + 1}/{message.sibling_count}
+                            <button
+                              onClick={() => handleSwitchBranch(message, 1)}
+                              disabled={loading || (message.sibling_index || 0) + 1 >= (message.sibling_count || 0)}
+                              className="disabled:opacity-40"
+                            >
+                              <ChevronRight size={14} />
+                            </button>
+                          </span>
+                        )}
+                        {message.role === 'assistant' && (
+                          <>
+                            <span>{message.input_tokens + message.output_tokens} tokens</span>
+                            <button
+                              onClick={() => handleRegenerate(message)}
+                              disabled={loading}
+                              title="重新生成"
+                              className="hover:text-gray-600 disabled:opacity-40"
+                            >
+                              <RefreshCw size={14} />
+                            </button>
+                          </>
+                        )}
+                        {message.role === 'user' && (
+                          <button
+                            onClick={() => handleEditMessage(message)}
+                            disabled={loading}
+                            title="编辑"
+                            className="hover:text-white disabled:opacity-40"
+                          >
+                            <Pencil size={14} />
+                          </button>
+                        )}
+                      </div>
                     </div>
                   </div>
                 </div>
@@ -295,7 +496,62 @@ const Chat: React.FC = () => {
 
             {/* 输入框 */}
             <div className="bg-white border-t px-6 py-4">
+              {images.length > 0 && (
+                <div className="mb-3 flex flex-wrap gap-2">
+                  {images.map((image, i) => (
+                    <div key={i} className="relative">
+                      <img
+                        src={`data:${image.mime_type};base64,${image.data}`}
+                        alt=""
+                        className="h-16 w-16 object-cover rounded"
+                      />
+                      <button
+                        type="button"
+                        onClick={() => setImages((prev) => prev.filter((_, j) => j !== i))}
+                        className="absolute -top-2 -right-2 bg-gray-700 text-white rounded-full p-0.5"
+                      >
+                        <X size={12} />
+                      </button>
+                    </div>
+                  ))}
+                </div>
+              )}
+              {documents.length > 0 && (
+                <div className="mb-3 flex flex-wrap gap-2">
+                  {documents.map((doc, i) => (
+                    <span key={i} className="flex items-center px-2 py-1 text-sm bg-gray-100 rounded">
+                      <FileText size={14} className="mr-1" />
+                      {doc.name}
+                      <button
+                        type="button"
+                        onClick={() => setDocuments((prev) => prev.filter((_, j) => j !== i))}
+                        className="ml-1 text-gray-500"
+                      >
+                        <X size={12} />
+                      </button>
+                    </span>
+                  ))}
+                </div>
+              )}
               <form onSubmit={handleSendMessage} className="flex items-end space-x-4">
+                <label className="px-3 py-3 text-gray-500 hover:text-blue-600 cursor-pointer" title="添加图片">
+                  <ImageIcon size={20} />
+                  <input type="file" accept="image/*" multiple className="hidden" onChange={handleSelectImages} />
+                </label>
+                <label
+                  className={`px-3 py-3 text-gray-500 hover:text-blue-600 cursor-pointer ${uploading ? 'opacity-50' : ''}`}
+                  title="添加文档（PDF、DOCX、Markdown、CSV、TXT）"
+                >
+                  <Paperclip size={20} />
+                  <input
+                    type="file"
+                    accept=".pdf,.docx,.md,.markdown,.csv,.txt"
+                    multiple
+                    className="hidden"
+                    disabled={uploading}
+                    onChange={handleSelectDocuments}
+                  />
+                </label>
                 <textarea
                   value={input}
                   onChange={(e) => setInput(e.target.value)}
@@ -310,13 +566,24 @@ const Chat: React.FC = () => {
                   rows={3}
                   disabled={loading}
                 />
-                <button
-                  type="submit"
-                  disabled={loading || !input.trim()}
-                  className="px-6 py-3 bg-blue-600 text-white rounded-lg hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-blue-500 disabled:opacity-50 disabled:cursor-not-allowed transition-colors"
-                >
-                  <Send size={20} />
-                </button>
+                {loading ? (
+                  <button
+                    type="button"
+                    onClick={handleCancel}
+                    title="停止生成"
+                    className="px-6 py-3 bg-gray-600 text-white rounded-lg hover:bg-gray-700 focus:outline-none focus:ring-2 focus:ring-gray-500 transition-colors"
+                  >
+                    <Square size={20} />
+                  </button>
+                ) : (
+                  <button
+                    type="submit"
+                    disabled={!input.trim()}
+                    className="px-6 py-3 bg-blue-600 text-white rounded-lg hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-blue-500 disabled:opacity-50 disabled:cursor-not-allowed transition-colors"
+                  >
+                    <Send size={20} />
+                  </button>
+                )}
               </form>
             </div>
           </>
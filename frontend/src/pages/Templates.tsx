@@ -2,7 +2,8 @@ import React, { useState, useEffect } from 'react';
 import { Sparkles, ArrowRight, Tag, User, Check } from 'lucide-react';
 import { templateService, AgentTemplate, TemplateCategory } from '../services/templateService';
 import { apiConfigService } from '../services/apiConfigService';
-import { APIConfig } from '../types';
+import { knowledgeBaseService } from '../services/knowledgeBaseService';
+import { APIConfig, KnowledgeBase } from '../types';
 import { useNavigate } from 'react-router-dom';
 
 const Templates: React.FC = () => {
@@ -12,6 +13,7 @@ const Templates: React.FC = () => {
   const [selectedCategory, setSelectedCategory] = useState<string>('');
   const [selectedTemplate, setSelectedTemplate] = useState<AgentTemplate | null>(null);
   const [apiConfigs, setApiConfigs] = useState<APIConfig[]>([]);
+  const [knowledgeBases, setKnowledgeBases] = useState<KnowledgeBase[]>([]);
   const [showConfigModal, setShowConfigModal] = useState(false);
   
   // 表单数据
@@ -27,6 +29,7 @@ const Templates: React.FC = () => {
     loadTemplates();
     loadCategories();
     loadApiConfigs();
+    loadKnowledgeBases();
   }, []);
 
   useEffect(() => {
@@ -64,6 +67,23 @@ const Templates: React.FC = () => {
     }
   };
 
+  const loadKnowledgeBases = async () => {
+    try {
+      const response = await knowledgeBaseService.getKnowledgeBases();
+      setKnowledgeBases(response.data || []);
+    } catch (error) {
+      console.error('加载知识库失败:', error);
+    }
+  };
+
+  // 知识库选项来自当前用户的知识库，其余参数使用模板定义的选项
+  const paramOptions = (param: any) => {
+    if (param.name === 'knowledge_base_ids') {
+      return knowledgeBases.map(kb => ({ label: kb.name, value: kb.id }));
+    }
+    return param.options;
+  };
+
   const handleSelectTemplate = (template: AgentTemplate) => {
     setSelectedTemplate(template);
     
@@ -142,7 +162,7 @@ const Templates: React.FC = () => {
       case 'multiselect':
         return (
           <div className="space-y-2">
-            {param.options?.map((option: any) => (
+            {paramOptions(param)?.map((option: any) => (
               <label key={option.value} className="flex items-center">
                 <input
                   type="checkbox"
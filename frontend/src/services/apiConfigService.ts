@@ -1,5 +1,5 @@
 import { api } from '../utils/api';
-import { APIConfig } from '../types';
+import { APIConfig, APIConfigTestResult } from '../types';
 
 export const apiConfigService = {
   // 获取API配置列表
@@ -26,5 +26,15 @@ export const apiConfigService = {
   async deleteConfig(id: number): Promise<void> {
     return api.delete(`/configs/${id}`);
   },
+
+  // 测试已保存的API配置
+  async testConfig(id: number, modelName: string, prompt?: string): Promise<{ data: APIConfigTestResult }> {
+    return api.post(`/configs/${id}/test`, { model_name: modelName, prompt });
+  },
+
+  // 测试未保存的API配置
+  async testUnsavedConfig(config: Partial<APIConfig> & { credentials?: string }, modelName: string, prompt?: string): Promise<{ data: APIConfigTestResult }> {
+    return api.post('/configs/test', { model_name: modelName, prompt, config });
+  },
 };
 
@@ -34,13 +34,47 @@ export const conversationService = {
   },
 
   // 发送消息
-  async sendMessage(conversationId: number, content: string): Promise<{ data: any }> {
-    return api.post(`/conversations/${conversationId}/messages`, { content });
+  async sendMessage(conversationId: number, content: string, attachments?: any[]): Promise<{ data: any }> {
+    return api.post(`/conversations/${conversationId}/messages`, { content, attachments });
+  },
+
+  // 连接对话最近一次的流式生成（断线后 EventSource 会携带 Last-Event-ID 自动重连并补齐事件）
+  async streamEvents(conversationId: number): Promise<EventSource> {
+    const { data } = await api.post<{ data: { ticket: string } }>(`/conversations/${conversationId}/stream/ticket`);
+    return api.stream(`/conversations/${conversationId}/stream`, data.ticket);
+  },
+
+  // 取消正在进行的生成
+  async cancelGeneration(conversationId: number): Promise<void> {
+    return api.post(`/conversations/${conversationId}/cancel`);
   },
 
   // 删除消息
   async deleteMessage(messageId: number): Promise<void> {
     return api.delete(`/messages/${messageId}`);
   },
+
+  // 删除消息及其后续的所有消息（整个分支）
+  async deleteBranch(messageId: number): Promise<void> {
+    return api.delete(`/messages/${messageId}/branch`);
+  },
+
+  // 重新生成AI回复
+  async regenerateMessage(messageId: number): Promise<{ data: { assistant_message: Message } }> {
+    return api.post(`/messages/${messageId}/regenerate`);
+  },
+
+  // 编辑用户消息并重新生成回复
+  async editMessage(
+    messageId: number,
+    content: string
+  ): Promise<{ data: { user_message: Message; assistant_message: Message } }> {
+    return api.put(`/messages/${messageId}`, { content });
+  },
+
+  // 切换到包含该消息的分支
+  async selectBranch(messageId: number): Promise<{ data: Message[] }> {
+    return api.post(`/messages/${messageId}/select`);
+  },
 };
 
@@ -0,0 +1,24 @@
+import { api } from '../utils/api';
+import { UploadedFile, FileList } from '../types';
+
+export const fileService = {
+  // 上传文件（文档会在服务端提取文本，可作为消息附件发送）
+  async upload(file: File): Promise<{ data: UploadedFile }> {
+    const formData = new FormData();
+    formData.append('file', file);
+    return api.post('/files', formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 120000,
+    });
+  },
+
+  // 获取文件列表和存储空间使用情况
+  async getFiles(): Promise<{ data: FileList }> {
+    return api.get('/files');
+  },
+
+  // 删除文件
+  async deleteFile(id: number): Promise<void> {
+    return api.delete(`/files/${id}`);
+  },
+};
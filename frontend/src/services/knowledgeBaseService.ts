@@ -0,0 +1,63 @@
+import { api } from '../utils/api';
+import { KnowledgeBase, KnowledgeDocument, RetrievedChunk } from '../types';
+
+export interface KnowledgeBaseInput {
+  name: string;
+  description?: string;
+  api_config_id: number;
+  embedding_model: string;
+  chunk_size?: number;
+  chunk_overlap?: number;
+}
+
+export const knowledgeBaseService = {
+  // 获取知识库列表
+  async getKnowledgeBases(): Promise<{ data: KnowledgeBase[] }> {
+    return api.get('/knowledge-bases');
+  },
+
+  // 获取知识库详情（包含文档列表）
+  async getKnowledgeBase(id: number): Promise<{ data: KnowledgeBase }> {
+    return api.get(`/knowledge-bases/${id}`);
+  },
+
+  // 创建知识库
+  async createKnowledgeBase(data: KnowledgeBaseInput): Promise<{ data: KnowledgeBase }> {
+    return api.post('/knowledge-bases', data);
+  },
+
+  // 更新知识库
+  async updateKnowledgeBase(id: number, data: KnowledgeBaseInput): Promise<{ data: KnowledgeBase }> {
+    return api.put(`/knowledge-bases/${id}`, data);
+  },
+
+  // 删除知识库
+  async deleteKnowledgeBase(id: number): Promise<void> {
+    return api.delete(`/knowledge-bases/${id}`);
+  },
+
+  // 上传文档到知识库（后台建立索引，状态变为 ready 后可检索）
+  async uploadDocument(id: number, file: File): Promise<{ data: KnowledgeDocument }> {
+    const formData = new FormData();
+    formData.append('file', file);
+    return api.post(`/knowledge-bases/${id}/documents`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 120000,
+    });
+  },
+
+  // 将已上传的文件加入知识库
+  async addDocument(id: number, fileId: number): Promise<{ data: KnowledgeDocument }> {
+    return api.post(`/knowledge-bases/${id}/documents`, { file_id: fileId });
+  },
+
+  // 删除知识库中的文档
+  async deleteDocument(id: number, documentId: number): Promise<void> {
+    return api.delete(`/knowledge-bases/${id}/documents/${documentId}`);
+  },
+
+  // 检索测试
+  async search(id: number, query: string, topK?: number): Promise<{ data: RetrievedChunk[] }> {
+    return api.post(`/knowledge-bases/${id}/search`, { query, top_k: topK });
+  },
+};
@@ -13,8 +13,10 @@ export interface Model {
 
 export const modelService = {
   // 获取模型列表
-  getModels: (provider: string = 'openrouter'): Promise<{ data: Model[] }> => {
-    return api.get(`/models?provider=${provider}`);
+  // provider 为 ollama 时需要传入 configId，从对应的 Ollama 服务获取本地模型
+  getModels: (provider: string = 'openrouter', configId?: string): Promise<{ data: Model[] }> => {
+    const query = configId ? `&config_id=${configId}` : '';
+    return api.get(`/models?provider=${provider}${query}`);
   },
 };
 
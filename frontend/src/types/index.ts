@@ -8,12 +8,26 @@ export interface User {
   last_login_at?: string;
 }
 
+// 自定义认证配置，值支持 {{credential}}、{{credential.字段}}、{{timestamp}}、{{signature}} 等变量
+export interface AuthSpec {
+  headers?: Record<string, string>;
+  query_params?: Record<string, string>;
+  signing?: {
+    algorithm?: string;
+    secret_field?: string;
+    string_to_sign?: string;
+    encoding?: string;
+    timestamp_format?: string;
+  };
+}
+
 export interface APIConfig {
   id: number;
   name: string;
   api_type: string;
   endpoint_url: string;
-  auth_type: 'bearer' | 'api_key' | 'custom';
+  auth_type: 'bearer' | 'api_key' | 'custom' | 'none';
+  auth_spec?: AuthSpec;
   field_mapping?: {
     request_mapping?: Record<string, any>;
     response_mapping?: Record<string, any>;
@@ -22,6 +36,20 @@ export interface APIConfig {
   created_at: string;
 }
 
+export interface APIConfigTestResult {
+  success: boolean;
+  stage?: string;
+  error?: string;
+  request_url?: string;
+  status_code?: number;
+  latency_ms: number;
+  raw_response?: string;
+  content: string;
+  input_tokens: number;
+  output_tokens: number;
+  finish_reason?: string;
+}
+
 export interface ModelParams {
   temperature?: number;
   max_tokens?: number;
@@ -31,6 +59,23 @@ export interface ModelParams {
   presence_penalty?: number;
 }
 
+export interface AgentFallback {
+  api_config_id?: number;
+  model_name?: string;
+}
+
+export interface ContextConfig {
+  strategy?: 'none' | 'sliding_window' | 'keep_first_last' | 'summarize';
+  keep_first?: number;
+  keep_last?: number;
+}
+
+export interface RetrievalConfig {
+  knowledge_base_ids?: number[];
+  top_k?: number;
+  min_score?: number;
+}
+
 export interface Agent {
   id: number;
   name: string;
@@ -41,10 +86,16 @@ export interface Agent {
   model_name: string;
   model_params?: ModelParams;
   tools?: string[];
+  fallbacks?: AgentFallback[];
+  context_config?: ContextConfig;
+  retrieval?: RetrievalConfig;
   is_public: boolean;
   usage_count: number;
   created_at: string;
   api_config?: APIConfig;
+  workflow_type?: 'simple' | 'template' | 'visual' | 'code';
+  template_id?: string;
+  custom_code?: string;
 }
 
 export interface Conversation {
@@ -54,6 +105,7 @@ export interface Conversation {
   status: 'active' | 'archived' | 'deleted';
   total_tokens: number;
   total_cost: number;
+  active_message_id?: number | null;
   created_at: string;
   updated_at: string;
   agent?: Agent;
@@ -63,6 +115,7 @@ export interface Conversation {
 export interface Message {
   id: number;
   conversation_id: number;
+  parent_id?: number | null;
   role: 'user' | 'assistant' | 'system';
   content: string;
   attachments?: any[];
@@ -70,6 +123,109 @@ export interface Message {
   output_tokens: number;
   metadata?: Record<string, any>;
   created_at: string;
+  // 分支信息：同一父消息下的兄弟消息
+  sibling_ids?: number[];
+  sibling_index?: number;
+  sibling_count?: number;
+}
+
+export interface UploadedFile {
+  id: number;
+  name: string;
+  mime_type: string;
+  kind: 'image' | 'document';
+  size: number;
+  text_length: number;
+  created_at: string;
+}
+
+export interface FileList {
+  files: UploadedFile[];
+  used_bytes: number;
+  quota: number;
+}
+
+export interface KnowledgeDocument {
+  id: number;
+  knowledge_base_id: number;
+  file_id?: number | null;
+  name: string;
+  status: 'processing' | 'ready' | 'failed';
+  error?: string;
+  chunk_count: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface KnowledgeBase {
+  id: number;
+  name: string;
+  description?: string;
+  api_config_id?: number | null;
+  embedding_model: string;
+  dimensions: number;
+  chunk_size: number;
+  chunk_overlap: number;
+  document_count: number;
+  chunk_count: number;
+  documents?: KnowledgeDocument[];
+  created_at: string;
+  updated_at: string;
+}
+
+export interface RetrievedChunk {
+  chunk_id: number;
+  knowledge_base_id: number;
+  document_id: number;
+  document_name: string;
+  chunk_index: number;
+  content: string;
+  score: number;
+}
+
+export interface Citation {
+  index: number;
+  knowledge_base_id: number;
+  document_id: number;
+  document_name: string;
+  chunk_id: number;
+  chunk_index: number;
+  score: number;
+  snippet: string;
+}
+
+export interface ReActStep {
+  iteration: number;
+  thought?: string;
+  action?: string;
+  action_input?: string;
+  observation?: string;
+  error?: string;
+  input_tokens: number;
+  output_tokens: number;
+}
+
+export interface ReActTrace {
+  steps: ReActStep[];
+  max_iterations: number;
+  token_budget: number;
+  stop_reason: 'final_answer' | 'max_iterations' | 'token_budget';
+}
+
+export interface ScriptCall {
+  type: 'model' | 'tool';
+  name: string;
+  input_tokens?: number;
+  output_tokens?: number;
+  error?: string;
+  latency_ms: number;
+}
+
+export interface ScriptTrace {
+  logs: string[];
+  calls: ScriptCall[];
+  steps: number;
+  duration_ms: number;
 }
 
 export interface UsageStats {
@@ -60,13 +60,9 @@ class ApiClient {
     return this.client.delete(url, requestConfig);
   }
 
-  // 流式请求
-  stream(url: string, data?: any): EventSource {
-    const token = localStorage.getItem(config.tokenKey);
-    const eventSource = new EventSource(
-      `${config.apiBaseUrl}${url}?token=${token}`
-    );
-    return eventSource;
+  // 流式请求（EventSource 无法设置请求头，使用只对本次生成有效的流票据认证）
+  stream(url: string, ticket: string): EventSource {
+    return new EventSource(`${config.apiBaseUrl}${url}?ticket=${encodeURIComponent(ticket)}`);
   }
 }
 